@@ -0,0 +1,185 @@
+// 部署前检查配置文件, 有问题时逐条输出 (带行列) 并以非 0 退出
+//
+//   confcheck -schema docs/config/schema.json conf/prod.json      用 JSON Schema 检查, schema 见 setting-doc
+//   confcheck -pkg qing/app/conf conf/prod.json                   用 -pkg 在 init() 中注册 (见 setting.Register) 的 confObj 检查
+//   confcheck -pkg qing/app/conf                                  同上, 检查每个 confObj 自己的配置文件 (FromFile)
+//
+// 配置文件的解析和服务启动时相同: include, profile 层 (-profile) 和 interpolate
+// -print 在标准输出打印合并后生效的配置 (JSON), 其中的密钥引用不会被解析
+//
+// -pkg 时, confcheck 在当前目录下生成一个临时的 main 包, 匿名引用 -pkg 中的包, 用 go run 执行它, 结束后删除; 当前目录需要位于
+// 能引用这些包的 module 中
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"qing/go-helper/error"
+	"qing/go-helper/setting"
+	"strings"
+	"text/template"
+)
+
+var mainTemplate = template.Must(template.New("main").Parse(`// Code generated by confcheck. DO NOT EDIT.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"qing/go-helper/setting"
+{{range .Pkgs}}
+	_ {{printf "%q" .}}{{end}}
+)
+
+func main() {
+	setting.SetProfile({{printf "%q" .Profile}})
+	bs, err := json.Marshal(setting.CheckRegistered({{.Strict}},{{range .Paths}} {{printf "%q" .}},{{end}}))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	os.Stdout.Write(bs)
+}
+`))
+
+func main() {
+	schema := flag.String("schema", "", "JSON Schema file to check against, see setting-doc")
+	pkg := flag.String("pkg", "", "comma separated import paths of the packages which register conf structs in init()")
+	profile := flag.String("profile", "", "profile of the config files, default to "+setting.ProfileEnv)
+	strict := flag.Bool("strict", false, "report keys which are not defined")
+	printConfig := flag.Bool("print", false, "print the effective merged config")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: confcheck [-schema file | -pkg importpath[,importpath]] [-profile p] [-strict] [-print] [file ...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	results, err := check(*schema, *pkg, *profile, *strict, flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if !report(results, *printConfig) {
+		os.Exit(1)
+	}
+}
+
+func check(schemaFile, pkgs, profile string, strict bool, paths []string) ([]setting.FileCheck, error) {
+	if (schemaFile == "") == (pkgs == "") {
+		flag.Usage()
+		return nil, errPkg.Fail("one of schema and pkg is required.", nil)
+	}
+	if pkgs != "" {
+		return checkRegistered(pkgs, profile, strict, paths)
+	}
+	if len(paths) == 0 {
+		flag.Usage()
+		return nil, errPkg.Fail("config files are required.", nil)
+	}
+
+	content, err := ioutil.ReadFile(schemaFile)
+	if err != nil {
+		return nil, errPkg.FailBy(err, "read schema file fail.", errPkg.Fields{"file": schemaFile})
+	}
+	schema := new(setting.JSONSchema)
+	if err = json.Unmarshal(content, schema); err != nil {
+		return nil, errPkg.FailBy(err, "unmarshal schema fail.", errPkg.Fields{"file": schemaFile})
+	}
+
+	setting.SetProfile(profile)
+	results := make([]setting.FileCheck, 0, len(paths))
+	for _, path := range paths {
+		tree, problems := setting.CheckFile(path, schema, strict)
+		result := setting.FileCheck{Path: path, Problems: problems}
+		if tree != nil {
+			result.Config = tree.Node
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// 生成并执行临时的 main 包, 它输出 setting.CheckRegistered 的结果
+func checkRegistered(pkgs, profile string, strict bool, paths []string) ([]setting.FileCheck, error) {
+	names := make([]string, 0)
+	for _, pkg := range strings.Split(pkgs, ",") {
+		if pkg = strings.TrimSpace(pkg); pkg != "" {
+			names = append(names, pkg)
+		}
+	}
+	abs := make([]string, 0, len(paths))
+	for _, path := range paths {
+		p, err := filepath.Abs(path)
+		if err != nil {
+			return nil, errPkg.FailBy(err, "get absolute path of config file fail.", errPkg.Fields{"file": path})
+		}
+		abs = append(abs, p)
+	}
+
+	// 临时的 main 包必须位于 module 中, 才能引用 pkgs
+	dir, err := ioutil.TempDir(".", "confcheck-")
+	if err != nil {
+		return nil, errPkg.FailBy(err, "create temp dir fail.", nil)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := os.Create(filepath.Join(dir, "main.go"))
+	if err != nil {
+		return nil, errPkg.FailBy(err, "create temp main fail.", nil)
+	}
+	err = mainTemplate.Execute(f, map[string]interface{}{"Pkgs": names, "Profile": profile, "Strict": strict, "Paths": abs})
+	f.Close()
+	if err != nil {
+		return nil, errPkg.FailBy(err, "generate temp main fail.", nil)
+	}
+
+	cmd := exec.Command("go", "run", "./"+filepath.ToSlash(dir))
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, errPkg.FailBy(err, "run temp main fail.", errPkg.Fields{"pkg": names})
+	}
+	results := make([]setting.FileCheck, 0)
+	if err = json.Unmarshal(output, &results); err != nil {
+		return nil, errPkg.FailBy(err, "unmarshal check results fail.", nil)
+	}
+	if len(results) == 0 {
+		return nil, errPkg.Fail("no config file to check, register conf structs implementing setting.FromFile or give the files.",
+			errPkg.Fields{"pkg": names})
+	}
+	return results, nil
+}
+
+// 问题输出到标准错误, 合并后的配置输出到标准输出; 没有问题时返回 true
+func report(results []setting.FileCheck, printConfig bool) bool {
+	ok := true
+	for _, result := range results {
+		for _, p := range result.Problems {
+			fmt.Fprintln(os.Stderr, p.String())
+			ok = false
+		}
+		if printConfig && result.Config != nil {
+			bs, err := json.MarshalIndent(result.Config, "", "  ")
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				ok = false
+				continue
+			}
+			if len(results) > 1 {
+				fmt.Printf("// %s\n", result.Path)
+			}
+			fmt.Println(string(bs))
+		}
+	}
+	if ok {
+		fmt.Fprintf(os.Stderr, "%d config file(s) ok\n", len(results))
+	}
+	return ok
+}
@@ -0,0 +1,112 @@
+// 根据 confObj 的定义生成 JSON Schema, Markdown 文档和示例配置文件, 见 setting.GenerateDocs
+//
+//   package log
+//
+//   //go:generate go run qing/go-helper/cmd/setting-doc -types Conf -out ../docs/config
+//   type Conf struct {
+//     Level string `json:"level" default:"INFO" desc:"日志级别"`
+//   }
+//
+// go generate 在 confObj 所在包的目录下执行, setting-doc 在该目录下生成一个临时的 main 包, 引用 -pkg (默认为当前目录的包)
+// 中的 -types, 用 go run 执行它, 结束后删除
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"qing/go-helper/error"
+	"strings"
+	"text/template"
+)
+
+var mainTemplate = template.Must(template.New("main").Parse(`// Code generated by setting-doc. DO NOT EDIT.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"qing/go-helper/setting"
+
+	target {{printf "%q" .Pkg}}
+)
+
+func main() {
+	err := setting.GenerateDocs({{printf "%q" .Out}},{{range .Types}}
+		new(target.{{.}}),{{end}}
+	)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+`))
+
+func main() {
+	pkg := flag.String("pkg", "", "import path of the package of the conf structs, default to the package in the working directory")
+	types := flag.String("types", "", "comma separated conf struct names, required")
+	out := flag.String("out", "docs", "output dir")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: setting-doc [-pkg importpath] -types Conf[,Other] [-out dir]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(*pkg, *types, *out); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(pkg, types, out string) error {
+	names := make([]string, 0)
+	for _, name := range strings.Split(types, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		flag.Usage()
+		return errPkg.Fail("types is required.", nil)
+	}
+
+	if pkg == "" {
+		output, err := exec.Command("go", "list", "-f", "{{.ImportPath}}", ".").Output()
+		if err != nil {
+			return errPkg.FailBy(err, "find the package in the working directory fail.", nil)
+		}
+		pkg = strings.TrimSpace(string(output))
+	}
+	out, err := filepath.Abs(out)
+	if err != nil {
+		return errPkg.FailBy(err, "get absolute path of out dir fail.", errPkg.Fields{"out": out})
+	}
+
+	// 临时的 main 包必须位于 module 中, 才能引用 pkg
+	dir, err := ioutil.TempDir(".", "setting-doc-")
+	if err != nil {
+		return errPkg.FailBy(err, "create temp dir fail.", nil)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := os.Create(filepath.Join(dir, "main.go"))
+	if err != nil {
+		return errPkg.FailBy(err, "create temp main fail.", nil)
+	}
+	err = mainTemplate.Execute(f, map[string]interface{}{"Pkg": pkg, "Out": out, "Types": names})
+	f.Close()
+	if err != nil {
+		return errPkg.FailBy(err, "generate temp main fail.", nil)
+	}
+
+	cmd := exec.Command("go", "run", "./"+filepath.ToSlash(dir))
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err = cmd.Run(); err != nil {
+		return errPkg.FailBy(err, "run temp main fail.", errPkg.Fields{"pkg": pkg, "types": names})
+	}
+	return nil
+}
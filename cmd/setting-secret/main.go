@@ -0,0 +1,92 @@
+// 加密 / 解密 setting 配置中的敏感值
+//
+//   setting-secret genkey                      生成一个 key (base64), 放到 SETTING_SECRET_KEY 或 SETTING_SECRET_KEY_FILE 指向的文件中
+//   setting-secret encrypt [value]             输出 enc:AES256-GCM:..., 写到配置文件中
+//   setting-secret decrypt [enc:AES256-GCM:...]
+//
+// 没有给出 value 时从标准输入读取 (去掉末尾的换行), 避免明文留在 shell 的历史中
+// key 默认来自环境变量 SETTING_SECRET_KEY 或 SETTING_SECRET_KEY_FILE, 也可以用 -key-file 指定
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"qing/go-helper/error"
+	"qing/go-helper/setting"
+	"strings"
+)
+
+func main() {
+	keyFile := flag.String("key-file", "", "file of the secret key, override env "+setting.SecretKeyEnv+" and "+setting.SecretKeyFileEnv)
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: setting-secret [-key-file file] genkey | encrypt [value] | decrypt [value]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(flag.Args(), *keyFile); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(args []string, keyFile string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		return errPkg.Fail("command is required.", nil)
+	}
+
+	if args[0] == "genkey" {
+		key, err := setting.GenerateSecretKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	}
+
+	if args[0] != "encrypt" && args[0] != "decrypt" {
+		flag.Usage()
+		return errPkg.Fail("unknown command.", errPkg.Fields{"command": args[0]})
+	}
+
+	if keyFile != "" {
+		os.Unsetenv(setting.SecretKeyEnv)
+		os.Setenv(setting.SecretKeyFileEnv, keyFile)
+	}
+	key, err := setting.SecretKey()
+	if err != nil {
+		return err
+	}
+
+	value, err := readValue(args[1:])
+	if err != nil {
+		return err
+	}
+
+	var result string
+	if args[0] == "encrypt" {
+		result, err = setting.EncryptSecret(value, key)
+	} else {
+		result, err = setting.DecryptSecret(value, key)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(result)
+	return nil
+}
+
+func readValue(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+	content, err := ioutil.ReadAll(bufio.NewReader(os.Stdin))
+	if err != nil {
+		return "", errPkg.FailBy(err, "read value from stdin fail.", nil)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
@@ -0,0 +1,109 @@
+package featureflag
+
+import (
+	"hash/fnv"
+	"qing/go-helper/error"
+	"qing/go-helper/setting"
+	"sync/atomic"
+	"time"
+)
+
+// 功能开关, 用配置灰度发布新功能, 修改配置即可生效, 不需要重新部署
+//
+//   flags, err := featureflag.Watch(nil, setting.WithFile("conf.json", "features"), setting.WithEnvPrefix("FEATURES"))
+//   if err != nil {
+//     panic(err.Error())
+//   }
+//   defer flags.Close()
+//
+//   if flags.EnabledFor("new-checkout", userID) {
+//     ...
+//   }
+//
+// 求值不加锁, 只读取原子替换的配置; 热加载时新的配置校验失败 (见 Config.Access), 继续使用上一次成功的配置
+// 不认识的 flag 总是关闭的
+type Flags struct {
+	// Load 得到的配置
+	current atomic.Value
+	// Watch 时最新的配置只从这里读取
+	value *setting.Value[Config]
+}
+
+// 从 opts 指定的源加载一次, 源的写法见 setting.Load
+func Load(opts ...setting.Option) (*Flags, error) {
+	conf, err := setting.Load[Config](opts...)
+	if err != nil {
+		return nil, errPkg.FailBy(err, "load feature flags fail.", nil)
+	}
+	f := new(Flags)
+	f.current.Store(conf)
+	return f, nil
+}
+
+// 同 Load, 并在配置变化时热加载, watchOpts 见 setting.WatchOptions, 可以为 nil
+func Watch(watchOpts *setting.WatchOptions, opts ...setting.Option) (*Flags, error) {
+	value, err := setting.LoadValue[Config](watchOpts, opts...)
+	if err != nil {
+		return nil, errPkg.FailBy(err, "load feature flags fail.", nil)
+	}
+	return &Flags{value: value}, nil
+}
+
+// 停止热加载, Load 返回的 Flags 什么也不做
+func (f *Flags) Close() error {
+	if f.value != nil {
+		return f.value.Close()
+	}
+	return nil
+}
+
+// 不区分用户的开关, 只有灰度为 100% 时才打开
+func (f *Flags) Enabled(name string) bool {
+	return f.EnabledAt(name, "", time.Now())
+}
+
+// key 通常是用户 ID, 用于 allow / deny 和灰度
+func (f *Flags) EnabledFor(name, key string) bool {
+	return f.EnabledAt(name, key, time.Now())
+}
+
+// 同 EnabledFor, 指定判断时间窗口使用的时间
+func (f *Flags) EnabledAt(name, key string, at time.Time) bool {
+	fl, ok := f.Config().compiled[name]
+	if !ok || !fl.enabled {
+		return false
+	}
+	if !fl.from.IsZero() && at.Before(fl.from) {
+		return false
+	}
+	if !fl.until.IsZero() && !at.Before(fl.until) {
+		return false
+	}
+	if key != "" && fl.deny[key] {
+		return false
+	}
+	if key != "" && fl.allow[key] {
+		return true
+	}
+	if fl.threshold >= buckets {
+		return true
+	}
+	return key != "" && bucket(name, key) < fl.threshold
+}
+
+// 当前的配置, 不要修改
+func (f *Flags) Config() *Config {
+	if f.value != nil {
+		return f.value.Get()
+	}
+	return f.current.Load().(*Config)
+}
+
+// 同一个 key 在不同 flag 中的哈希相互独立, 避免总是同一批用户先拿到新功能
+func bucket(name, key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return h.Sum32() % buckets
+}
@@ -0,0 +1,92 @@
+package featureflag
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"qing/go-helper/setting"
+	"testing"
+	"time"
+)
+
+func TestFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "featureflag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.json")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"features": {"flags": {
+		"beta": true,
+		"off": false,
+		"checkout": {"percentage": 30, "allow": ["vip"], "deny": ["banned"]},
+		"sale": {"from": "2024-11-11", "until": "2024-11-12"}
+	}}}`)
+
+	// 环境变量中的 flag 和文件中的合并, 同名时文件优先, 见 setting.PriorityFile
+	os.Setenv("FEATURES_FLAGS", "off=on,internal=0%;allow=alice")
+	defer os.Unsetenv("FEATURES_FLAGS")
+
+	flags, err := Watch(&setting.WatchOptions{Debounce: 10 * time.Millisecond},
+		setting.WithFile(path, "features"), setting.WithEnvPrefix("FEATURES"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer flags.Close()
+
+	assert.True(t, flags.Enabled("beta"))
+	assert.False(t, flags.Enabled("off"))
+	assert.False(t, flags.Enabled("unknown"))
+	assert.True(t, flags.EnabledFor("internal", "alice"))
+	assert.False(t, flags.EnabledFor("internal", "bob"))
+
+	// 灰度: 稳定, 并且比例接近配置
+	assert.False(t, flags.Enabled("checkout"))
+	assert.True(t, flags.EnabledFor("checkout", "vip"))
+	assert.False(t, flags.EnabledFor("checkout", "banned"))
+	enabled := 0
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		if flags.EnabledFor("checkout", key) {
+			enabled++
+		}
+		assert.Equal(t, flags.EnabledFor("checkout", key), flags.EnabledFor("checkout", key))
+	}
+	assert.InDelta(t, 3000, enabled, 300)
+
+	// 时间窗口, 默认是 model.CST
+	utc := func(s string) time.Time {
+		at, _ := time.Parse(time.RFC3339, s)
+		return at
+	}
+	assert.False(t, flags.EnabledAt("sale", "", utc("2024-11-10T15:59:59Z")))
+	assert.True(t, flags.EnabledAt("sale", "", utc("2024-11-10T16:00:00Z")))
+	assert.False(t, flags.EnabledAt("sale", "", utc("2024-11-11T16:00:00Z")))
+
+	// 热加载, 不合法的配置被忽略
+	write(`{"features": {"flags": {"beta": false}}}`)
+	deadline := time.Now().Add(3 * time.Second)
+	for flags.Enabled("beta") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, flags.Enabled("beta"))
+	assert.False(t, flags.EnabledFor("checkout", "vip"))
+	// 求值直接读取热加载的最新配置, 没有另外的拷贝
+	assert.True(t, flags.Config() == flags.value.Get())
+
+	write(`{"features": {"flags": {"beta": {"percentage": 200}}}}`)
+	assert.NotNil(t, flags.value.Reload())
+	assert.False(t, flags.Enabled("beta"))
+	assert.True(t, flags.EnabledFor("internal", "alice"))
+
+	static, err := Load(setting.WithFile(path, "features"), setting.WithSources(setting.OriginFile))
+	assert.Nil(t, static)
+	assert.NotNil(t, err)
+}
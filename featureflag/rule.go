@@ -0,0 +1,198 @@
+package featureflag
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"qing/go-helper/error"
+	"qing/go-helper/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// flag 的配置, 和其他配置一样来自 setting 的各个源 (配置文件, 环境变量, 配置中心), 见 Load
+//
+//   {
+//     "timezone": "Asia/Shanghai",
+//     "flags": {
+//       "new-checkout": {"percentage": 20, "allow": ["u1"], "deny": ["u2"]},
+//       "double-11": {"from": "2024-11-11 00:00:00", "until": "2024-11-12 00:00:00"},
+//       "beta": true
+//     }
+//   }
+type Config struct {
+	// 时间窗口使用的时区, 比如 "Asia/Shanghai", 默认 model.CST
+	Timezone string          `json:"timezone"`
+	Flags    map[string]Rule `json:"flags"`
+
+	// Access 时编译好的 flag, 求值时只读
+	compiled map[string]*flag
+}
+
+// 一个 flag 的规则, 按顺序判断:
+//   1. Enabled 为 false 时关闭
+//   2. 不在时间窗口 [From, Until) 内时关闭
+//   3. key 在 Deny 中时关闭, 在 Allow 中时打开
+//   4. 按 key 的哈希灰度 Percentage%, 同一个 key 的结果是稳定的; 没有 key 时只有 100% 才打开
+//
+// 除了对象, 还可以写作字符串, 以便通过环境变量或者 ini 配置, 各部分以 ; 分隔, 列表以 | 分隔:
+//   "on", "off", "20%", "20%;allow=u1|u2;deny=u3", "from=2024-11-11 00:00:00;until=2024-11-12 00:00:00"
+// 比如环境变量 FEATURES_FLAGS="new-checkout=20%;allow=u1,beta=on", 多个 flag 以 , 分隔, 见 setting 中 map 的写法
+type Rule struct {
+	// 对象形式中默认为 true
+	Enabled bool `json:"enabled"`
+	// 0 ~ 100, 支持小数, 比如 0.5; 不设置时为 100
+	Percentage *float64 `json:"percentage,omitempty"`
+	Allow      []string `json:"allow,omitempty"`
+	Deny       []string `json:"deny,omitempty"`
+	// "2006-01-02 15:04:05" 或者 "2006-01-02", 使用 Config.Timezone; 也可以是带时区的 RFC3339; 为空时不限制
+	From  string `json:"from,omitempty"`
+	Until string `json:"until,omitempty"`
+}
+
+func (r *Rule) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		return r.parse(s)
+	case string(data) == "true" || string(data) == "false":
+		*r = Rule{Enabled: string(data) == "true"}
+		return nil
+	}
+
+	type plain Rule
+	rule := plain{Enabled: true}
+	if err := json.Unmarshal(data, &rule); err != nil {
+		return err
+	}
+	*r = Rule(rule)
+	return nil
+}
+
+func (r *Rule) parse(s string) error {
+	rule := Rule{Enabled: true}
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		kv := strings.SplitN(part, "=", 2)
+		switch {
+		case part == "":
+		case part == "on" || part == "true":
+			rule.Enabled = true
+		case part == "off" || part == "false":
+			rule.Enabled = false
+		case strings.HasSuffix(part, "%"):
+			p, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(part, "%")), 64)
+			if err != nil {
+				return errPkg.FailBy(err, "parse flag percentage fail.", errPkg.Fields{"rule": s})
+			}
+			rule.Percentage = &p
+		case len(kv) == 2 && strings.TrimSpace(kv[0]) == "allow":
+			rule.Allow = splitList(kv[1])
+		case len(kv) == 2 && strings.TrimSpace(kv[0]) == "deny":
+			rule.Deny = splitList(kv[1])
+		case len(kv) == 2 && strings.TrimSpace(kv[0]) == "from":
+			rule.From = strings.TrimSpace(kv[1])
+		case len(kv) == 2 && strings.TrimSpace(kv[0]) == "until":
+			rule.Until = strings.TrimSpace(kv[1])
+		default:
+			return errPkg.Fail("unknown part of flag rule.", errPkg.Fields{"rule": s, "part": part})
+		}
+	}
+	*r = rule
+	return nil
+}
+
+func splitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, "|") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// 灰度的精度是万分之一
+const buckets = 10000
+
+type flag struct {
+	enabled bool
+	// key 的哈希落在 [0, threshold) 中时打开
+	threshold   uint32
+	allow, deny map[string]bool
+	from, until time.Time
+}
+
+// 校验并编译所有的 flag, 见 setting.CanChecked; 不合法的配置不会被热加载
+func (c *Config) Access() error {
+	loc := model.CST
+	if c.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(c.Timezone); err != nil {
+			return errPkg.FailBy(err, "load flag timezone fail.", errPkg.Fields{"timezone": c.Timezone})
+		}
+	}
+
+	compiled := make(map[string]*flag, len(c.Flags))
+	for name, rule := range c.Flags {
+		f, err := rule.compile(loc)
+		if err != nil {
+			return errPkg.FailBy(err, "compile flag fail.", errPkg.Fields{"flag": name})
+		}
+		compiled[name] = f
+	}
+	c.compiled = compiled
+	return nil
+}
+
+func (r Rule) compile(loc *time.Location) (*flag, error) {
+	f := &flag{enabled: r.Enabled, threshold: buckets, allow: toSet(r.Allow), deny: toSet(r.Deny)}
+	if r.Percentage != nil {
+		p := *r.Percentage
+		if p < 0 || p > 100 || math.IsNaN(p) {
+			return nil, errPkg.Fail("flag percentage must be in [0, 100].", errPkg.Fields{"percentage": p})
+		}
+		f.threshold = uint32(math.Round(p * buckets / 100))
+	}
+
+	var err error
+	if f.from, err = parseTime(r.From, loc); err != nil {
+		return nil, err
+	}
+	if f.until, err = parseTime(r.Until, loc); err != nil {
+		return nil, err
+	}
+	if !f.from.IsZero() && !f.until.IsZero() && !f.from.Before(f.until) {
+		return nil, errPkg.Fail("flag time window is empty.", errPkg.Fields{"from": r.From, "until": r.Until})
+	}
+	return f, nil
+}
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
+func parseTime(s string, loc *time.Location) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errPkg.FailBy(err, "parse flag time fail.", errPkg.Fields{"time": s})
+	}
+	return t, nil
+}
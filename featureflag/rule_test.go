@@ -0,0 +1,58 @@
+package featureflag
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"qing/go-helper/model"
+	"testing"
+	"time"
+)
+
+func TestRule_UnmarshalJSON(t *testing.T) {
+	twenty := 20.0
+	cases := []struct {
+		json string
+		want Rule
+	}{
+		{`true`, Rule{Enabled: true}},
+		{`{"percentage": 20}`, Rule{Enabled: true, Percentage: &twenty}},
+		{`{"enabled": false, "allow": ["u1"]}`, Rule{Allow: []string{"u1"}}},
+		{`"off"`, Rule{}},
+		{`"20%; allow=u1|u2; deny=u3"`, Rule{Enabled: true, Percentage: &twenty, Allow: []string{"u1", "u2"}, Deny: []string{"u3"}}},
+		{`"from=2024-11-11;until=2024-11-12 00:00:00"`, Rule{Enabled: true, From: "2024-11-11", Until: "2024-11-12 00:00:00"}},
+	}
+	for _, c := range cases {
+		var rule Rule
+		assert.Nil(t, json.Unmarshal([]byte(c.json), &rule), c.json)
+		assert.Equal(t, c.want, rule, c.json)
+	}
+
+	var rule Rule
+	assert.NotNil(t, json.Unmarshal([]byte(`"sometimes"`), &rule))
+	assert.NotNil(t, json.Unmarshal([]byte(`"x%"`), &rule))
+}
+
+func TestConfig_Access(t *testing.T) {
+	conf := &Config{Flags: map[string]Rule{
+		"sale": {Enabled: true, From: "2024-11-11", Until: "2024-11-12T00:00:00Z"},
+	}}
+	assert.Nil(t, conf.Access())
+	sale := conf.compiled["sale"]
+	assert.Equal(t, time.Date(2024, 11, 11, 0, 0, 0, 0, model.CST), sale.from)
+	assert.Equal(t, time.Date(2024, 11, 12, 0, 0, 0, 0, time.UTC), sale.until)
+	assert.Equal(t, uint32(buckets), sale.threshold)
+
+	conf.Timezone = "UTC"
+	assert.Nil(t, conf.Access())
+	assert.Equal(t, time.Date(2024, 11, 11, 0, 0, 0, 0, time.UTC), conf.compiled["sale"].from)
+
+	bad := []Config{
+		{Timezone: "Mars/Olympus"},
+		{Flags: map[string]Rule{"a": {Percentage: new(float64), From: "tomorrow"}}},
+		{Flags: map[string]Rule{"a": {Percentage: func() *float64 { p := 101.0; return &p }()}}},
+		{Flags: map[string]Rule{"a": {From: "2024-11-12", Until: "2024-11-11"}}},
+	}
+	for i := range bad {
+		assert.NotNil(t, bad[i].Access(), "bad config %d", i)
+	}
+}
@@ -0,0 +1,104 @@
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"qing/go-helper/error"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 配置变化的审计日志, 每次变化追加一行 JSON (JSON Lines), 便于排查配置推送之后的行为变化:
+//   {"time":"...","type":"*app.Conf","sources":["file /etc/app/conf.json","env APP_PORT"],"checksum":"sha256:...","diff":{...}}
+// 只记录热加载和再次 Init 时真正发生的变化, 见 Diff
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// 变化的值来自哪些源, 配置文件不包括行列
+	Sources []string `json:"sources"`
+	// 新配置的摘要, 见 checksumLeaves
+	Checksum string `json:"checksum"`
+	Diff     Diff   `json:"diff"`
+}
+
+var (
+	auditMu      sync.Mutex
+	auditFile    *os.File
+	auditOnError func(err error)
+)
+
+// 设置审计日志文件, 文件不存在时以 0600 创建; path 为空时关闭审计日志
+// 写入失败时调用 onError (可以为 nil), 不影响配置的加载
+func SetAuditFile(path string, onError func(err error)) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if auditFile != nil {
+		auditFile.Close()
+		auditFile = nil
+	}
+	auditOnError = onError
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errPkg.FailBy(err, "open audit file fail.", errPkg.Fields{"file": path})
+	}
+	auditFile = f
+	return nil
+}
+
+// 比较 v 和它之前的叶子 old, 有变化时写入审计日志; v 的来源需要已经被记录
+func changesOf(v interface{}, old []leafSnapshot) Diff {
+	leaves := snapshotLeaves(v)
+	diff := compareLeaves(old, leaves)
+	if !diff.Empty() {
+		audit(v, leaves, diff)
+	}
+	return diff
+}
+
+func audit(v interface{}, leaves []leafSnapshot, diff Diff) {
+	auditMu.Lock()
+	f, onError := auditFile, auditOnError
+	if f == nil {
+		auditMu.Unlock()
+		return
+	}
+
+	record := AuditRecord{
+		Time:     time.Now(),
+		Type:     fmt.Sprintf("%T", v),
+		Sources:  changeSources(diff),
+		Checksum: checksumLeaves(leaves),
+		Diff:     diff,
+	}
+	bs, err := json.Marshal(record)
+	if err == nil {
+		_, err = f.Write(append(bs, '\n'))
+	}
+	auditMu.Unlock()
+
+	if err != nil && onError != nil {
+		onError(errPkg.FailBy(err, "write audit log fail.", errPkg.Fields{"file": f.Name()}))
+	}
+}
+
+func changeSources(diff Diff) []string {
+	seen := make(map[string]bool)
+	sources := make([]string, 0)
+	for _, changes := range [][]Change{diff.Added, diff.Removed, diff.Changed} {
+		for _, c := range changes {
+			origin := c.Source
+			origin.Line, origin.Column = 0, 0
+			if s := origin.String(); !seen[s] {
+				seen[s] = true
+				sources = append(sources, s)
+			}
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
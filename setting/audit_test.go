@@ -0,0 +1,70 @@
+package setting
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type auditConf struct {
+	URL      string `json:"url"`
+	Password Secret `json:"password"`
+}
+
+func (conf *auditConf) FromOsEnvs() string {
+	return "AUDIT"
+}
+
+func Test_SetAuditFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	assert.Nil(t, SetAuditFile(path, func(err error) { t.Error(err) }))
+	defer SetAuditFile("", nil)
+
+	os.Setenv("AUDIT_URL", "mysql://a")
+	os.Setenv("AUDIT_PASSWORD", "${env:AUDIT_SECRET}")
+	os.Setenv("AUDIT_SECRET", "p1")
+	defer func() {
+		os.Unsetenv("AUDIT_URL")
+		os.Unsetenv("AUDIT_PASSWORD")
+		os.Unsetenv("AUDIT_SECRET")
+	}()
+
+	// 第一次 Init 不是变化
+	conf := new(auditConf)
+	assert.Nil(t, Init(conf))
+	defer Forget(conf)
+	os.Setenv("AUDIT_URL", "mysql://b")
+	os.Setenv("AUDIT_SECRET", "p2")
+	assert.Nil(t, Init(conf))
+	// 没有变化时不记录
+	assert.Nil(t, Init(conf))
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if !assert.Equal(t, 1, len(lines)) {
+		return
+	}
+	assert.NotContains(t, lines[0], "p2")
+	var record AuditRecord
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, "*setting.auditConf", record.Type)
+	assert.Equal(t, []string{"env AUDIT_PASSWORD", "env AUDIT_URL"}, record.Sources)
+	assert.True(t, strings.HasPrefix(record.Checksum, "sha256:"))
+	assert.Equal(t, []Change{
+		{Key: "url", Old: "mysql://a", New: "mysql://b", Source: Origin{Kind: OriginEnv, Name: "AUDIT_URL"}},
+		{Key: "password", Old: maskedValue, New: maskedValue, Source: Origin{Kind: OriginEnv, Name: "AUDIT_PASSWORD"}},
+	}, record.Diff.Changed)
+	assert.Equal(t, checksumLeaves(snapshotLeaves(conf)), record.Checksum)
+}
@@ -0,0 +1,419 @@
+package setting
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"qing/go-helper/error"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 配置文件先被解析成一棵通用的树, 再绑定到 confObj 上
+// 树的节点只有三种:
+//   map[string]interface{}: 对象 / section
+//   []interface{}: 数组
+//   scalar: string, bool, int64, float64, time.Time ...
+//
+// 像 INI / properties 这样只有字符串的格式, 绑定时会按字段类型做转换, 比如 "10" -> int, "5s" -> time.Duration,
+// "a, b" -> []string
+func bindTree(tree interface{}, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errPkg.Fail("bind target must be a non-nil pointer.", errPkg.Fields{"type": fmt.Sprintf("%T", v)})
+	}
+	return bindValue(tree, rv.Elem(), "")
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+func bindValue(node interface{}, rv reflect.Value, path string) error {
+	if node == nil {
+		return nil
+	}
+
+	// 密钥引用, 见 secret.go, 出错时不暴露明文
+	if s, ok := node.(string); ok && isSecretRef(s) {
+		if !isSecretType(rv.Type()) {
+			return bindFail(errPkg.Fail("secret reference can only be bound to setting.Secret.", nil), node, rv, path)
+		}
+		resolved, err := resolveSecret(s)
+		if err != nil {
+			return bindFail(err, node, rv, path)
+		}
+		if err = bindValue(resolved, rv, path); err != nil {
+			return bindFail(nil, node, rv, path)
+		}
+		return nil
+	}
+
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+			if err := applyDefaults(rv.Elem()); err != nil {
+				return err
+			}
+		}
+		return bindValue(node, rv.Elem(), path)
+	}
+	// 转义得到的文本, 见 interpolate.go
+	if s, ok := node.(escapedString); ok {
+		node = string(s)
+	}
+
+	if rv.CanAddr() {
+		if rv.Addr().Type().Implements(jsonUnmarshalerType) {
+			bs, err := json.Marshal(node)
+			if err != nil {
+				return bindFail(err, node, rv, path)
+			}
+			if err = rv.Addr().Interface().(json.Unmarshaler).UnmarshalJSON(bs); err != nil {
+				return bindFail(err, node, rv, path)
+			}
+			return nil
+		}
+		if s, ok := node.(string); ok && rv.Addr().Type().Implements(textUnmarshalerType) {
+			if err := rv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+				return bindFail(err, node, rv, path)
+			}
+			return nil
+		}
+	}
+
+	if rv.Type() == durationType {
+		d, err := toDuration(node)
+		if err != nil {
+			return bindFail(err, node, rv, path)
+		}
+		rv.SetInt(int64(d))
+		return nil
+	}
+
+	// 同 encoding/json, []byte 的字符串值是 base64 编码的
+	if s, ok := node.(string); ok && rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+		bs, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return bindFail(err, node, rv, path)
+		}
+		rv.SetBytes(bs)
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Interface:
+		if rv.NumMethod() != 0 {
+			return bindFail(nil, node, rv, path)
+		}
+		rv.Set(reflect.ValueOf(node))
+		return nil
+
+	case reflect.Struct:
+		if t, ok := node.(time.Time); ok && rv.Type() == reflect.TypeOf(t) {
+			rv.Set(reflect.ValueOf(t))
+			return nil
+		}
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return bindFail(nil, node, rv, path)
+		}
+		// 按 key 的顺序绑定, 多个 key 出错时报告的总是同一个
+		for _, k := range sortedKeys(obj) {
+			child := obj[k]
+			field, ok := fieldByKey(rv, k)
+			if !ok {
+				continue
+			}
+			if err := bindValue(child, field, joinPath(path, k)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return bindFail(nil, node, rv, path)
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMap(rv.Type()))
+		}
+		for k, child := range obj {
+			key := reflect.New(rv.Type().Key()).Elem()
+			if err := bindScalar(k, key, joinPath(path, k)); err != nil {
+				return err
+			}
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			if old := rv.MapIndex(key); old.IsValid() {
+				elem.Set(old)
+			} else if err := applyDefaults(elem); err != nil {
+				return err
+			}
+			if err := bindValue(child, elem, joinPath(path, k)); err != nil {
+				return err
+			}
+			rv.SetMapIndex(key, elem)
+		}
+		return nil
+
+	case reflect.Slice, reflect.Array:
+		items, ok := toItems(node)
+		if !ok {
+			return bindFail(nil, node, rv, path)
+		}
+		if rv.Kind() == reflect.Slice {
+			rv.Set(reflect.MakeSlice(rv.Type(), len(items), len(items)))
+		} else if len(items) > rv.Len() {
+			return bindFail(errPkg.Fail("too many items for array.", errPkg.Fields{"len": rv.Len()}), node, rv, path)
+		}
+		for i, item := range items {
+			if rv.Kind() == reflect.Slice {
+				if err := applyDefaults(rv.Index(i)); err != nil {
+					return err
+				}
+			}
+			if err := bindValue(item, rv.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return bindScalar(node, rv, path)
+}
+
+func bindScalar(node interface{}, rv reflect.Value, path string) error {
+	var err error
+	switch rv.Kind() {
+	case reflect.String:
+		switch n := node.(type) {
+		case string:
+			rv.SetString(n)
+		case bool, int64, float64, json.Number:
+			rv.SetString(fmt.Sprint(n))
+		default:
+			return bindFail(nil, node, rv, path)
+		}
+
+	case reflect.Bool:
+		switch n := node.(type) {
+		case bool:
+			rv.SetBool(n)
+		case string:
+			var b bool
+			if b, err = strconv.ParseBool(strings.TrimSpace(n)); err == nil {
+				rv.SetBool(b)
+			}
+		default:
+			return bindFail(nil, node, rv, path)
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		if i, err = toInt64(node); err == nil {
+			if rv.OverflowInt(i) {
+				err = errPkg.Fail("value overflows field.", nil)
+			} else {
+				rv.SetInt(i)
+			}
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var i int64
+		if i, err = toInt64(node); err == nil {
+			if i < 0 || rv.OverflowUint(uint64(i)) {
+				err = errPkg.Fail("value overflows field.", nil)
+			} else {
+				rv.SetUint(uint64(i))
+			}
+		}
+
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = toFloat64(node); err == nil {
+			if rv.OverflowFloat(f) {
+				err = errPkg.Fail("value overflows field.", nil)
+			} else {
+				rv.SetFloat(f)
+			}
+		}
+
+	default:
+		return bindFail(nil, node, rv, path)
+	}
+
+	if err != nil {
+		return bindFail(err, node, rv, path)
+	}
+	return nil
+}
+
+// 绑定失败的 error, 见 bindSourceFail
+const bindFailMsg = "bind config value to field fail."
+
+func bindFail(cause error, node interface{}, rv reflect.Value, path string) *errPkg.Err {
+	return errPkg.FailBy(cause, bindFailMsg, errPkg.Fields{
+		"key":   path,
+		"type":  rv.Type().String(),
+		"value": fmt.Sprintf("%#v", node),
+	})
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func toItems(node interface{}) ([]interface{}, bool) {
+	switch n := node.(type) {
+	case []interface{}:
+		return n, true
+	case string:
+		// INI / properties 里的数组, 约定以逗号分隔
+		if strings.TrimSpace(n) == "" {
+			return []interface{}{}, true
+		}
+		parts := strings.Split(n, ",")
+		items := make([]interface{}, len(parts))
+		for i, part := range parts {
+			items[i] = strings.TrimSpace(part)
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+func toInt64(node interface{}) (int64, error) {
+	switch n := node.(type) {
+	case int64:
+		return n, nil
+	case float64:
+		if n != float64(int64(n)) {
+			return 0, errPkg.Fail("value is not an integer.", nil)
+		}
+		return int64(n), nil
+	case json.Number:
+		return n.Int64()
+	case string:
+		return strconv.ParseInt(strings.TrimSpace(n), 0, 64)
+	}
+	return 0, errPkg.Fail("value is not a number.", nil)
+}
+
+func toFloat64(node interface{}) (float64, error) {
+	switch n := node.(type) {
+	case int64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case json.Number:
+		return n.Float64()
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(n), 64)
+	}
+	return 0, errPkg.Fail("value is not a number.", nil)
+}
+
+// "5s", "1h30m" 或者整数 (纳秒)
+func toDuration(node interface{}) (time.Duration, error) {
+	if s, ok := node.(string); ok {
+		s = strings.TrimSpace(s)
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.Duration(i), nil
+		}
+		return time.ParseDuration(s)
+	}
+	i, err := toInt64(node)
+	return time.Duration(i), err
+}
+
+// 按 encoding/json 的规则查找 key 对应的字段: 先精确匹配 tag / 字段名, 再忽略大小写匹配
+func fieldByKey(rv reflect.Value, key string) (reflect.Value, bool) {
+	fields := structFields(rv.Type())
+	var matched *structField
+	for i := range fields {
+		if fields[i].name == key {
+			matched = &fields[i]
+			break
+		}
+		if matched == nil && strings.EqualFold(fields[i].name, key) {
+			matched = &fields[i]
+		}
+	}
+	if matched == nil {
+		return reflect.Value{}, false
+	}
+
+	field := rv
+	for _, i := range matched.index {
+		if field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				field.Set(reflect.New(field.Type().Elem()))
+			}
+			field = field.Elem()
+		}
+		field = field.Field(i)
+	}
+	return field, true
+}
+
+type structField struct {
+	name  string
+	index []int
+	field reflect.StructField
+}
+
+// 可以被配置的字段, 匿名嵌入的 struct 的字段会被提升, 浅层的字段优先
+func structFields(t reflect.Type) []structField {
+	result := make([]structField, 0, t.NumField())
+	seen := make(map[string]bool)
+	embedded := make([]structField, 0)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			if f.PkgPath != "" && f.Type.Kind() == reflect.Ptr {
+				continue
+			}
+			for _, sub := range structFields(ft) {
+				sub.index = append([]int{i}, sub.index...)
+				embedded = append(embedded, sub)
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		seen[name] = true
+		result = append(result, structField{name: name, index: []int{i}, field: f})
+	}
+
+	for _, sub := range embedded {
+		if !seen[sub.name] {
+			seen[sub.name] = true
+			result = append(result, sub)
+		}
+	}
+	return result
+}
@@ -0,0 +1,458 @@
+package setting
+
+import (
+	"fmt"
+	"net/url"
+	"qing/go-helper/error"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 部署前检查配置文件发现的问题, 见 CheckFile 和 cmd/confcheck
+type Problem struct {
+	// 出错的 key, 从文件的根节点开始, 包括 sections; 语法错误等没有 key
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+	// 出错的位置, 通常是 file 和行列
+	Origin Origin `json:"origin"`
+}
+
+// 形如 conf.json:3:5: db.port: expected integer, found "abc"
+func (p Problem) String() string {
+	where := Position{File: p.Origin.Name, Line: p.Origin.Line, Column: p.Origin.Column}.String()
+	if p.Origin.Kind != OriginFile {
+		where = p.Origin.String()
+	}
+	if p.Key == "" {
+		return fmt.Sprintf("%s: %s", where, p.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", where, p.Key, p.Message)
+}
+
+// 像服务启动时一样解析配置文件: include, profile 层 (见 SetProfile) 和 interpolate, 返回合并后的整个文件, 不绑定到 confObj
+// 返回值的 Node 就是生效的配置, 其中的密钥引用不会被解析
+func ReadFile(path string) (*SourceTree, error) {
+	return loadFileSource(newLoadSession(), path, nil, nil, nil)
+}
+
+// key 在源中的位置, 比如配置文件的行列, key 的写法见 Explain; 没有精确位置时使用最近的祖先
+func (tree *SourceTree) Locate(key string) Origin {
+	segs, err := parseKeyPath(key)
+	if err != nil {
+		segs = nil
+	}
+	return locateKey(tree, func(segs []interface{}) Origin { return Origin{} }, segs)
+}
+
+// 检查配置文件, 返回所有的问题和合并后的配置 (无法解析时为 nil):
+//   schema 不为 nil 时, 用它检查合并后的配置
+//   objs 不为空时, 用它们的类型检查: schema 为 nil 时由 GenerateSchema(objs) 生成; 然后在 objs 的拷贝上按 FromFile 的
+//   sections 绑定, 执行 validate tag 和 CanChecked.Access, objs 本身不会被修改
+//   生成的 schema 不检查 required, 是否缺少由应用默认值之后的校验判断, 和加载时一致
+// strict 为 true 时, schema 中没有定义的 key 也是问题, 见 strict.go
+// 同一个 key 只报告 schema 发现的问题, 不再重复报告绑定和校验的问题
+func CheckFile(path string, schema *JSONSchema, strict bool, objs ...interface{}) (*SourceTree, []Problem) {
+	tree, err := ReadFile(path)
+	if err != nil {
+		return nil, []Problem{problemOf(err, path)}
+	}
+	if schema == nil && len(objs) > 0 {
+		schema = GenerateSchema("", objs...)
+		schema.clearRequired()
+	}
+
+	problems := make([]Problem, 0)
+	reported := make(map[string]bool)
+	add := func(p Problem) {
+		if p.Key != "" && p.Origin.Kind == "" {
+			p.Origin = tree.Locate(p.Key)
+		}
+		if p.Origin.Kind == "" {
+			p.Origin = Origin{Kind: OriginFile, Name: path}
+		}
+		problems = append(problems, p)
+	}
+	if schema != nil {
+		for _, p := range schema.Check(tree.Node, strict) {
+			reported[p.Key] = true
+			add(p)
+		}
+	}
+	for _, v := range objs {
+		for _, p := range checkObj(tree, path, v) {
+			if !reported[p.Key] {
+				reported[p.Key] = true
+				add(p)
+			}
+		}
+	}
+	return tree, problems
+}
+
+// 在 v 的拷贝上绑定 v 的 sections 对应的节点, 再执行校验
+func checkObj(tree *SourceTree, path string, v interface{}) []Problem {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil
+	}
+	fresh := cloneValue(rv)
+	if err := applyDefaults(fresh); err != nil {
+		return []Problem{problemOf(err, path)}
+	}
+
+	segs := fileSections(v)
+	node, resolved, ok := lookupSection(tree.Node, segs)
+	if ok {
+		// 层的 Origin 相对于文件的根节点
+		section := &SourceTree{Node: node, prefix: resolved}
+		for _, layer := range tree.Layers {
+			layer := layer
+			sub, _, ok := lookupSection(layer.Node, resolved)
+			if !ok {
+				continue
+			}
+			section.Layers = append(section.Layers, &SourceTree{Node: sub, Origin: func(segs []interface{}) Origin {
+				return layer.Origin(append(append([]interface{}{}, resolved...), segs...))
+			}})
+		}
+		origin := func(segs []interface{}) Origin {
+			return Origin{Kind: OriginFile, Name: path}
+		}
+		if err := bindTree(node, fresh.Interface()); err != nil {
+			return []Problem{problemOf(bindSourceFail(err, section, origin, "unmarshal config file's content bytes to confObj fail.", nil), path)}
+		}
+	}
+
+	prefix := formatKeyPath(resolved)
+	if !ok {
+		prefix = formatKeyPath(segs)
+	}
+	if err := Validate(fresh.Interface()); err != nil {
+		problems := make([]Problem, 0)
+		if known, ok := err.(*errPkg.Err); ok {
+			keys, _ := known.Fields["keys"].([]string)
+			violations, _ := known.Fields["violations"].(map[string]string)
+			for _, key := range keys {
+				problems = append(problems, Problem{Key: joinPath(prefix, key), Message: violations[key]})
+			}
+		}
+		if len(problems) > 0 {
+			return problems
+		}
+		return []Problem{{Message: err.Error()}}
+	}
+	if needCheck, ok := fresh.Interface().(CanChecked); ok {
+		if err := needCheck.Access(); err != nil {
+			return []Problem{{Key: prefix, Message: "Access(): " + errMessage(err)}}
+		}
+	}
+	return nil
+}
+
+// 带位置的 error (见 diagnose.go) 转换为 Problem
+func problemOf(err error, path string) Problem {
+	p := Problem{Message: errMessage(err), Origin: Origin{Kind: OriginFile, Name: path}}
+	for cause := err; cause != nil; {
+		known, ok := cause.(*errPkg.Err)
+		if !ok {
+			break
+		}
+		if line, ok := known.Fields["line"].(int); ok {
+			p.Origin.Line = line
+			p.Origin.Column, _ = known.Fields["column"].(int)
+			if file, ok := known.Fields["file"].(string); ok {
+				p.Origin.Name = file
+			}
+			if key, ok := known.Fields["key"].(string); ok {
+				p.Key = key
+			}
+			if expected, ok := known.Fields["expected"]; ok {
+				p.Message = fmt.Sprintf("expected %v, found %v", expected, known.Fields["found"])
+			}
+			break
+		}
+		cause = known.Cause
+	}
+	return p
+}
+
+// 最内层的原因, 不包括 errPkg.Err 的时间等信息
+func errMessage(err error) string {
+	msg := err.Error()
+	for err != nil {
+		known, ok := err.(*errPkg.Err)
+		if !ok {
+			return err.Error()
+		}
+		msg = known.Msg
+		err = known.Cause
+	}
+	return msg
+}
+
+// 用 schema 检查配置树, 只支持 GenerateSchema 用到的部分 (见 JSONSchema); 返回的 Problem 没有 Origin
+// 和绑定时一样, INI 这样只有字符串的格式中, 可以转换为目标类型的字符串是合法的, 比如 "10" 之于 integer
+// 密钥引用 (见 secret.go) 不做检查
+func (s *JSONSchema) Check(node interface{}, strict bool) []Problem {
+	problems := make([]Problem, 0)
+	s.check(node, nil, strict, &problems)
+	return problems
+}
+
+func (s *JSONSchema) check(node interface{}, segs []interface{}, strict bool, problems *[]Problem) {
+	if s == nil || node == nil {
+		return
+	}
+	if str, ok := node.(string); ok && isSecretRef(str) {
+		return
+	}
+	add := func(format string, args ...interface{}) {
+		*problems = append(*problems, Problem{Key: formatKeyPath(segs), Message: fmt.Sprintf(format, args...)})
+	}
+
+	value, ok := coerceSchemaValue(node, s.Type)
+	if !ok {
+		add("expected %s, found %#v", s.Type, node)
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(value, s.Enum) {
+		add("must be one of %v, found %#v", s.Enum, node)
+	}
+
+	switch v := value.(type) {
+	case string:
+		s.checkString(v, add)
+	case int64:
+		s.checkNumber(float64(v), add)
+	case float64:
+		s.checkNumber(v, add)
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			add("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			add("must have at most %d items", *s.MaxItems)
+		}
+		for i, item := range v {
+			s.Items.check(item, appendSeg(segs, i), strict, problems)
+		}
+	case map[string]interface{}:
+		s.checkObject(v, segs, strict, problems, add)
+	}
+}
+
+func (s *JSONSchema) checkString(v string, add func(format string, args ...interface{})) {
+	n := len([]rune(v))
+	if s.MinLength != nil && n < *s.MinLength {
+		add("length must be at least %d", *s.MinLength)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		add("length must be at most %d", *s.MaxLength)
+	}
+	if s.Pattern != "" {
+		if re, err := regexp.Compile(s.Pattern); err == nil && !re.MatchString(v) {
+			add("must match %s, found %q", s.Pattern, v)
+		}
+	}
+	switch s.Format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			add("must be a RFC3339 date-time, found %q", v)
+		}
+	case "uri":
+		if u, err := url.Parse(v); err != nil || u.Scheme == "" {
+			add("must be an absolute uri, found %q", v)
+		}
+	case "regex":
+		if _, err := regexp.Compile(v); err != nil {
+			add("must be a regular expression, found %q", v)
+		}
+	}
+}
+
+func (s *JSONSchema) checkNumber(v float64, add func(format string, args ...interface{})) {
+	if s.Minimum != nil && v < *s.Minimum {
+		add("must be >= %v, found %v", *s.Minimum, v)
+	}
+	if s.Maximum != nil && v > *s.Maximum {
+		add("must be <= %v, found %v", *s.Maximum, v)
+	}
+}
+
+func (s *JSONSchema) clearRequired() {
+	if s == nil {
+		return
+	}
+	s.Required = nil
+	for _, p := range s.Properties {
+		p.clearRequired()
+	}
+	s.Items.clearRequired()
+	s.AdditionalProperties.clearRequired()
+}
+
+func (s *JSONSchema) checkObject(obj map[string]interface{}, segs []interface{}, strict bool, problems *[]Problem,
+	add func(format string, args ...interface{})) {
+	if s.MinProperties != nil && len(obj) < *s.MinProperties {
+		add("must have at least %d keys", *s.MinProperties)
+	}
+	if s.MaxProperties != nil && len(obj) > *s.MaxProperties {
+		add("must have at most %d keys", *s.MaxProperties)
+	}
+	for _, name := range s.Required {
+		if key, ok := sectionKey(obj, name); !ok || obj[key] == nil {
+			*problems = append(*problems, Problem{Key: formatKeyPath(appendSeg(segs, name)), Message: "is required"})
+		}
+	}
+
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	for _, key := range sortedKeys(obj) {
+		child := obj[key]
+		if prop, ok := s.Properties[key]; ok {
+			prop.check(child, appendSeg(segs, key), strict, problems)
+			continue
+		}
+		if name, ok := foldKey(names, key); ok {
+			s.Properties[name].check(child, appendSeg(segs, key), strict, problems)
+			continue
+		}
+		if s.AdditionalProperties != nil {
+			s.AdditionalProperties.check(child, appendSeg(segs, key), strict, problems)
+			continue
+		}
+		if strict && s.Properties != nil {
+			msg := "unknown key"
+			if suggestion := suggest(key, names); suggestion != "" {
+				msg += ", did you mean " + suggestion + "?"
+			}
+			*problems = append(*problems, Problem{Key: formatKeyPath(appendSeg(segs, key)), Message: msg})
+		}
+	}
+}
+
+func foldKey(names []string, key string) (string, bool) {
+	for _, name := range names {
+		if strings.EqualFold(name, key) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// 按绑定时的规则转换为 schema 的类型, 见 bindScalar 和 toItems
+func coerceSchemaValue(node interface{}, typ string) (interface{}, bool) {
+	if s, ok := node.(escapedString); ok {
+		node = string(s)
+	}
+	switch typ {
+	case "":
+		return node, true
+	case "object":
+		_, ok := node.(map[string]interface{})
+		return node, ok
+	case "array":
+		return toItems(node)
+	case "string":
+		switch n := node.(type) {
+		case string:
+			return n, true
+		case time.Time:
+			return n.Format(time.RFC3339Nano), true
+		case bool, int64, float64:
+			return fmt.Sprint(n), true
+		}
+	case "integer":
+		switch n := node.(type) {
+		case int64:
+			return n, true
+		case float64:
+			return int64(n), n == float64(int64(n))
+		case string:
+			i, err := strconv.ParseInt(strings.TrimSpace(n), 10, 64)
+			return i, err == nil
+		}
+	case "number":
+		switch n := node.(type) {
+		case int64, float64:
+			return n, true
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+			return f, err == nil
+		}
+	case "boolean":
+		switch n := node.(type) {
+		case bool:
+			return n, true
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(n))
+			return b, err == nil
+		}
+	}
+	return nil, false
+}
+
+func inEnum(value interface{}, enum []interface{}) bool {
+	for _, option := range enum {
+		if fmt.Sprint(option) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// 一个配置文件的检查结果, 见 CheckRegistered
+type FileCheck struct {
+	Path string `json:"path"`
+	// 合并后生效的配置, 文件无法解析时为 nil
+	Config   interface{} `json:"config,omitempty"`
+	Problems []Problem   `json:"problems"`
+}
+
+// 用已注册 (见 Register) 的 confObj 检查配置文件, 见 CheckFile
+// paths 为空时, 检查每个 confObj 自己的配置文件 (见 FromFile), 使用同一个文件的 confObj 一起检查
+// paths 不为空时, 每个文件都用所有实现了 FromFile 的 confObj 检查, 比如检查各个环境的配置文件
+func CheckRegistered(strict bool, paths ...string) []FileCheck {
+	objs := make(map[string][]interface{})
+	order := make([]string, 0)
+	for _, v := range Registered() {
+		f, ok := v.(FromFile)
+		if !ok {
+			continue
+		}
+		path, _ := f.FromFile()
+		if len(paths) > 0 {
+			path = ""
+		}
+		if _, ok := objs[path]; !ok {
+			order = append(order, path)
+		}
+		objs[path] = append(objs[path], v)
+	}
+
+	results := make([]FileCheck, 0)
+	if len(paths) == 0 {
+		for _, path := range order {
+			results = append(results, checkFileResult(path, strict, objs[path]))
+		}
+		return results
+	}
+	for _, path := range paths {
+		results = append(results, checkFileResult(path, strict, objs[""]))
+	}
+	return results
+}
+
+func checkFileResult(path string, strict bool, objs []interface{}) FileCheck {
+	tree, problems := CheckFile(path, nil, strict, objs...)
+	result := FileCheck{Path: path, Problems: problems}
+	if tree != nil {
+		result.Config = tree.Node
+	}
+	return result
+}
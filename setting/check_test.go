@@ -0,0 +1,159 @@
+package setting
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type checkDB struct {
+	path  string
+	URL   string `json:"url" validate:"required,url"`
+	Port  int    `json:"port" validate:"min=1,max=65535"`
+	Level string `json:"level" validate:"oneof=DEBUG INFO"`
+	Data  string `json:"data" validate:"file-exists"`
+}
+
+func (conf *checkDB) FromFile() (string, []string) {
+	return conf.path, []string{"db"}
+}
+
+func (conf *checkDB) Access() error {
+	if conf.Level == "DEBUG" && conf.Port == 3306 {
+		return errors.New("debug on the primary port")
+	}
+	return nil
+}
+
+func Test_CheckFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	path := write("conf.json", `{
+  "db": {
+    "url": "mysql://a",
+    "port": 70000,
+    "levle": "INFO",
+    "data": "/not/exist"
+  }
+}`)
+	db := &checkDB{path: path}
+	tree, problems := CheckFile(path, nil, true, db)
+	if assert.NotNil(t, tree) {
+		assert.Equal(t, "mysql://a", tree.Node.(map[string]interface{})["db"].(map[string]interface{})["url"])
+	}
+	assert.Equal(t, []Problem{
+		{Key: "db.levle", Message: "unknown key, did you mean level?", Origin: Origin{Kind: OriginFile, Name: path, Line: 5, Column: 5}},
+		{Key: "db.port", Message: "must be <= 65535, found 70000", Origin: Origin{Kind: OriginFile, Name: path, Line: 4, Column: 5}},
+		{Key: "db.data", Message: "file must exist: stat /not/exist: no such file or directory", Origin: Origin{Kind: OriginFile, Name: path, Line: 6, Column: 5}},
+	}, problems)
+	assert.Equal(t, path+":5:5: db.levle: unknown key, did you mean level?", problems[0].String())
+	assert.Equal(t, &checkDB{path: path}, db)
+
+	// ini 中的字符串按字段类型转换; 绑定失败时报告期望的类型
+	path = write("conf.ini", "[db]\nurl = mysql://a\nport = abc\nlevel = DEBUG\n")
+	_, problems = CheckFile(path, nil, false, &checkDB{path: path})
+	assert.Equal(t, []Problem{
+		{Key: "db.port", Message: `expected integer, found "abc"`, Origin: Origin{Kind: OriginFile, Name: path, Line: 3, Column: 1}},
+	}, problems)
+
+	// Access() 在校验通过之后执行
+	path = write("access.json", `{"db": {"url": "mysql://a", "port": 3306, "level": "DEBUG"}}`)
+	_, problems = CheckFile(path, nil, false, &checkDB{path: path})
+	assert.Equal(t, []Problem{
+		{Key: "db", Message: "Access(): debug on the primary port", Origin: Origin{Kind: OriginFile, Name: path, Line: 1, Column: 2}},
+	}, problems)
+
+	// 语法错误
+	path = write("bad.json", "{\n  \"db\": \n}")
+	tree, problems = CheckFile(path, nil, false, &checkDB{path: path})
+	assert.Nil(t, tree)
+	if assert.Equal(t, 1, len(problems)) {
+		assert.Equal(t, 3, problems[0].Origin.Line)
+	}
+}
+
+type checkServer struct {
+	path string
+	Host string `json:"host" validate:"required"`
+	Port int    `json:"port" default:"8080" validate:"required"`
+}
+
+func (conf *checkServer) FromFile() (string, []string) {
+	return conf.path, []string{"app"}
+}
+
+// 有默认值的 required 字段在文件中可以省略, 和 Load 一致
+func Test_CheckFile_requiredWithDefault(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.json")
+	if err = ioutil.WriteFile(path, []byte(`{"app": {"host": "localhost"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{"host"}, GenerateSchema("", &checkServer{path: path}).Properties["app"].Required)
+	_, problems := CheckFile(path, nil, false, &checkServer{path: path})
+	assert.Empty(t, problems)
+	conf, err := Load[checkServer](WithFile(path, "app"))
+	assert.Nil(t, err)
+	Forget(conf)
+
+	// 缺少的 required 字段由校验报告
+	if err = ioutil.WriteFile(path, []byte(`{"app": {"port": 80}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, problems = CheckFile(path, nil, false, &checkServer{path: path})
+	if assert.Equal(t, 1, len(problems)) {
+		assert.Equal(t, "app.host", problems[0].Key)
+	}
+}
+
+func Test_CheckRegistered(t *testing.T) {
+	defer resetRegistry()
+	dir, err := ioutil.TempDir("", "setting-check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.json")
+	if err = ioutil.WriteFile(path, []byte(`{"db": {"url": "mysql://a", "port": 1}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(dir, "other.json")
+	if err = ioutil.WriteFile(other, []byte(`{"db": {"port": 1}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	Register(&checkDB{path: path})
+	results := CheckRegistered(false)
+	if assert.Equal(t, 1, len(results)) {
+		assert.Equal(t, path, results[0].Path)
+		assert.Equal(t, []Problem{}, results[0].Problems)
+		assert.NotNil(t, results[0].Config)
+	}
+
+	results = CheckRegistered(false, other)
+	if assert.Equal(t, 1, len(results)) {
+		// required 由绑定之后的校验报告, 见 CheckFile
+		assert.Equal(t, []Problem{
+			{Key: "db.url", Message: "required", Origin: Origin{Kind: OriginFile, Name: other, Line: 1, Column: 2}},
+		}, results[0].Problems)
+	}
+}
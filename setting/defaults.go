@@ -0,0 +1,179 @@
+package setting
+
+import (
+	"fmt"
+	"qing/go-helper/error"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// 通过 tag 声明默认值:
+//
+//   type Conf struct {
+//     Level   string            `json:"level" default:"INFO"`
+//     Timeout time.Duration     `json:"timeout" default:"5s"`
+//     Hosts   []string          `json:"hosts" default:"a,b"`
+//     Labels  map[string]string `json:"labels" default:"k1=v1,k2=v2"`
+//     DB      struct {
+//       Port int `json:"port" default:"3306"`
+//     } `json:"db"`
+//     Replicas []DB `json:"replicas"` // 每个元素也会应用 DB 的默认值
+//   }
+//
+// 默认值在所有源之前设置到 v 上, 只有零值的字段才会被设置 (Init 之前在代码里设置的值优先), 之后各个源只覆盖它们提供了的 key,
+// 所以配置文件里显式写的 0 / "" / false 会保留, 而没有写的 key 使用默认值
+// 值为 nil 的指针字段 (比如可选的 Backup *DB) 保持 nil, 只有某个源提供了该节点时才会分配, 并应用 DB 的默认值
+const defaultTag = "default"
+
+func applyDefaults(rv reflect.Value) error {
+	return applyDefaultsAt(rv, "")
+}
+
+func applyDefaultsAt(rv reflect.Value, path string) error {
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return applyDefaultsAt(rv.Elem(), path)
+
+	case reflect.Struct:
+		for _, f := range structFields(rv.Type()) {
+			field, ok := fieldByKey(rv, f.name)
+			if !ok {
+				continue
+			}
+			fieldPath := joinPath(path, f.name)
+			if literal, ok := f.field.Tag.Lookup(defaultTag); ok && field.IsZero() {
+				if err := bindValue(defaultNode(literal, field.Type()), field, fieldPath); err != nil {
+					return errPkg.FailBy(err, "apply default value fail.", errPkg.Fields{
+						"key":     fieldPath,
+						"default": literal,
+					})
+				}
+			}
+			if err := applyDefaultsAt(field, fieldPath); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if err := applyDefaultsAt(rv.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		if !hasDefaults(rv.Type().Elem(), nil) {
+			return nil
+		}
+		iter := rv.MapRange()
+		for iter.Next() {
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			if err := applyDefaultsAt(elem, joinPath(path, fmt.Sprint(iter.Key()))); err != nil {
+				return err
+			}
+			rv.SetMapIndex(iter.Key(), elem)
+		}
+	}
+	return nil
+}
+
+// map 类型的默认值写作 "k1=v1,k2=v2", 其他类型直接交给 bindValue 转换
+func defaultNode(literal string, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Map {
+		return literal
+	}
+	node := make(map[string]interface{})
+	for _, pair := range strings.Split(literal, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 1 {
+			node[strings.TrimSpace(kv[0])] = ""
+			continue
+		}
+		node[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return node
+}
+
+func hasDefaults(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	if visiting == nil {
+		visiting = make(map[reflect.Type]bool)
+	}
+	if visiting[t] {
+		return false
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for _, f := range structFields(t) {
+		if _, ok := f.field.Tag.Lookup(defaultTag); ok || hasDefaults(f.field.Type, visiting) {
+			return true
+		}
+	}
+	return false
+}
+
+// 一个声明了默认值的字段
+type DefaultValue struct {
+	// 配置中的 key 路径, 如 "db.port", 数组元素的字段写作 "replicas[].port"
+	Key string
+	// tag 中的字面值
+	Value string
+	Type  string
+}
+
+// 列出 v (confObj 或其类型) 中所有通过 tag 声明的默认值, 按 key 排序, 用于生成帮助信息和文档
+func DefaultValues(v interface{}) []DefaultValue {
+	result := make([]DefaultValue, 0)
+	collectDefaults(reflect.TypeOf(v), "", &result, make(map[reflect.Type]bool))
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+func collectDefaults(t reflect.Type, path string, result *[]DefaultValue, visiting map[reflect.Type]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		collectDefaults(t.Elem(), path+"[]", result, visiting)
+		return
+	case reflect.Map:
+		collectDefaults(t.Elem(), joinPath(path, "*"), result, visiting)
+		return
+	case reflect.Struct:
+	default:
+		return
+	}
+	if visiting[t] {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for _, f := range structFields(t) {
+		key := joinPath(path, f.name)
+		if literal, ok := f.field.Tag.Lookup(defaultTag); ok {
+			*result = append(*result, DefaultValue{Key: key, Value: literal, Type: f.field.Type.String()})
+		}
+		collectDefaults(f.field.Type, key, result, visiting)
+	}
+}
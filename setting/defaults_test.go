@@ -0,0 +1,88 @@
+package setting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	testingX "qing/go-helper/testing"
+	"testing"
+	"time"
+)
+
+type defaultsDB struct {
+	URL  string `json:"url"`
+	Port int    `json:"port" default:"3306"`
+}
+
+type defaultsConf struct {
+	Level    string            `json:"level" default:"INFO"`
+	Debug    bool              `json:"debug" default:"true"`
+	Timeout  time.Duration     `json:"timeout" default:"5s"`
+	Hosts    []string          `json:"hosts" default:"a,b"`
+	Labels   map[string]string `json:"labels" default:"k1=v1,k2=v2"`
+	DB       defaultsDB        `json:"db"`
+	Replicas []defaultsDB      `json:"replicas"`
+	Backup   *defaultsDB       `json:"backup"`
+}
+
+func (conf *defaultsConf) FromFile() (string, []string) {
+	return "defaults.json", []string{"app"}
+}
+
+func Test_applyDefaults(t *testing.T) {
+	conf := new(defaultsConf)
+	conf.Level = "WARN"
+	assert.Nil(t, applyDefaults(reflect.ValueOf(conf)))
+	assert.Equal(t, &defaultsConf{
+		Level:   "WARN",
+		Debug:   true,
+		Timeout: 5 * time.Second,
+		Hosts:   []string{"a", "b"},
+		Labels:  map[string]string{"k1": "v1", "k2": "v2"},
+		DB:      defaultsDB{Port: 3306},
+	}, conf, "value set in code wins, nil pointer stays nil")
+
+	conf.Backup = &defaultsDB{URL: "backup"}
+	assert.Nil(t, applyDefaults(reflect.ValueOf(conf)))
+	assert.Equal(t, &defaultsDB{URL: "backup", Port: 3306}, conf.Backup)
+
+	bad := new(struct {
+		Size int `default:"ten"`
+	})
+	assert.NotNil(t, applyDefaults(reflect.ValueOf(bad)))
+}
+
+func TestInit_defaults(t *testing.T) {
+	f := testingX.MockFile("defaults.json", `{"app": {
+		"debug": false,
+		"db": {"url": "primary"},
+		"replicas": [{"url": "r1"}, {"url": "r2", "port": 0}]
+	}}`)
+	defer f.Remove()
+
+	conf := new(defaultsConf)
+	assert.Nil(t, Init(conf))
+	assert.Equal(t, "INFO", conf.Level)
+	assert.Equal(t, false, conf.Debug, "explicit false is not unset")
+	assert.Equal(t, defaultsDB{URL: "primary", Port: 3306}, conf.DB)
+	assert.Equal(t, []defaultsDB{{URL: "r1", Port: 3306}, {URL: "r2", Port: 0}}, conf.Replicas)
+	assert.Nil(t, conf.Backup, "optional section is not provided")
+
+	f2 := testingX.MockFile("defaults.json", `{"app": {"backup": {"url": "backup"}}}`)
+	defer f2.Remove()
+	conf = new(defaultsConf)
+	assert.Nil(t, Init(conf))
+	assert.Equal(t, &defaultsDB{URL: "backup", Port: 3306}, conf.Backup)
+}
+
+func TestDefaultValues(t *testing.T) {
+	assert.Equal(t, []DefaultValue{
+		{Key: "backup.port", Value: "3306", Type: "int"},
+		{Key: "db.port", Value: "3306", Type: "int"},
+		{Key: "debug", Value: "true", Type: "bool"},
+		{Key: "hosts", Value: "a,b", Type: "[]string"},
+		{Key: "labels", Value: "k1=v1,k2=v2", Type: "map[string]string"},
+		{Key: "level", Value: "INFO", Type: "string"},
+		{Key: "replicas[].port", Value: "3306", Type: "int"},
+		{Key: "timeout", Value: "5s", Type: "time.Duration"},
+	}, DefaultValues(new(defaultsConf)))
+}
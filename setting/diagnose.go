@@ -0,0 +1,160 @@
+package setting
+
+import (
+	"fmt"
+	"io/ioutil"
+	"qing/go-helper/error"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 解析或者绑定配置失败时, 返回的 *errPkg.Err 带上定位问题需要的字段:
+//   file, line, column: 出错的位置, 行列从 1 开始, 只有来自配置文件时才有
+//   key: 出错的 key 的完整路径, 包括 sections, 形如 services.db.replicas[1].port
+//   expected: 字段的 Go 类型
+//   found: 配置中的值 (密钥引用不会被解析成明文)
+//   snippet: 出错的那一行, 用 ^ 标出列, 比如
+//
+//     3 |   "port": "abc",
+//       |   ^
+//
+// 语法错误只有 file, line, column 和 snippet, 没有 key
+func bindSourceFail(err error, tree *SourceTree, origin func(segs []interface{}) Origin, msg string, fields errPkg.Fields) *errPkg.Err {
+	failed := findBindFail(err)
+	if failed == nil {
+		return errPkg.FailBy(err, msg, fields)
+	}
+	if fields == nil {
+		fields = make(errPkg.Fields)
+	}
+
+	key, _ := failed.Fields["key"].(string)
+	segs, parseErr := parseKeyPath(key)
+	if parseErr != nil {
+		fields["key"] = joinPath(formatKeyPath(tree.prefix), key)
+		segs = nil
+	} else {
+		fields["key"] = formatKeyPath(append(append([]interface{}{}, tree.prefix...), segs...))
+	}
+	fields["expected"] = failed.Fields["type"]
+	fields["found"] = failed.Fields["value"]
+
+	o := locateKey(tree, origin, segs)
+	if o.Kind != OriginFile {
+		fields["origin"] = o.String()
+		return errPkg.FailBy(err, msg, fields)
+	}
+	fields["file"], fields["line"], fields["column"] = o.Name, o.Line, o.Column
+	if content, readErr := ioutil.ReadFile(o.Name); readErr == nil {
+		fields["snippet"] = snippet(content, o.Line, o.Column)
+	}
+	return errPkg.FailBy(err, msg, fields)
+}
+
+// 配置文件解析失败, cause 中有行列时 (见各个 Decoder 的 line, column 字段) 加上出错的那一行
+func decodeFail(err error, path string, content []byte) *errPkg.Err {
+	fields := errPkg.Fields{"file": path}
+	if line, column := errorPosition(err); line > 0 {
+		if column <= 0 {
+			column = firstColumn(content, line)
+		}
+		fields["line"], fields["column"] = line, column
+		fields["snippet"] = snippet(content, line, column)
+	}
+	return errPkg.FailBy(err, "unmarshal config file's content bytes to confObj fail.", fields)
+}
+
+// cause 链中最外层的绑定错误, 它的 key 是出错的字段
+func findBindFail(err error) *errPkg.Err {
+	for err != nil {
+		e, ok := err.(*errPkg.Err)
+		if !ok {
+			return nil
+		}
+		if e.Msg == bindFailMsg {
+			return e
+		}
+		err = e.Cause
+	}
+	return nil
+}
+
+func errorPosition(err error) (line, column int) {
+	for err != nil {
+		e, ok := err.(*errPkg.Err)
+		if !ok {
+			return 0, 0
+		}
+		if line, ok = e.Fields["line"].(int); ok {
+			column, _ = e.Fields["column"].(int)
+			return line, column
+		}
+		err = e.Cause
+	}
+	return 0, 0
+}
+
+// key 的来源: 最后一个包含该 key 的层, 没有位置时 (比如数组中的标量, 或者被 interpolate 展开的值) 向上找父节点
+// 来自文件但整条路径都没有位置时, 就是文件的开始
+func locateKey(tree *SourceTree, origin func(segs []interface{}) Origin, segs []interface{}) Origin {
+	var o Origin
+	for n := len(segs); n >= 0; n-- {
+		o = originOf(tree, origin, segs[:n])
+		if o.Kind != OriginFile || o.Line > 0 {
+			return o
+		}
+	}
+	o.Line, o.Column = 1, 1
+	return o
+}
+
+func originOf(tree *SourceTree, origin func(segs []interface{}) Origin, segs []interface{}) Origin {
+	for i := len(tree.Layers) - 1; i >= 0; i-- {
+		layer := tree.Layers[i]
+		if _, _, ok := lookupSection(layer.Node, segs); ok && layer.Origin != nil {
+			return layer.Origin(segs)
+		}
+	}
+	if len(tree.Layers) > 0 {
+		// 所有的层中都没有该 key (比如缺少 required 的 key), 继续找父节点
+		return Origin{Kind: OriginFile}
+	}
+	if tree.Origin != nil {
+		return tree.Origin(segs)
+	}
+	return origin(segs)
+}
+
+func snippet(content []byte, line, column int) string {
+	lines := strings.Split(string(content), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	text := strings.TrimRight(lines[line-1], "\r")
+	gutter := strconv.Itoa(line)
+
+	// 保留 tab, 使 ^ 和出错的字符对齐
+	var pad strings.Builder
+	for i, r := range []rune(text) {
+		if i >= column-1 {
+			break
+		}
+		if r == '\t' {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+	}
+	return fmt.Sprintf("%s | %s\n%s | %s^", gutter, text, strings.Repeat(" ", len(gutter)), pad.String())
+}
+
+// 只有行号时 (比如 ini 和 properties), 指向该行第一个非空白字符
+func firstColumn(content []byte, line int) int {
+	lines := strings.Split(string(content), "\n")
+	if line < 1 || line > len(lines) {
+		return 1
+	}
+	text := lines[line-1]
+	return utf8.RuneCountInString(text[:len(text)-len(strings.TrimLeft(text, " \t\f"))]) + 1
+}
@@ -0,0 +1,104 @@
+package setting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"qing/go-helper/error"
+	"testing"
+)
+
+type diagnoseConf struct {
+	Replicas []struct {
+		URL  string `json:"url"`
+		Port int    `json:"port"`
+	} `json:"replicas"`
+}
+
+func Test_bindSourceFail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-diagnose")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.json")
+	content := "{\n  \"services\": {\n    \"db\": {\n      \"replicas\": [\n        {\"url\": \"a\", \"port\": 1},\n        {\"url\": \"b\",\n         \"port\": \"abc\"}\n      ]\n    }\n  }\n}\n"
+	if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Load[diagnoseConf](WithSources(OriginFile), WithFile(path, "services.db"))
+	known := findField(err, "key")
+	if assert.NotNil(t, known) {
+		assert.Equal(t, "services.db.replicas[1].port", known.Fields["key"])
+		assert.Equal(t, path, known.Fields["file"])
+		assert.Equal(t, 7, known.Fields["line"])
+		assert.Equal(t, 10, known.Fields["column"])
+		assert.Equal(t, "int", known.Fields["expected"])
+		assert.Equal(t, `"abc"`, known.Fields["found"])
+		assert.Equal(t, "7 |          \"port\": \"abc\"}\n  |          ^", known.Fields["snippet"])
+	}
+
+	// 不是来自文件的 key 没有行列
+	os.Setenv("DIAGNOSE_PORT", "abc")
+	defer os.Unsetenv("DIAGNOSE_PORT")
+	_, err = Load[loadConf](WithSources(OriginEnv), WithEnvPrefix("DIAGNOSE"))
+	known = findField(err, "key")
+	if assert.NotNil(t, known) {
+		assert.Equal(t, "port", known.Fields["key"])
+		assert.Equal(t, "env DIAGNOSE_PORT", known.Fields["origin"])
+		assert.Nil(t, known.Fields["line"])
+	}
+}
+
+func Test_decodeFail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-diagnose")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		file, content string
+		line, column  int
+		snippet       string
+	}{
+		{"a.json", "{\n  \"url\": \"a\",\n  \"port\" 1\n}", 3, 11, "3 |   \"port\" 1\n  |           ^"},
+		{"a.toml", "url = \"a\"\nport = = 1\n", 2, 8, "2 | port = = 1\n  |        ^"},
+		{"a.ini", "[db]\n  url\n", 2, 3, "2 |   url\n  |   ^"},
+	}
+	for _, c := range cases {
+		path := filepath.Join(dir, c.file)
+		if err = ioutil.WriteFile(path, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err = Load[diagnoseConf](WithSources(OriginFile), WithFile(path))
+		known := findField(err, "snippet")
+		if assert.NotNil(t, known, c.file) {
+			assert.Equal(t, path, known.Fields["file"], c.file)
+			assert.Equal(t, c.line, known.Fields["line"], c.file)
+			assert.Equal(t, c.column, known.Fields["column"], c.file)
+			assert.Equal(t, c.snippet, known.Fields["snippet"], c.file)
+		}
+	}
+}
+
+// cause 链 (包括字段中的 error, 见 "do unmarshal fail.") 中第一个有 field 字段的 error
+func findField(err error, field string) *errPkg.Err {
+	known, ok := err.(*errPkg.Err)
+	if !ok {
+		return nil
+	}
+	if _, ok = known.Fields[field]; ok {
+		return known
+	}
+	for _, value := range known.Fields {
+		if sub, ok := value.(error); ok {
+			if found := findField(sub, field); found != nil {
+				return found
+			}
+		}
+	}
+	return findField(known.Cause, field)
+}
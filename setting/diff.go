@@ -0,0 +1,157 @@
+package setting
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// 配置的变化, 在热加载 (见 Watch, WatchAll) 和对同一个 confObj 再次 Init (包括 LoadAll) 时计算
+// key 是叶子字段的路径, 同 Explain; 元素是标量的数组作为一个值比较, map 按 key 比较
+// Secret 类型的字段和通过密钥引用 (见 secret.go) 得到的值被遮盖, 但仍然按明文比较, 所以只改变密钥也会出现在 Changed 中
+type Diff struct {
+	Added   []Change `json:"added,omitempty"`
+	Removed []Change `json:"removed,omitempty"`
+	Changed []Change `json:"changed,omitempty"`
+}
+
+type Change struct {
+	Key string      `json:"key"`
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+	// 新值的来源, 被删除的 key 是旧值的来源
+	Source Origin `json:"source"`
+}
+
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// 每个变化一行
+//   + db.port = 3307
+//   - db.replicas[1].url = mysql://b
+//   ~ db.url: mysql://a -> mysql://c
+func (d Diff) String() string {
+	var bf strings.Builder
+	for _, c := range d.Added {
+		fmt.Fprintf(&bf, "+ %s = %v\n", c.Key, c.New)
+	}
+	for _, c := range d.Removed {
+		fmt.Fprintf(&bf, "- %s = %v\n", c.Key, c.Old)
+	}
+	for _, c := range d.Changed {
+		fmt.Fprintf(&bf, "~ %s: %v -> %v\n", c.Key, c.Old, c.New)
+	}
+	return bf.String()
+}
+
+// 比较两个同类型的 confObj (指针), 值的来源和是否敏感使用它们最近一次 Init 时记录的信息, 见 Explain
+func Compare(old, new interface{}) Diff {
+	return compareLeaves(snapshotLeaves(old), snapshotLeaves(new))
+}
+
+type leafSnapshot struct {
+	key       string
+	value     interface{}
+	raw       interface{}
+	origin    Origin
+	sensitive bool
+}
+
+// confObj 所有非 nil 的叶子, 按 walkLeaves 的顺序; raw 是深拷贝, 之后对 confObj 的修改 (比如再次 Init) 不影响它
+func snapshotLeaves(v interface{}) []leafSnapshot {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil
+	}
+	prov := newProvenance()
+	if p, ok := provenances.Load(v); ok {
+		prov = p.(*provenance)
+	}
+
+	leaves := make([]leafSnapshot, 0)
+	var add func(leaf reflect.Value, segs []interface{}, field *reflect.StructField)
+	add = func(leaf reflect.Value, segs []interface{}, field *reflect.StructField) {
+		if leaf.Kind() == reflect.Ptr && leaf.IsNil() {
+			return
+		}
+		// 元素是标量的 map 在 Explain 中是一个值, 这里按 key 展开, 以便看出增删了哪些 key
+		if leaf.Kind() == reflect.Map && !leaf.Type().Implements(textMarshalerType) {
+			keys := leaf.MapKeys()
+			sort.Slice(keys, func(i, j int) bool {
+				return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+			})
+			for _, k := range keys {
+				add(leaf.MapIndex(k), appendSeg(segs, fmt.Sprint(k)), nil)
+			}
+			return
+		}
+		snapshot := leafSnapshot{
+			key:       formatKeyPath(segs),
+			value:     explainValue(leaf),
+			raw:       cloneValue(leaf).Interface(),
+			origin:    Origin{Kind: presetOrDefault(leaf, field)},
+			sensitive: isSecretType(leaf.Type()),
+		}
+		if record := prov.lookup(segs); record != nil {
+			snapshot.origin = record.origin
+			snapshot.sensitive = snapshot.sensitive || record.sensitive()
+		}
+		leaves = append(leaves, snapshot)
+	}
+	walkLeaves(rv.Elem(), nil, nil, add)
+	return leaves
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+func compareLeaves(old, new []leafSnapshot) Diff {
+	olds := make(map[string]leafSnapshot, len(old))
+	for _, leaf := range old {
+		olds[leaf.key] = leaf
+	}
+	news := make(map[string]bool, len(new))
+
+	var d Diff
+	for _, leaf := range new {
+		news[leaf.key] = true
+		before, ok := olds[leaf.key]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, Change{Key: leaf.key, New: leaf.display(), Source: leaf.origin})
+		case !reflect.DeepEqual(before.raw, leaf.raw):
+			// 任何一边敏感时, 两边都遮盖
+			oldValue, newValue := before.display(), leaf.display()
+			if before.sensitive || leaf.sensitive {
+				oldValue, newValue = maskedValue, maskedValue
+			}
+			d.Changed = append(d.Changed, Change{Key: leaf.key, Old: oldValue, New: newValue, Source: leaf.origin})
+		}
+	}
+	for _, leaf := range old {
+		if !news[leaf.key] {
+			d.Removed = append(d.Removed, Change{Key: leaf.key, Old: leaf.display(), Source: leaf.origin})
+		}
+	}
+	return d
+}
+
+func (leaf leafSnapshot) display() interface{} {
+	if leaf.sensitive {
+		return maskedValue
+	}
+	return leaf.value
+}
+
+// 配置的摘要, 用于审计日志中区分不同版本的配置; 敏感的值只参与遮盖后的计算, 不会从摘要中被推测出来
+func checksumLeaves(leaves []leafSnapshot) string {
+	h := sha256.New()
+	for _, leaf := range leaves {
+		fmt.Fprintf(h, "%s=%#v\n", leaf.key, leaf.display())
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,60 @@
+package setting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type diffConf struct {
+	URL      string            `json:"url"`
+	Password Secret            `json:"password"`
+	Timeout  time.Duration     `json:"timeout"`
+	Hosts    []string          `json:"hosts"`
+	Labels   map[string]string `json:"labels"`
+	Replica  *struct {
+		URL string `json:"url"`
+	} `json:"replica"`
+}
+
+func Test_Compare(t *testing.T) {
+	old := &diffConf{
+		URL:      "mysql://a",
+		Password: "p1",
+		Timeout:  time.Second,
+		Hosts:    []string{"a", "b"},
+		Labels:   map[string]string{"zone": "sh", "tier": "db"},
+	}
+	new := &diffConf{
+		URL:      "mysql://a",
+		Password: "p2",
+		Timeout:  2 * time.Second,
+		Hosts:    []string{"a", "c"},
+		Labels:   map[string]string{"zone": "bj"},
+		Replica: &struct {
+			URL string `json:"url"`
+		}{URL: "mysql://b"},
+	}
+
+	diff := Compare(old, new)
+	assert.Equal(t, []Change{
+		{Key: "replica.url", New: "mysql://b", Source: Origin{Kind: OriginPreset}},
+	}, diff.Added)
+	assert.Equal(t, []Change{
+		{Key: "labels.tier", Old: "db", Source: Origin{Kind: OriginPreset}},
+	}, diff.Removed)
+	assert.Equal(t, []Change{
+		{Key: "password", Old: maskedValue, New: maskedValue, Source: Origin{Kind: OriginPreset}},
+		{Key: "timeout", Old: "1s", New: "2s", Source: Origin{Kind: OriginPreset}},
+		{Key: "hosts", Old: []string{"a", "b"}, New: []string{"a", "c"}, Source: Origin{Kind: OriginPreset}},
+		{Key: "labels.zone", Old: "sh", New: "bj", Source: Origin{Kind: OriginPreset}},
+	}, diff.Changed)
+	assert.Equal(t, "+ replica.url = mysql://b\n"+
+		"- labels.tier = db\n"+
+		"~ password: ****** -> ******\n"+
+		"~ timeout: 1s -> 2s\n"+
+		"~ hosts: [a b] -> [a c]\n"+
+		"~ labels.zone: sh -> bj\n", diff.String())
+
+	assert.True(t, Compare(old, old).Empty())
+}
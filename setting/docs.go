@@ -0,0 +1,597 @@
+package setting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"qing/go-helper/error"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 根据 confObj 的定义 (FromFile 的 sections, default, validate 和 desc tag) 生成文档:
+//   GenerateSchema      JSON Schema, 用于编辑器提示和 CI 校验, 见 schema.go
+//   GenerateMarkdown    Markdown 格式的配置项说明
+//   SampleConfig        带注释的示例配置文件, 支持 .json (json 没有注释), .toml, .ini, .properties, .env
+//   GenerateDocs        以上全部写入一个目录, 配合 cmd/setting-doc 在 go generate 中使用
+
+var sampleExts = []string{".env", ".ini", ".json", ".properties", ".toml"}
+
+// 将 objs 的文档写入 dir, 按配置文件分组, 比如 conf.json:
+//   conf.schema.json
+//   conf.sample.json, conf.sample.toml, conf.sample.ini, conf.sample.properties, conf.sample.env
+// 以及所有 objs 的 config.md
+func GenerateDocs(dir string, objs ...interface{}) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errPkg.FailBy(err, "create docs dir fail.", errPkg.Fields{"dir": dir})
+	}
+
+	paths := make([]string, 0)
+	groups := make(map[string][]interface{})
+	for _, v := range objs {
+		f, ok := v.(FromFile)
+		if !ok {
+			continue
+		}
+		path, _ := f.FromFile()
+		if _, ok := groups[path]; !ok {
+			paths = append(paths, path)
+		}
+		groups[path] = append(groups[path], v)
+	}
+
+	write := func(name string, content []byte) error {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			return errPkg.FailBy(err, "write docs fail.", errPkg.Fields{"file": filepath.Join(dir, name)})
+		}
+		return nil
+	}
+	for _, path := range paths {
+		base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		schema, err := GenerateSchema(filepath.Base(path), groups[path]...).JSON()
+		if err != nil {
+			return errPkg.FailBy(err, "marshal json schema fail.", errPkg.Fields{"file": path})
+		}
+		if err = write(base+".schema.json", append(schema, '\n')); err != nil {
+			return err
+		}
+		for _, ext := range sampleExts {
+			sample, err := SampleConfig(ext, groups[path]...)
+			if err != nil {
+				return err
+			}
+			if err = write(base+".sample"+ext, sample); err != nil {
+				return err
+			}
+		}
+	}
+	return write("config.md", []byte(GenerateMarkdown(objs...)))
+}
+
+// 每个 confObj 一张表, key 是配置文件中从根节点开始的路径, 数组元素的字段写作 replicas[].url, map 的值写作 dbs.*.url
+func GenerateMarkdown(objs ...interface{}) string {
+	var bf bytes.Buffer
+	bf.WriteString("# Configuration\n")
+	for _, v := range objs {
+		t := reflect.TypeOf(v)
+		fmt.Fprintf(&bf, "\n## %s\n\n", t.String())
+
+		sections := fileSections(v)
+		if f, ok := v.(FromFile); ok {
+			path, _ := f.FromFile()
+			fmt.Fprintf(&bf, "- file: `%s`\n", path)
+			if len(sections) > 0 {
+				fmt.Fprintf(&bf, "- section: `%s`\n", formatKeyPath(sections))
+			}
+		}
+		envPrefix, hasEnv := "", false
+		if e, ok := v.(FromOsEnvs); ok {
+			envPrefix, hasEnv = e.FromOsEnvs(), true
+			fmt.Fprintf(&bf, "- env prefix: `%s`\n", envPrefix)
+		}
+		if a, ok := v.(FromOsArgs); ok {
+			fmt.Fprintf(&bf, "- flag prefix: `%s`\n", a.FromOsArgs())
+		}
+		if n, ok := v.(FromApollo); ok {
+			fmt.Fprintf(&bf, "- config center namespace: `%s`\n", n.FromApollo())
+		}
+
+		bf.WriteString("\n| Key | Type | Default | Validate |")
+		if hasEnv {
+			bf.WriteString(" Env |")
+		}
+		bf.WriteString(" Description |\n|---|---|---|---|")
+		if hasEnv {
+			bf.WriteString("---|")
+		}
+		bf.WriteString("---|\n")
+
+		for _, row := range docRows(t, sections) {
+			fmt.Fprintf(&bf, "| `%s` | `%s` | %s | %s |", row.key, row.typ, markdownCode(row.defaultValue), markdownCode(row.validate))
+			if hasEnv {
+				env := ""
+				if row.envSegs != nil {
+					env = envName(envPrefix, row.envSegs)
+				}
+				fmt.Fprintf(&bf, " %s |", markdownCode(env))
+			}
+			fmt.Fprintf(&bf, " %s |\n", markdownEscape(row.desc))
+		}
+	}
+	return bf.String()
+}
+
+type docRow struct {
+	key          string
+	typ          string
+	defaultValue string
+	validate     string
+	desc         string
+	// 可以通过环境变量赋值时, 相对于 confObj 的路径
+	envSegs []string
+}
+
+func docRows(t reflect.Type, sections []interface{}) []docRow {
+	rows := make([]docRow, 0)
+	collectDocRows(t, formatKeyPath(sections), []string{}, &rows, make(map[reflect.Type]bool))
+	return rows
+}
+
+// envSegs 为 nil 表示在数组或 map 中, 不能通过环境变量赋值
+func collectDocRows(t reflect.Type, path string, envSegs []string, rows *[]docRow, visiting map[reflect.Type]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if isLeafType(t) {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		collectDocRows(t.Elem(), path+"[]", nil, rows, visiting)
+		return
+	case reflect.Map:
+		collectDocRows(t.Elem(), joinPath(path, "*"), nil, rows, visiting)
+		return
+	case reflect.Struct:
+	default:
+		return
+	}
+	if visiting[t] {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for _, f := range structFields(t) {
+		key := joinPath(path, f.name)
+		var segs []string
+		if envSegs != nil {
+			segs = append(append([]string{}, envSegs...), f.name)
+		}
+		row := docRow{
+			key:      key,
+			typ:      f.field.Type.String(),
+			validate: f.field.Tag.Get(validateTag),
+			desc:     f.field.Tag.Get(descTag),
+		}
+		if isLeafType(f.field.Type) {
+			row.envSegs = segs
+		}
+		if literal, ok := f.field.Tag.Lookup(defaultTag); ok {
+			row.defaultValue = literal
+		}
+		*rows = append(*rows, row)
+		collectDocRows(f.field.Type, key, segs, rows, visiting)
+	}
+}
+
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "`" + strings.Replace(s, "|", `\|`, -1) + "`"
+}
+
+func markdownEscape(s string) string {
+	return strings.Replace(strings.Replace(s, "|", `\|`, -1), "\n", "<br>", -1)
+}
+
+// 示例配置文件中的一个节点
+type sampleNode struct {
+	key      string
+	comments []string
+	// 叶子的值, 见 jsonValue
+	value interface{}
+	// 对象的字段; array 为 true 时, 是数组中一个元素的字段
+	children []*sampleNode
+	array    bool
+	leaf     bool
+}
+
+func (node *sampleNode) child(key string) *sampleNode {
+	for _, c := range node.children {
+		if c.key == key && !c.leaf {
+			return c
+		}
+	}
+	c := &sampleNode{key: key}
+	node.children = append(node.children, c)
+	return c
+}
+
+// 生成带注释的示例配置文件, 值为默认值 (没有默认值时为零值), ext 见 sampleExts
+// objs 应该使用同一个配置文件
+func SampleConfig(ext string, objs ...interface{}) ([]byte, error) {
+	root := &sampleNode{}
+	for _, v := range objs {
+		node := root
+		for _, seg := range fileSections(v) {
+			if _, ok := seg.(int); ok {
+				// 数组下标: 示例中写作只有一个元素的数组
+				node.array = true
+				continue
+			}
+			node = node.child(fmt.Sprint(seg))
+		}
+		node.children = append(node.children, sampleFields(reflect.TypeOf(v), make(map[reflect.Type]bool))...)
+	}
+
+	var bf bytes.Buffer
+	switch normalizeExt(ext) {
+	case ".json":
+		writeSampleJSON(&bf, root, "")
+		bf.WriteByte('\n')
+	case ".toml":
+		writeSampleTOML(&bf, root, nil)
+	case ".ini":
+		writeSampleINI(&bf, root, nil)
+	case ".properties":
+		writeSampleProperties(&bf, root, nil)
+	case ".env":
+		writeSampleDotenv(&bf, root, nil)
+	default:
+		return nil, errPkg.Fail("sample config is not supported for format.", errPkg.Fields{
+			"ext":       ext,
+			"supported": sampleExts,
+		})
+	}
+	return bf.Bytes(), nil
+}
+
+func sampleFields(t reflect.Type, visiting map[reflect.Type]bool) []*sampleNode {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	nodes := make([]*sampleNode, 0)
+	if t.Kind() != reflect.Struct || visiting[t] {
+		return nodes
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for _, f := range structFields(t) {
+		ft := f.field.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		node := &sampleNode{key: f.name, comments: fieldComments(f.field)}
+		switch {
+		case isLeafType(ft):
+			node.leaf = true
+			if literal, ok := f.field.Tag.Lookup(defaultTag); ok {
+				node.value = typedDefault(literal, ft)
+			} else {
+				node.value = jsonValue(reflect.New(ft).Elem())
+			}
+		case ft.Kind() == reflect.Struct:
+			if visiting[ft] {
+				continue
+			}
+			node.children = sampleFields(ft, visiting)
+		case ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array:
+			node.array = true
+			node.children = sampleFields(ft.Elem(), visiting)
+		case ft.Kind() == reflect.Map:
+			// map 的 key 是任意的, 示例中写作 example
+			example := &sampleNode{key: "example", children: sampleFields(ft.Elem(), visiting)}
+			node.children = []*sampleNode{example}
+		default:
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func fieldComments(field reflect.StructField) []string {
+	comments := make([]string, 0, 2)
+	if desc := field.Tag.Get(descTag); desc != "" {
+		comments = append(comments, strings.Split(desc, "\n")...)
+	}
+	attrs := []string{"type: " + field.Type.String()}
+	if literal, ok := field.Tag.Lookup(defaultTag); ok {
+		attrs = append(attrs, "default: "+literal)
+	}
+	if tag := field.Tag.Get(validateTag); tag != "" {
+		attrs = append(attrs, "validate: "+tag)
+	}
+	return append(comments, strings.Join(attrs, ", "))
+}
+
+func writeComments(bf *bytes.Buffer, comments []string, mark, indent string) {
+	for _, c := range comments {
+		bf.WriteString(indent + mark + " " + c + "\n")
+	}
+}
+
+func writeSampleJSON(bf *bytes.Buffer, node *sampleNode, indent string) {
+	if node.leaf {
+		bs, _ := json.Marshal(node.value)
+		bf.Write(bs)
+		return
+	}
+	if node.array {
+		bf.WriteString("[\n" + indent + "  ")
+		writeSampleJSON(bf, &sampleNode{children: node.children}, indent+"  ")
+		bf.WriteString("\n" + indent + "]")
+		return
+	}
+	if len(node.children) == 0 {
+		bf.WriteString("{}")
+		return
+	}
+	bf.WriteString("{\n")
+	for i, c := range node.children {
+		key, _ := json.Marshal(c.key)
+		bf.WriteString(indent + "  " + string(key) + ": ")
+		writeSampleJSON(bf, c, indent+"  ")
+		if i < len(node.children)-1 {
+			bf.WriteByte(',')
+		}
+		bf.WriteByte('\n')
+	}
+	bf.WriteString(indent + "}")
+}
+
+// 先写叶子, 再写 [table] 和 [[array]]
+func writeSampleTOML(bf *bytes.Buffer, node *sampleNode, path []string) {
+	for _, c := range node.children {
+		if c.leaf {
+			writeComments(bf, c.comments, "#", "")
+			bf.WriteString(tomlKey(c.key) + " = " + tomlValue(c.value) + "\n\n")
+		}
+	}
+	for _, c := range node.children {
+		if c.leaf {
+			continue
+		}
+		sub := append(append([]string{}, path...), c.key)
+		keys := make([]string, len(sub))
+		for i, k := range sub {
+			keys[i] = tomlKey(k)
+		}
+		writeComments(bf, c.comments, "#", "")
+		if c.array {
+			bf.WriteString("[[" + strings.Join(keys, ".") + "]]\n")
+		} else {
+			bf.WriteString("[" + strings.Join(keys, ".") + "]\n")
+		}
+		writeSampleTOML(bf, c, sub)
+	}
+}
+
+func tomlKey(key string) string {
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return strconv.Quote(key)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+	return key
+}
+
+func tomlValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return `""`
+	case string:
+		return strconv.Quote(value)
+	case float32, float64:
+		s := fmt.Sprint(value)
+		if !strings.ContainsAny(s, ".eEn") {
+			s += ".0"
+		}
+		return s
+	case []interface{}:
+		items := make([]string, len(value))
+		for i, item := range value {
+			items[i] = tomlValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		keys := sortedKeys(value)
+		pairs := make([]string, len(keys))
+		for i, k := range keys {
+			pairs[i] = tomlKey(k) + " = " + tomlValue(value[k])
+		}
+		if len(pairs) == 0 {
+			return "{}"
+		}
+		return "{ " + strings.Join(pairs, ", ") + " }"
+	}
+	return fmt.Sprint(v)
+}
+
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 先写叶子, 再写 [section]; map 写作子 section, 数组写作逗号分隔的值
+// ini 不能表示对象的数组, 只写一条注释
+func writeSampleINI(bf *bytes.Buffer, node *sampleNode, path []string) {
+	maps := make([]*sampleNode, 0)
+	for _, c := range node.children {
+		if !c.leaf {
+			continue
+		}
+		if obj, ok := c.value.(map[string]interface{}); ok {
+			m := &sampleNode{key: c.key, comments: c.comments}
+			for _, k := range sortedKeys(obj) {
+				m.children = append(m.children, &sampleNode{key: k, value: obj[k], leaf: true})
+			}
+			maps = append(maps, m)
+			continue
+		}
+		writeComments(bf, c.comments, ";", "")
+		bf.WriteString(c.key + " = " + flatValue(c.value, true) + "\n\n")
+	}
+	for _, c := range append(maps, node.children...) {
+		if c.leaf {
+			continue
+		}
+		sub := append(append([]string{}, path...), c.key)
+		writeComments(bf, c.comments, ";", "")
+		if c.array {
+			bf.WriteString("; " + strings.Join(sub, ".") + ": array of objects is not supported in ini, use json or toml\n\n")
+			continue
+		}
+		bf.WriteString("[" + strings.Join(sub, ".") + "]\n")
+		writeSampleINI(bf, c, sub)
+	}
+}
+
+func writeSampleProperties(bf *bytes.Buffer, node *sampleNode, path []string) {
+	for _, c := range node.children {
+		key := strings.Join(append(append([]string{}, path...), c.key), ".")
+		writeComments(bf, c.comments, "#", "")
+		switch {
+		case c.array:
+			bf.WriteString("# " + key + ": array of objects is not supported in properties, use json or toml\n\n")
+		case !c.leaf:
+			writeSampleProperties(bf, c, append(append([]string{}, path...), c.key))
+		default:
+			if obj, ok := c.value.(map[string]interface{}); ok {
+				if len(obj) == 0 {
+					bf.WriteString("# " + key + ".<key> = <value>\n\n")
+				}
+				for _, k := range sortedKeys(obj) {
+					bf.WriteString(escapeProperties(key+"."+k, true) + " = " + escapeProperties(flatValue(obj[k], false), false) + "\n")
+				}
+				continue
+			}
+			bf.WriteString(escapeProperties(key, true) + " = " + escapeProperties(flatValue(c.value, false), false) + "\n\n")
+		}
+	}
+}
+
+// 变量名按 FromOsEnvs 的规则由 key 路径得到 (见 envName), 数组写作逗号分隔的值, map 写作 k1=v1,k2=v2 (见 defaultNode)
+// .env 不能表示对象的数组, 只写一条注释
+func writeSampleDotenv(bf *bytes.Buffer, node *sampleNode, path []string) {
+	for _, c := range node.children {
+		sub := append(append([]string{}, path...), c.key)
+		name := envName("", sub)
+		writeComments(bf, c.comments, "#", "")
+		switch {
+		case c.array:
+			bf.WriteString("# " + name + ": array of objects is not supported in .env, use json or toml\n\n")
+		case !c.leaf:
+			writeSampleDotenv(bf, c, sub)
+		default:
+			value := flatValue(c.value, false)
+			if items, ok := c.value.([]interface{}); ok {
+				parts := make([]string, len(items))
+				for i, item := range items {
+					parts[i] = flatValue(item, false)
+				}
+				value = strings.Join(parts, ",")
+			}
+			if obj, ok := c.value.(map[string]interface{}); ok {
+				if len(obj) == 0 {
+					bf.WriteString("# " + name + "=<key>=<value>,<key>=<value>\n\n")
+					continue
+				}
+				pairs := make([]string, 0, len(obj))
+				for _, k := range sortedKeys(obj) {
+					pairs = append(pairs, k+"="+flatValue(obj[k], false))
+				}
+				value = strings.Join(pairs, ",")
+			}
+			bf.WriteString(name + "=" + dotenvValue(value) + "\n\n")
+		}
+	}
+}
+
+// 包含空白, 引号, # 或 $ 的值写在双引号中并转义, 见 dotenvParser.quoted
+func dotenvValue(s string) string {
+	if !strings.ContainsAny(s, " \t\n\r#'\"$\\") {
+		return s
+	}
+	var bf strings.Builder
+	bf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\', '$':
+			bf.WriteString(`\` + string(r))
+		case '\n':
+			bf.WriteString(`\n`)
+		case '\r':
+			bf.WriteString(`\r`)
+		case '\t':
+			bf.WriteString(`\t`)
+		default:
+			bf.WriteRune(r)
+		}
+	}
+	bf.WriteByte('"')
+	return bf.String()
+}
+
+// ini / properties 中的值, 数组以逗号分隔, 见 toItems
+func flatValue(v interface{}, quote bool) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, len(value))
+		for i, item := range value {
+			items[i] = flatValue(item, false)
+		}
+		v = strings.Join(items, ", ")
+	}
+	s := fmt.Sprint(v)
+	if quote && (strings.ContainsAny(s, ";#\"'") || strings.TrimSpace(s) != s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func escapeProperties(s string, isKey bool) string {
+	var bf strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			bf.WriteString(`\\`)
+		case r == '\n':
+			bf.WriteString(`\n`)
+		case r == '\t':
+			bf.WriteString(`\t`)
+		case isKey && strings.ContainsRune("=: ", r), !isKey && i == 0 && r == ' ':
+			bf.WriteString(`\` + string(r))
+		default:
+			bf.WriteRune(r)
+		}
+	}
+	return bf.String()
+}
@@ -0,0 +1,130 @@
+package setting
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type docsDB struct {
+	URL  string `json:"url" validate:"required,url" desc:"数据库地址"`
+	Port int    `json:"port" default:"3306" validate:"min=1,max=65535"`
+}
+
+type docsConf struct {
+	Level    string            `json:"level" default:"INFO" validate:"oneof=DEBUG INFO WARN" desc:"日志级别"`
+	Timeout  time.Duration     `json:"timeout" default:"5s"`
+	Hosts    []string          `json:"hosts" default:"a,b" validate:"min=1"`
+	Labels   map[string]string `json:"labels" default:"k1=v1"`
+	Password Secret            `json:"password"`
+	DB       docsDB            `json:"db"`
+	Replicas []docsDB          `json:"replicas"`
+}
+
+func (conf *docsConf) FromFile() (string, []string) {
+	return "docs.json", []string{"app"}
+}
+
+func (conf *docsConf) FromOsEnvs() string {
+	return "DOCS"
+}
+
+func Test_GenerateSchema(t *testing.T) {
+	schema := GenerateSchema("docs.json", new(docsConf))
+	assert.Equal(t, jsonSchemaDraft, schema.Schema)
+	app := schema.Properties["app"]
+	assert.Equal(t, "object", app.Type)
+
+	level := app.Properties["level"]
+	assert.Equal(t, "string", level.Type)
+	assert.Equal(t, "日志级别", level.Description)
+	assert.Equal(t, "INFO", level.Default)
+	assert.Equal(t, []interface{}{"DEBUG", "INFO", "WARN"}, level.Enum)
+
+	assert.Equal(t, "5s", app.Properties["timeout"].Default)
+	assert.Equal(t, []interface{}{"a", "b"}, app.Properties["hosts"].Default)
+	assert.Equal(t, 1, *app.Properties["hosts"].MinItems)
+	assert.Equal(t, map[string]interface{}{"k1": "v1"}, app.Properties["labels"].Default)
+	assert.True(t, app.Properties["password"].WriteOnly)
+
+	db := app.Properties["db"]
+	assert.Equal(t, []string{"url"}, db.Required)
+	assert.Equal(t, "uri", db.Properties["url"].Format)
+	assert.Equal(t, int64(3306), db.Properties["port"].Default)
+	assert.Equal(t, float64(65535), *db.Properties["port"].Maximum)
+	assert.Equal(t, "array", app.Properties["replicas"].Type)
+	assert.Equal(t, "object", app.Properties["replicas"].Items.Type)
+
+	schema = GenerateSchema("sections.json", &sectionConf{sections: []string{"services.db[0]"}}, new(sectionHosts))
+	db = schema.Properties["services"].Properties["db"]
+	assert.Equal(t, "array", db.Type)
+	assert.Equal(t, "string", db.Items.Properties["url"].Type)
+	assert.Equal(t, "array", schema.Properties["hosts"].Type)
+
+	bs, err := schema.JSON()
+	assert.Nil(t, err)
+	assert.True(t, json.Valid(bs))
+}
+
+func Test_GenerateMarkdown(t *testing.T) {
+	md := GenerateMarkdown(new(docsConf))
+	assert.True(t, strings.Contains(md, "- file: `docs.json`"), md)
+	assert.True(t, strings.Contains(md, "| `app.level` | `string` | `INFO` | `oneof=DEBUG INFO WARN` | `DOCS_LEVEL` | 日志级别 |"), md)
+	assert.True(t, strings.Contains(md, "| `app.replicas[].url` |"), md)
+	assert.True(t, strings.Contains(md, "| `app.db.url` | `string` |  | `required,url` | `DOCS_DB_URL` | 数据库地址 |"), md)
+}
+
+func Test_SampleConfig(t *testing.T) {
+	for _, ext := range sampleExts {
+		sample, err := SampleConfig(ext, new(docsConf))
+		if !assert.Nil(t, err, ext) {
+			continue
+		}
+		tree, err := lookupFormat(ext).Decode(bytes.NewReader(sample))
+		if !assert.Nil(t, err, "%s:\n%s", ext, sample) {
+			continue
+		}
+
+		conf := new(docsConf)
+		if ext == ".env" {
+			// 变量名按 FromOsEnvs 的规则对应字段, 见 envLayer
+			tree = envLayer(fileLayer{tree: tree}, []interface{}{"app"}, reflect.TypeOf(conf)).tree
+			assert.True(t, strings.Contains(string(sample), "APP_DB_PORT=3306\n"), "%s:\n%s", ext, sample)
+		}
+		app, _ := tree["app"].(map[string]interface{})
+		assert.Nil(t, bindTree(app, conf), ext)
+		assert.Equal(t, "INFO", conf.Level, ext)
+		assert.Equal(t, 5*time.Second, conf.Timeout, ext)
+		assert.Equal(t, []string{"a", "b"}, conf.Hosts, ext)
+		assert.Equal(t, map[string]string{"k1": "v1"}, conf.Labels, ext)
+		assert.Equal(t, 3306, conf.DB.Port, ext)
+		if ext != ".json" {
+			assert.True(t, strings.Contains(string(sample), "日志级别"), ext)
+		}
+	}
+
+	_, err := SampleConfig(".yaml", new(docsConf))
+	assert.NotNil(t, err)
+}
+
+func Test_GenerateDocs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, GenerateDocs(dir, new(docsConf)))
+	for _, name := range []string{"docs.schema.json", "docs.sample.json", "docs.sample.toml", "docs.sample.ini",
+		"docs.sample.properties", "docs.sample.env", "config.md"} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		assert.Nil(t, err, name)
+	}
+}
@@ -0,0 +1,410 @@
+package setting
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"qing/go-helper/error"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// .env:
+//   # 注释
+//   export APP_NAME=demo            export 前缀可以省略
+//   DB_URL = mysql://localhost      未被引号包裹的值去掉两边的空白, " #" 之后的内容被视为注释
+//   DB_PASSWORD='p@ss#word'         单引号中的内容原样保留, 不转义也不展开
+//   GREETING="hello\n${APP_NAME}"   双引号中支持转义 \n \r \t \" \\ \$, 以及变量展开
+//   CERT="-----BEGIN CERT-----      引号中的值可以跨行
+//   ...
+//   -----END CERT-----"
+//
+// 变量展开: $NAME, ${NAME}, ${NAME:-default}, 先找文件中之前定义的变量, 再找环境变量, 都没有时为空 (或者 :- 之后的默认值)
+// 其他的 ${...} (比如 ${db.host}, ${env:...}) 不是变量名, 留给 interpolate 和密钥引用处理, 见 interpolate.go
+//
+// 作为 FromFile 的格式时, 变量名按 FromOsEnvs 的规则对应 confObj 的字段, FromFile 的 sections 作为前缀, 比如
+// sections []string{"db"} 时 DB_MAX_IDLE 对应 db.maxIdle; 没有 confObj 时 (比如 ReadFile) 每个 _ 是一层
+// 也可以用 LoadEnvFile 在 Init 之前把 .env 中的变量设置到环境变量中, 再由 FromOsEnvs 读取
+func parseDotenv(r io.Reader) (map[string]interface{}, error) {
+	tree, _, err := parseDotenvPositions(r)
+	return tree, err
+}
+
+// 树中的 key 是变量名, 值都是字符串
+func parseDotenvPositions(r io.Reader) (map[string]interface{}, map[string]Position, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := (&dotenvParser{lines: strings.Split(string(content), "\n"), literal: "$${"}).parse()
+	if err != nil {
+		return nil, nil, err
+	}
+	tree := make(map[string]interface{}, len(entries))
+	positions := make(map[string]Position, len(entries))
+	for _, e := range entries {
+		tree[e.name] = e.value
+		positions[e.name] = e.pos
+	}
+	return tree, positions, nil
+}
+
+type dotenvFormat struct{}
+
+func (dotenvFormat) Decode(r io.Reader) (map[string]interface{}, error) {
+	return parseDotenv(r)
+}
+
+func (dotenvFormat) DecodePositions(r io.Reader) (map[string]interface{}, map[string]Position, error) {
+	return parseDotenvPositions(r)
+}
+
+func (dotenvFormat) envNames() {}
+
+// Decoder 可以选择实现该接口, 表示解析出来的 key 是环境变量名, 加载时按 confObj 的字段转换成 key 路径, 见 envLayer
+type envNamesDecoder interface {
+	envNames()
+}
+
+type dotenvEntry struct {
+	name, value string
+	pos         Position
+}
+
+type dotenvParser struct {
+	lines []string
+	// 被引号保护或者被转义的 ${ 写作什么: 作为配置文件时写作 $${, 以免被 interpolate 展开
+	literal string
+	// 变量展开时环境变量优先于文件中的变量, 用于 LoadEnvFile, 和不覆盖已有环境变量的规则一致
+	envFirst bool
+	entries  []dotenvEntry
+	values   map[string]string
+}
+
+func (p *dotenvParser) parse() ([]dotenvEntry, error) {
+	p.values = make(map[string]string)
+	for i := 0; i < len(p.lines); i++ {
+		raw := strings.TrimRight(p.lines[i], "\r")
+		line := strings.TrimLeft(raw, " \t")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		lineNo := i + 1
+		column := utf8.RuneCountInString(raw[:len(raw)-len(line)]) + 1
+		if strings.HasPrefix(line, "export") && len(line) > 6 && (line[6] == ' ' || line[6] == '\t') {
+			rest := strings.TrimLeft(line[6:], " \t")
+			column += utf8.RuneCountInString(line[:len(line)-len(rest)])
+			line = rest
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return nil, errPkg.Fail("parse dotenv entry fail, expect NAME=value.", errPkg.Fields{"line": lineNo, "column": column, "content": raw})
+		}
+		name := strings.TrimSpace(line[:eq])
+		if !validEnvName(name) {
+			return nil, errPkg.Fail("invalid dotenv variable name.", errPkg.Fields{"line": lineNo, "column": column, "name": name})
+		}
+
+		value, end, err := p.value(strings.TrimLeft(line[eq+1:], " \t"), i)
+		if err != nil {
+			return nil, errPkg.FailBy(err, "parse dotenv value fail.", errPkg.Fields{"line": lineNo, "column": column, "name": name})
+		}
+		i = end
+		p.values[name] = value
+		p.entries = append(p.entries, dotenvEntry{name: name, value: value, pos: Position{Line: lineNo, Column: column}})
+	}
+	return p.entries, nil
+}
+
+// 解析从第 i 行开始的值, 返回值和它结束的行
+func (p *dotenvParser) value(s string, i int) (string, int, error) {
+	if s == "" {
+		return "", i, nil
+	}
+	switch quote := s[0]; quote {
+	case '"', '\'':
+		text, end, rest, err := p.quoted(s[1:], quote, i)
+		if err != nil {
+			return "", i, err
+		}
+		if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+			return "", i, errPkg.Fail("unexpected content after closing quote.", errPkg.Fields{"content": rest})
+		}
+		if quote == '\'' {
+			return strings.Replace(text, "${", p.literal, -1), end, nil
+		}
+		value, err := p.expand(text)
+		return value, end, err
+	}
+
+	for _, mark := range []string{" #", "\t#"} {
+		if j := strings.Index(s, mark); j >= 0 {
+			s = s[:j]
+		}
+	}
+	value, err := p.expand(strings.TrimSpace(s))
+	return value, i, err
+}
+
+// 找到和 quote 匹配的引号, 可以跨行; 双引号中的转义在这里处理, 转义后的 $ 写作 \x00, 以免被 expand 展开
+// 返回引号中的内容, 结束的行, 以及结束的引号之后的内容
+func (p *dotenvParser) quoted(s string, quote byte, i int) (string, int, string, error) {
+	var bf strings.Builder
+	for {
+		for j := 0; j < len(s); j++ {
+			c := s[j]
+			if c == quote {
+				return bf.String(), i, s[j+1:], nil
+			}
+			if c != '\\' || quote == '\'' || j == len(s)-1 {
+				bf.WriteByte(c)
+				continue
+			}
+			j++
+			switch s[j] {
+			case 'n':
+				bf.WriteByte('\n')
+			case 'r':
+				bf.WriteByte('\r')
+			case 't':
+				bf.WriteByte('\t')
+			case '"', '\\':
+				bf.WriteByte(s[j])
+			case '$':
+				bf.WriteByte(0)
+			default:
+				bf.WriteByte('\\')
+				bf.WriteByte(s[j])
+			}
+		}
+		if i++; i >= len(p.lines) {
+			return "", i, "", errPkg.Fail("unclosed quote.", errPkg.Fields{"quote": string(quote)})
+		}
+		bf.WriteByte('\n')
+		s = strings.TrimRight(p.lines[i], "\r")
+	}
+}
+
+// 展开 $NAME, ${NAME} 和 ${NAME:-default}
+func (p *dotenvParser) expand(s string) (string, error) {
+	var bf strings.Builder
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == 0:
+			// 被转义的 $
+			if strings.HasPrefix(s[i+1:], "{") {
+				bf.WriteString(p.literal[:len(p.literal)-1])
+			} else {
+				bf.WriteByte('$')
+			}
+			i++
+		case strings.HasPrefix(s[i:], "${"):
+			end := matchBrace(s, i+2)
+			if end < 0 {
+				return "", errPkg.Fail("unclosed variable reference.", errPkg.Fields{"value": s})
+			}
+			expr := s[i+2 : end]
+			name, def, hasDef := expr, "", false
+			if j := strings.Index(expr, ":-"); j >= 0 {
+				name, def, hasDef = expr[:j], expr[j+2:], true
+			}
+			if !validEnvName(name) {
+				// 不是变量名, 比如 ${db.host}, 原样保留
+				bf.WriteString(s[i : end+1])
+				i = end + 1
+				continue
+			}
+			value, ok := p.lookup(name)
+			if !ok && hasDef {
+				var err error
+				if value, err = p.expand(def); err != nil {
+					return "", err
+				}
+			}
+			bf.WriteString(value)
+			i = end + 1
+		case s[i] == '$' && i+1 < len(s) && isEnvNameStart(s[i+1]):
+			j := i + 1
+			for j < len(s) && isEnvNameChar(s[j]) {
+				j++
+			}
+			value, _ := p.lookup(s[i+1 : j])
+			bf.WriteString(value)
+			i = j
+		default:
+			bf.WriteByte(s[i])
+			i++
+		}
+	}
+	return bf.String(), nil
+}
+
+// 默认文件中之前定义的变量优先于环境变量
+func (p *dotenvParser) lookup(name string) (string, bool) {
+	if p.envFirst {
+		if value, ok := os.LookupEnv(name); ok {
+			return value, true
+		}
+	}
+	if value, ok := p.values[name]; ok {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
+
+func validEnvName(name string) bool {
+	if name == "" || !isEnvNameStart(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isEnvNameChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isEnvNameStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isEnvNameChar(c byte) bool {
+	return isEnvNameStart(c) || ('0' <= c && c <= '9')
+}
+
+// 把 .env 的层转换成普通的层: t 中的字段按 FromOsEnvs 的规则 (见 envName) 对应变量名, prefix (FromFile 的 sections) 作为前缀
+// 其余以前缀开头的变量, 以及 t 为 nil 时所有的变量, 去掉前缀后每个 _ 是一层, 严格模式下它们会被报告为不认识的 key
+// 不会修改 layer
+func envLayer(layer fileLayer, prefix []interface{}, t reflect.Type) fileLayer {
+	segs := make([]string, 0, len(prefix))
+	for _, seg := range prefix {
+		if i, ok := seg.(int); ok {
+			segs = append(segs, strconv.Itoa(i))
+		} else {
+			segs = append(segs, seg.(string))
+		}
+	}
+	envPrefix := envName("", segs)
+
+	names := make(map[string]string, len(layer.tree))
+	for name := range layer.tree {
+		names[strings.ToUpper(name)] = name
+	}
+	node := make(map[string]interface{})
+	positions := make(map[string]Position)
+	used := make(map[string]bool)
+	if t != nil {
+		for _, leaf := range leafKeys(t) {
+			name, ok := names[envName(envPrefix, leaf.segs)]
+			if !ok {
+				continue
+			}
+			value, _ := layer.tree[name].(string)
+			if setPath(node, leaf.segs, defaultNode(value, leaf.typ)) == nil {
+				used[name] = true
+				positions[formatKeyPath(append(append([]interface{}{}, prefix...), toSegs(leaf.segs)...))] = layer.positions[name]
+			}
+		}
+	}
+	for _, name := range sortedKeys(layer.tree) {
+		rest := strings.ToUpper(name)
+		if used[name] || (envPrefix != "" && !strings.HasPrefix(rest, envPrefix+"_")) {
+			continue
+		}
+		if envPrefix != "" {
+			rest = rest[len(envPrefix)+1:]
+		}
+		keys := strings.Split(strings.ToLower(rest), "_")
+		if setPath(node, keys, layer.tree[name]) == nil {
+			positions[formatKeyPath(append(append([]interface{}{}, prefix...), toSegs(keys)...))] = layer.positions[name]
+		}
+	}
+
+	tree, ok := nestAt(prefix, node).(map[string]interface{})
+	if !ok {
+		tree = make(map[string]interface{})
+	}
+	for key, pos := range positions {
+		pos.File = layer.file
+		positions[key] = pos
+	}
+	return fileLayer{file: layer.file, tree: tree, positions: positions}
+}
+
+func toSegs(keys []string) []interface{} {
+	segs := make([]interface{}, len(keys))
+	for i, key := range keys {
+		segs[i] = key
+	}
+	return segs
+}
+
+// 以 segs 为路径包装 node, 数组下标之前的元素为 nil
+func nestAt(segs []interface{}, node interface{}) interface{} {
+	for i := len(segs) - 1; i >= 0; i-- {
+		switch seg := segs[i].(type) {
+		case int:
+			items := make([]interface{}, seg+1)
+			items[seg] = node
+			node = items
+		case string:
+			node = map[string]interface{}{seg: node}
+		}
+	}
+	return node
+}
+
+// 依次读取 .env 文件 (路径见 ResolvePath), 把其中的变量设置到环境变量中, 在 Init (FromOsEnvs) 之前调用
+// 调用之前已经存在的环境变量不会被覆盖, 所以部署环境中设置的变量总是优先; 多个文件中有同名变量时, 前面的文件优先,
+// 同一个文件中有同名变量时, 后面的赋值优先, 和作为 FromFile 的格式时一致
+// 文件不存在时忽略, 因为 .env 通常只在本地开发时存在
+//
+//   func main() {
+//     if err := setting.LoadEnvFile(".env"); err != nil {
+//       panic(err.Error())
+//     }
+//     ...
+//   }
+func LoadEnvFile(paths ...string) error {
+	// 不覆盖的变量: 调用之前已经存在的, 以及前面的文件设置的
+	kept := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			kept[kv[:i]] = true
+		}
+	}
+	for _, path := range paths {
+		resolved, err := ResolvePath(path)
+		if err != nil {
+			if e, ok := err.(*errPkg.Err); ok && os.IsNotExist(e.Cause) {
+				continue
+			}
+			return err
+		}
+		content, err := ioutil.ReadFile(resolved)
+		if err != nil {
+			return errPkg.FailBy(err, "read env file fail.", errPkg.Fields{"file": resolved})
+		}
+
+		// 预加载时没有 interpolate, 被保护的 ${ 就是 ${
+		entries, err := (&dotenvParser{lines: strings.Split(string(content), "\n"), literal: "${", envFirst: true}).parse()
+		if err != nil {
+			return decodeFail(err, resolved, content)
+		}
+		for _, e := range entries {
+			if kept[e.name] {
+				continue
+			}
+			if err = os.Setenv(e.name, e.value); err != nil {
+				return errPkg.FailBy(err, "set env fail.", errPkg.Fields{"file": resolved, "name": e.name})
+			}
+		}
+		for _, e := range entries {
+			kept[e.name] = true
+		}
+	}
+	return nil
+}
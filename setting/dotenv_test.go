@@ -0,0 +1,147 @@
+package setting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	testingX "qing/go-helper/testing"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseDotenv(t *testing.T) {
+	os.Setenv("DOTENV_HOME", "/home/app")
+	defer os.Unsetenv("DOTENV_HOME")
+
+	content := `# comment
+APP=demo
+export  NAME = ${APP}-api   # inline comment
+  PLAIN=a#b
+SINGLE='${APP} \n #raw'
+DOUBLE="${APP}\t\"q\"\n\$HOME \${db.host}"
+MULTI="line1
+line2"
+HOME_DIR=$DOTENV_HOME/data
+DEFAULT=${MISSING:-${APP}-default}
+EMPTY=
+REF=${db.host}
+`
+	tree, positions, err := parseDotenvPositions(strings.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"APP":      "demo",
+		"NAME":     "demo-api",
+		"PLAIN":    "a#b",
+		"SINGLE":   `$${APP} \n #raw`,
+		"DOUBLE":   "demo\t\"q\"\n$HOME $${db.host}",
+		"MULTI":    "line1\nline2",
+		"HOME_DIR": "/home/app/data",
+		"DEFAULT":  "demo-default",
+		"EMPTY":    "",
+		"REF":      "${db.host}",
+	}, tree)
+	assert.Equal(t, Position{Line: 3, Column: 9}, positions["NAME"])
+	assert.Equal(t, Position{Line: 4, Column: 3}, positions["PLAIN"])
+	assert.Equal(t, Position{Line: 9, Column: 1}, positions["HOME_DIR"])
+
+	for content, line := range map[string]int{
+		"A=1\nB\n":          2,
+		"A=1\n1B=2\n":       2,
+		"A=1\nB=\"abc\n":    2,
+		"A='x' y\n":         1,
+		"A=${B\n":           1,
+		"A=1\n\nB=\"x\" # ": 0,
+	} {
+		_, _, err := parseDotenvPositions(strings.NewReader(content))
+		if line == 0 {
+			assert.Nil(t, err, content)
+			continue
+		}
+		if assert.NotNil(t, err, content) {
+			actual, _ := errorPosition(err)
+			assert.Equal(t, line, actual, content)
+		}
+	}
+}
+
+type dotenvConf struct {
+	URL      string   `json:"url"`
+	MaxIdle  int      `json:"maxIdle"`
+	Hosts    []string `json:"hosts"`
+	Password string   `json:"password"`
+}
+
+func (conf *dotenvConf) FromFile() (string, []string) {
+	return "dotenv_test.env", []string{"db"}
+}
+
+func Test_InitFromFile_dotenv(t *testing.T) {
+	f := testingX.MockFile("dotenv_test.env", `
+DB_URL=mysql://${DB_HOST:-localhost}:3306
+DB_MAX_IDLE=5
+DB_HOSTS=a,b
+DB_PASSWORD='p${x}'
+LOG_LEVEL=DEBUG
+`)
+	defer f.Remove()
+
+	actual := new(dotenvConf)
+	assert.Nil(t, InitFromFile(actual))
+	assert.Equal(t, dotenvConf{URL: "mysql://localhost:3306", MaxIdle: 5, Hosts: []string{"a", "b"}, Password: "p${x}"}, *actual)
+
+	e, err := Explain(actual)
+	assert.Nil(t, err)
+	for _, entry := range e.Entries {
+		if entry.Key == "maxIdle" {
+			assert.Equal(t, OriginFile, entry.Source.Kind)
+			assert.Equal(t, 3, entry.Source.Line)
+		}
+	}
+
+	// 严格模式下, 前缀 DB_ 之下不认识的变量会报错, 其他前缀的变量属于别的 section
+	f2 := testingX.MockFile("dotenv_test.env", "DB_URL=x\nDB_MAX_IDEL=5\nLOG_LEVEL=DEBUG\n")
+	defer f2.Remove()
+	err = bindFile(newLoadSession(), "dotenv_test.env", []string{"db"}, new(dotenvConf), reflect.TypeOf(new(dotenvConf)), nil)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "db.max")
+		assert.NotContains(t, err.Error(), "level")
+	}
+}
+
+func Test_LoadEnvFile(t *testing.T) {
+	os.Setenv("DOTENV_KEEP", "from-env")
+	defer os.Unsetenv("DOTENV_KEEP")
+	defer os.Unsetenv("DOTENV_NEW")
+	defer os.Unsetenv("DOTENV_REF")
+	defer os.Unsetenv("DOTENV_RAW")
+
+	f := testingX.MockFile("dotenv_test_preload.env", `
+DOTENV_KEEP=from-file
+export DOTENV_NEW="a
+b"
+DOTENV_REF=${DOTENV_KEEP}
+DOTENV_RAW='${DOTENV_KEEP}'
+`)
+	defer f.Remove()
+
+	assert.Nil(t, LoadEnvFile("dotenv_test_not_exist.env", "dotenv_test_preload.env"))
+	assert.Equal(t, "from-env", os.Getenv("DOTENV_KEEP"))
+	assert.Equal(t, "a\nb", os.Getenv("DOTENV_NEW"))
+	assert.Equal(t, "from-env", os.Getenv("DOTENV_REF"))
+	assert.Equal(t, "${DOTENV_KEEP}", os.Getenv("DOTENV_RAW"))
+
+	// 同一个文件中后面的赋值优先, 前面的文件优先于后面的文件
+	defer os.Unsetenv("DOTENV_DUP")
+	defer os.Unsetenv("DOTENV_DUP_REF")
+	f3 := testingX.MockFile("dotenv_test_dup.env", "DOTENV_DUP=1\nDOTENV_DUP=2\nDOTENV_DUP_REF=${DOTENV_DUP}\n")
+	defer f3.Remove()
+	f4 := testingX.MockFile("dotenv_test_dup2.env", "DOTENV_DUP=3\n")
+	defer f4.Remove()
+	assert.Nil(t, LoadEnvFile("dotenv_test_dup.env", "dotenv_test_dup2.env"))
+	assert.Equal(t, "2", os.Getenv("DOTENV_DUP"))
+	assert.Equal(t, "2", os.Getenv("DOTENV_DUP_REF"))
+
+	f2 := testingX.MockFile("dotenv_test_bad.env", "DOTENV_BAD\n")
+	defer f2.Remove()
+	assert.NotNil(t, LoadEnvFile("dotenv_test_bad.env"))
+}
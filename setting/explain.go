@@ -0,0 +1,353 @@
+package setting
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"qing/go-helper/error"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// 配置值从哪里来
+type OriginKind string
+
+const (
+	// default tag
+	OriginDefault OriginKind = "default"
+	// Init 之前在代码里设置的值
+	OriginPreset OriginKind = "preset"
+	// 没有任何源提供, 零值
+	OriginUnset        OriginKind = "unset"
+	OriginFile         OriginKind = "file"
+	OriginEnv          OriginKind = "env"
+	OriginFlag         OriginKind = "flag"
+	OriginConfigCenter OriginKind = "config-center"
+)
+
+// Name: 文件路径, 环境变量名, 运行参数名 或者 配置中心的 namespace
+// Line, Column: 仅当 Kind 为 file 并且格式支持时 (见 PositionDecoder) 才有
+type Origin struct {
+	Kind   OriginKind `json:"kind"`
+	Name   string     `json:"name,omitempty"`
+	Line   int        `json:"line,omitempty"`
+	Column int        `json:"column,omitempty"`
+}
+
+func (o Origin) String() string {
+	switch o.Kind {
+	case OriginFile:
+		return "file " + Position{File: o.Name, Line: o.Line, Column: o.Column}.String()
+	case OriginEnv, OriginFlag, OriginConfigCenter:
+		return string(o.Kind) + " " + o.Name
+	}
+	if o.Name != "" {
+		return string(o.Kind) + " " + o.Name
+	}
+	return string(o.Kind)
+}
+
+// 被更高优先级的源覆盖的值
+type Override struct {
+	Source Origin      `json:"source"`
+	Value  interface{} `json:"value"`
+}
+
+type ExplainEntry struct {
+	// 形如 db.replicas[0].url
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+	// 最终生效的值的来源
+	Source Origin `json:"source"`
+	// 被覆盖的值, 优先级从高到低
+	Overridden []Override `json:"overridden,omitempty"`
+}
+
+type Explanation struct {
+	Type    string         `json:"type"`
+	Entries []ExplainEntry `json:"entries"`
+	// 超时被跳过的源, 见 Skipped
+	Skipped []SkippedSource `json:"skipped,omitempty"`
+}
+
+// 以表格的形式输出
+func (e *Explanation) String() string {
+	var bf bytes.Buffer
+	bf.WriteString(e.Type + "\n")
+	tw := tabwriter.NewWriter(&bf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE\tOVERRIDDEN")
+	for _, entry := range e.Entries {
+		overridden := make([]string, 0, len(entry.Overridden))
+		for _, o := range entry.Overridden {
+			overridden = append(overridden, fmt.Sprintf("%s=%v", o.Source, o.Value))
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%s\t%s\n", entry.Key, entry.Value, entry.Source, strings.Join(overridden, "; "))
+	}
+	tw.Flush()
+	for _, skipped := range e.Skipped {
+		fmt.Fprintf(&bf, "skipped source %s: %s\n", skipped.Source, skipped.Reason)
+	}
+	return bf.String()
+}
+
+func (e *Explanation) JSON() ([]byte, error) {
+	return json.MarshalIndent(e, "", "  ")
+}
+
+const maskedValue = "******"
+
+// 列出 confObj 的每一个叶子字段的最终值和来源, 以及被覆盖的值
+// 来源在 Init / InitFromFile 成功时记录, 没有经过 Init 的 confObj 只能区分 default, preset 和 unset; 不再需要时见 Forget
+// Secret 类型的字段和通过密钥引用 (见 secret.go) 得到的值会被隐藏
+func Explain(v interface{}) (*Explanation, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil, errPkg.Fail("explain target must be a non-nil pointer.", errPkg.Fields{"type": fmt.Sprintf("%T", v)})
+	}
+
+	prov := newProvenance()
+	if p, ok := provenances.Load(v); ok {
+		prov = p.(*provenance)
+	}
+	e := &Explanation{Type: rv.Type().String(), Entries: make([]ExplainEntry, 0), Skipped: prov.skipped}
+	walkLeaves(rv.Elem(), nil, nil, func(leaf reflect.Value, segs []interface{}, field *reflect.StructField) {
+		e.leaf(leaf, segs, field, prov)
+	})
+	return e, nil
+}
+
+// 遍历 rv 的叶子字段, 按字段的顺序, map 按 key 的顺序; 叶子是 isLeafType 的值 (包括元素是标量的数组) 和 nil 指针
+// field 是叶子所在的 struct 字段, 数组和 map 的元素为 nil
+func walkLeaves(rv reflect.Value, segs []interface{}, field *reflect.StructField, fn func(rv reflect.Value, segs []interface{}, field *reflect.StructField)) {
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		walkLeaves(rv.Elem(), segs, field, fn)
+		return
+	}
+	if rv.Kind() == reflect.Ptr || isLeafType(rv.Type()) {
+		fn(rv, segs, field)
+		return
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		for _, f := range structFields(rv.Type()) {
+			fv, ok := existingField(rv, f.index)
+			if !ok {
+				continue
+			}
+			sf := f.field
+			walkLeaves(fv, appendSeg(segs, f.name), &sf, fn)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			walkLeaves(rv.Index(i), appendSeg(segs, i), nil, fn)
+		}
+	case reflect.Map:
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		for _, k := range keys {
+			walkLeaves(rv.MapIndex(k), appendSeg(segs, fmt.Sprint(k)), nil, fn)
+		}
+	}
+}
+
+func (e *Explanation) leaf(rv reflect.Value, segs []interface{}, field *reflect.StructField, prov *provenance) {
+	entry := ExplainEntry{Key: formatKeyPath(segs), Value: explainValue(rv)}
+	sensitive := isSecretType(rv.Type())
+
+	if record := prov.lookup(segs); record != nil {
+		entry.Source = record.origin
+		entry.Overridden = append(entry.Overridden, record.overridden...)
+		sensitive = sensitive || record.sensitive()
+		if field != nil {
+			if literal, ok := field.Tag.Lookup(defaultTag); ok {
+				entry.Overridden = append(entry.Overridden, Override{Source: Origin{Kind: OriginDefault}, Value: literal})
+			}
+		}
+	} else {
+		entry.Source = Origin{Kind: presetOrDefault(rv, field)}
+	}
+
+	if sensitive {
+		entry.Value = maskedValue
+		for i := range entry.Overridden {
+			entry.Overridden[i].Value = maskedValue
+		}
+	}
+	e.Entries = append(e.Entries, entry)
+}
+
+func presetOrDefault(rv reflect.Value, field *reflect.StructField) OriginKind {
+	if field != nil {
+		if literal, ok := field.Tag.Lookup(defaultTag); ok {
+			dv := reflect.New(rv.Type()).Elem()
+			if bindValue(defaultNode(literal, rv.Type()), dv, "") == nil && reflect.DeepEqual(dv.Interface(), rv.Interface()) {
+				return OriginDefault
+			}
+		}
+	}
+	if rv.IsZero() {
+		return OriginUnset
+	}
+	return OriginPreset
+}
+
+// 便于阅读的值: time.Duration, time.Time 和实现了 encoding.TextMarshaler 的类型转为字符串
+func explainValue(rv reflect.Value) interface{} {
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Type() {
+	case durationType:
+		return time.Duration(rv.Int()).String()
+	case timeType:
+		return rv.Interface().(time.Time).Format(time.RFC3339Nano)
+	}
+	if m, ok := rv.Interface().(encoding.TextMarshaler); ok {
+		if text, err := m.MarshalText(); err == nil {
+			return string(text)
+		}
+	}
+	return rv.Interface()
+}
+
+// 调试用的 HTTP 接口, 输出所有 Init 成功过的 confObj 的 Explain 结果
+//   默认输出 JSON, ?format=table 输出文本表格, ?type=*log.Conf 只输出该类型
+// 配置里可能有内部地址等信息, 不要暴露在公网上
+func ExplainHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		typ := r.URL.Query().Get("type")
+		explanations := make([]*Explanation, 0)
+		provenances.Range(func(key, _ interface{}) bool {
+			if typ != "" && reflect.TypeOf(key).String() != typ {
+				return true
+			}
+			if e, err := Explain(key); err == nil {
+				explanations = append(explanations, e)
+			}
+			return true
+		})
+		sort.SliceStable(explanations, func(i, j int) bool {
+			return explanations[i].Type < explanations[j].Type
+		})
+
+		if r.URL.Query().Get("format") == "table" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, e := range explanations {
+				fmt.Fprintln(w, e.String())
+			}
+			return
+		}
+		bs, err := json.MarshalIndent(explanations, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(bs)
+	})
+}
+
+// confObj 指针 -> *provenance, Init 成功后记录, 直到 Forget
+var provenances sync.Map
+
+func recordProvenance(v interface{}, prov *provenance) {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		provenances.Store(v, prov)
+	}
+}
+
+// 不再记录 v 的来源, 之后 Explain(v) 只能区分 default, preset 和 unset, ExplainHandler 也不再输出 v
+// 来源被一直记录, v 也不会被回收: 反复 Load 或 InitNamed 得到的临时的 confObj (比如每个请求一个), 用完后应该 Forget
+// Watch, WatchAll 和 WatchNamed 在替换配置时会自动 Forget 旧的值
+func Forget(v interface{}) {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		provenances.Delete(v)
+	}
+}
+
+// Init 过程中每个 key 的来源, key 相对于 confObj, 不区分大小写
+// 数组整体作为一个值记录
+type provenance struct {
+	records map[string]*provenanceRecord
+	// 超时被跳过的源, 见 timeout.go
+	skipped []SkippedSource
+}
+
+type provenanceRecord struct {
+	origin     Origin
+	value      interface{}
+	overridden []Override
+}
+
+func newProvenance() *provenance {
+	return &provenance{records: make(map[string]*provenanceRecord)}
+}
+
+// 记录 tree 中所有的叶子, 优先级高的源后记录
+func (p *provenance) addTree(node interface{}, segs []interface{}, origin func(segs []interface{}) Origin) {
+	if obj, ok := node.(map[string]interface{}); ok {
+		for k, child := range obj {
+			p.addTree(child, appendSeg(segs, k), origin)
+		}
+		return
+	}
+	if len(segs) == 0 {
+		return
+	}
+
+	key := strings.ToLower(formatKeyPath(segs))
+	record := &provenanceRecord{origin: origin(segs), value: node}
+	if old, ok := p.records[key]; ok {
+		record.overridden = append([]Override{{Source: old.origin, Value: old.value}}, old.overridden...)
+	}
+	p.records[key] = record
+}
+
+// 记录 Source 加载的树, 有 Layers 时按层记录; 没有 Origin 的树使用 origin
+func (p *provenance) addSource(tree *SourceTree, origin func(segs []interface{}) Origin) {
+	if tree.Origin != nil {
+		origin = tree.Origin
+	}
+	if len(tree.Layers) == 0 {
+		p.addTree(tree.Node, nil, origin)
+		return
+	}
+	for _, layer := range tree.Layers {
+		p.addSource(layer, origin)
+	}
+}
+
+// 精确匹配, 否则使用最近的祖先 (比如数组整体)
+func (p *provenance) lookup(segs []interface{}) *provenanceRecord {
+	for i := len(segs); i > 0; i-- {
+		if record, ok := p.records[strings.ToLower(formatKeyPath(segs[:i]))]; ok {
+			return record
+		}
+	}
+	return nil
+}
+
+// 值或者被覆盖的值中有密钥引用
+func (r *provenanceRecord) sensitive() bool {
+	if s, ok := r.value.(string); ok && isSecretRef(s) {
+		return true
+	}
+	for _, o := range r.overridden {
+		if s, ok := o.Value.(string); ok && isSecretRef(s) {
+			return true
+		}
+	}
+	return false
+}
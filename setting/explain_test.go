@@ -0,0 +1,184 @@
+package setting
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"os"
+	testingX "qing/go-helper/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+type explainConf struct {
+	Level    string        `json:"level" default:"INFO"`
+	Dir      string        `json:"dir"`
+	Port     int           `json:"port" default:"8080"`
+	Timeout  time.Duration `json:"timeout"`
+	Debug    bool          `json:"debug"`
+	Password Secret        `json:"password"`
+	DB       struct {
+		URL string `json:"url"`
+	} `json:"db"`
+	Name string `json:"name"`
+}
+
+func (conf *explainConf) FromFile() (string, []string) {
+	return "explain.json", []string{"app"}
+}
+
+func (conf *explainConf) FromOsEnvs() string {
+	return "EXPLAIN"
+}
+
+func (conf *explainConf) FromOsArgs() string {
+	return ""
+}
+
+func Test_Explain(t *testing.T) {
+	f := testingX.MockFile("explain.json", `{
+  "app": {
+    "level": "DEBUG",
+    "timeout": "3s",
+    "password": "p@ss",
+    "db": {"url": "mysql://file"}
+  }
+}`)
+	defer f.Remove()
+	os.Setenv("EXPLAIN_DB_URL", "mysql://env")
+	os.Setenv("EXPLAIN_DIR", "/var/log")
+	os.Setenv("EXPLAIN_LEVEL", "WARN")
+	defer os.Unsetenv("EXPLAIN_DB_URL")
+	defer os.Unsetenv("EXPLAIN_DIR")
+	defer os.Unsetenv("EXPLAIN_LEVEL")
+	args := os.Args
+	os.Args = []string{args[0], "--debug", "--db.url", "mysql://flag"}
+	defer func() { os.Args = args }()
+
+	conf := new(explainConf)
+	conf.Name = "svc"
+	if err := Init(conf); err != nil {
+		t.Fatal(err)
+	}
+	defer Forget(conf)
+	assert.Equal(t, "DEBUG", conf.Level)
+	assert.Equal(t, "/var/log", conf.Dir)
+	assert.Equal(t, "mysql://flag", conf.DB.URL)
+	assert.True(t, conf.Debug)
+
+	e, err := Explain(conf)
+	assert.Nil(t, err)
+	entries := make(map[string]ExplainEntry)
+	for _, entry := range e.Entries {
+		entries[entry.Key] = entry
+	}
+
+	level := entries["level"]
+	assert.Equal(t, "DEBUG", level.Value)
+	assert.Equal(t, Origin{Kind: OriginFile, Name: ResolvedPaths()["explain.json"], Line: 3, Column: 5}, level.Source)
+	assert.Equal(t, []Override{
+		{Source: Origin{Kind: OriginEnv, Name: "EXPLAIN_LEVEL"}, Value: "WARN"},
+		{Source: Origin{Kind: OriginDefault}, Value: "INFO"},
+	}, level.Overridden)
+
+	assert.Equal(t, Origin{Kind: OriginEnv, Name: "EXPLAIN_DIR"}, entries["dir"].Source)
+	assert.Equal(t, Origin{Kind: OriginFlag, Name: "--db.url"}, entries["db.url"].Source)
+	assert.Equal(t, 2, len(entries["db.url"].Overridden))
+	assert.Equal(t, Origin{Kind: OriginFlag, Name: "--debug"}, entries["debug"].Source)
+	assert.Equal(t, "3s", entries["timeout"].Value)
+	assert.Equal(t, Origin{Kind: OriginDefault}, entries["port"].Source)
+	assert.Equal(t, Origin{Kind: OriginPreset}, entries["name"].Source)
+	assert.Equal(t, maskedValue, entries["password"].Value)
+
+	table := e.String()
+	assert.True(t, strings.Contains(table, "env EXPLAIN_DIR"), table)
+	assert.False(t, strings.Contains(table, "p@ss"), table)
+	bs, err := e.JSON()
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(string(bs), "p@ss"))
+
+	rec := httptest.NewRecorder()
+	ExplainHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/?type=*setting.explainConf", nil))
+	served := make([]Explanation, 0)
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	assert.Equal(t, 1, len(served))
+	assert.Equal(t, len(e.Entries), len(served[0].Entries))
+
+	rec = httptest.NewRecorder()
+	ExplainHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/?format=table&type=*setting.explainConf", nil))
+	assert.Equal(t, table+"\n", rec.Body.String())
+}
+
+func Test_Explain_notInit(t *testing.T) {
+	conf := new(explainConf)
+	conf.Port = 9090
+	e, err := Explain(conf)
+	assert.Nil(t, err)
+	for _, entry := range e.Entries {
+		switch entry.Key {
+		case "port":
+			assert.Equal(t, Origin{Kind: OriginPreset}, entry.Source)
+		case "level":
+			assert.Equal(t, Origin{Kind: OriginUnset}, entry.Source)
+		}
+	}
+
+	_, err = Explain(*conf)
+	assert.NotNil(t, err)
+}
+
+func Test_decodeJSONPositions(t *testing.T) {
+	_, positions, err := decodeJSONPositions(strings.NewReader("{\n  \"a\": {\"b\": [1,\n  2]},\n\t\"名\": 1\n}"))
+	assert.Nil(t, err)
+	assert.Equal(t, Position{Line: 2, Column: 3}, positions["a"])
+	assert.Equal(t, Position{Line: 2, Column: 9}, positions["a.b"])
+	assert.Equal(t, Position{Line: 3, Column: 3}, positions["a.b[1]"])
+	assert.Equal(t, Position{Line: 4, Column: 2}, positions["名"])
+
+	_, _, err = decodeJSONPositions(strings.NewReader("{\n  \"a\": }"))
+	assert.NotNil(t, err)
+}
+
+type explainSecretsConf struct {
+	Tokens []Secret          `json:"tokens"`
+	Keys   map[string]Secret `json:"keys"`
+	Backup *Secret           `json:"backup"`
+}
+
+// 元素是 Secret 的数组, map 和指针, 值和被覆盖的值都会被遮盖
+func Test_Explain_secretCollections(t *testing.T) {
+	f := testingX.MockFile("explain_secrets.json", `{"tokens": ["file-t1", "file-t2"], "keys": {"a": "file-k"}, "backup": "file-b"}`)
+	defer f.Remove()
+	os.Setenv("EXPLAIN_SECRETS_TOKENS", "env-t1,env-t2")
+	os.Setenv("EXPLAIN_SECRETS_KEYS", "a=env-k")
+	os.Setenv("EXPLAIN_SECRETS_BACKUP", "env-b")
+	defer os.Unsetenv("EXPLAIN_SECRETS_TOKENS")
+	defer os.Unsetenv("EXPLAIN_SECRETS_KEYS")
+	defer os.Unsetenv("EXPLAIN_SECRETS_BACKUP")
+
+	conf, err := Load[explainSecretsConf](WithFile("explain_secrets.json"), WithEnvPrefix("EXPLAIN_SECRETS"),
+		WithSources(OriginFile, OriginEnv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Forget(conf)
+	e, err := Explain(conf)
+	assert.Nil(t, err)
+	overridden := 0
+	for _, entry := range e.Entries {
+		assert.Equal(t, maskedValue, entry.Value, entry.Key)
+		for _, o := range entry.Overridden {
+			assert.Equal(t, maskedValue, o.Value, entry.Key)
+			overridden++
+		}
+	}
+	assert.True(t, overridden > 0)
+
+	bs, err := e.JSON()
+	assert.Nil(t, err)
+	for _, dumped := range []string{e.String(), string(bs)} {
+		assert.NotContains(t, dumped, "file-")
+		assert.NotContains(t, dumped, "env-")
+	}
+}
@@ -0,0 +1,549 @@
+package setting
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"qing/go-helper/error"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Decoder 将配置文件的内容解析成通用的树, 见 bindTree
+type Decoder interface {
+	Decode(r io.Reader) (map[string]interface{}, error)
+}
+
+// 函数形式的 Decoder
+type DecoderFunc func(r io.Reader) (map[string]interface{}, error)
+
+func (f DecoderFunc) Decode(r io.Reader) (map[string]interface{}, error) {
+	return f(r)
+}
+
+// Decoder 可以选择实现该接口, 表示可以根据内容判断文件是否为该格式
+// 当文件没有扩展名, 或者扩展名有歧义 (比如 .conf, .cfg) 时, 会用它来探测格式
+type Sniffer interface {
+	Sniff(content []byte) bool
+}
+
+type format struct {
+	ext     string
+	decoder Decoder
+}
+
+var (
+	formatsMu sync.RWMutex
+	// 注册顺序, 探测格式时后注册的优先
+	formats []format
+
+	// 这些扩展名不足以说明文件格式, 如果没有被注册, 则根据内容探测
+	ambiguousExts = map[string]bool{"": true, ".conf": true, ".cfg": true, ".config": true}
+)
+
+func init() {
+	// 探测时 json 最先, properties 最后
+	RegisterFormat(".properties", builtinFormat{parsePropertiesPositions, sniffProperties})
+	RegisterFormat(".ini", builtinFormat{parseINIPositions, sniffINI})
+	RegisterFormat(".toml", builtinFormat{parseTOMLPositions, sniffTOML})
+	RegisterFormat(".json", builtinFormat{decodeJSONPositions, sniffJSON})
+	// 内容和 properties 难以区分, 只按扩展名识别
+	RegisterFormat(".env", dotenvFormat{})
+}
+
+// 注册配置文件格式, ext 形如 ".yaml", 重复注册将覆盖之前的 Decoder (包括内置的格式)
+func RegisterFormat(ext string, d Decoder) {
+	if d == nil {
+		panic("setting: RegisterFormat decoder is nil")
+	}
+	ext = normalizeExt(ext)
+
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	for i, f := range formats {
+		if f.ext == ext {
+			formats = append(formats[:i], formats[i+1:]...)
+			break
+		}
+	}
+	formats = append(formats, format{ext: ext, decoder: d})
+}
+
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if ext != "" && ext[0] != '.' {
+		ext = "." + ext
+	}
+	return ext
+}
+
+func lookupFormat(ext string) Decoder {
+	ext = normalizeExt(ext)
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	for _, f := range formats {
+		if f.ext == ext {
+			return f.decoder
+		}
+	}
+	return nil
+}
+
+// 根据内容探测格式, 返回 Decoder 和对应的扩展名
+func sniffFormat(content []byte) (Decoder, string) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	for i := len(formats) - 1; i >= 0; i-- {
+		if sniffer, ok := formats[i].decoder.(Sniffer); ok && sniffer.Sniff(content) {
+			return formats[i].decoder, formats[i].ext
+		}
+	}
+	return nil, ""
+}
+
+func supportedExts() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	exts := make([]string, 0, len(formats))
+	for _, f := range formats {
+		exts = append(exts, f.ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+type builtinFormat struct {
+	decode func(r io.Reader) (map[string]interface{}, map[string]Position, error)
+	sniff  func(content []byte) bool
+}
+
+func (f builtinFormat) Decode(r io.Reader) (map[string]interface{}, error) {
+	tree, _, err := f.decode(r)
+	return tree, err
+}
+
+func (f builtinFormat) DecodePositions(r io.Reader) (map[string]interface{}, map[string]Position, error) {
+	return f.decode(r)
+}
+
+func (f builtinFormat) Sniff(content []byte) bool {
+	return f.sniff(content)
+}
+
+func parseJSON(r io.Reader) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var node interface{}
+	if err := decoder.Decode(&node); err != nil {
+		return nil, err
+	}
+	return wrapRoot(normalizeNumbers(node)), nil
+}
+
+// json 的根节点可以不是对象 (比如 confObj 本身是 []string), 这时树中只有 rootKey 一个 key, 见 lookupSection
+// encoding/json 解析出的 key 都是合法的 UTF-8, 不会和 rootKey 冲突
+const rootKey = "\xff"
+
+func wrapRoot(node interface{}) map[string]interface{} {
+	if tree, ok := node.(map[string]interface{}); ok {
+		return tree
+	}
+	return map[string]interface{}{rootKey: node}
+}
+
+// json.Number -> int64 / float64
+func normalizeNumbers(node interface{}) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for k, child := range n {
+			n[k] = normalizeNumbers(child)
+		}
+	case []interface{}:
+		for i, child := range n {
+			n[i] = normalizeNumbers(child)
+		}
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		if f, err := n.Float64(); err == nil {
+			return f
+		}
+		return n.String()
+	}
+	return node
+}
+
+func sniffJSON(content []byte) bool {
+	content = bytes.TrimSpace(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")))
+	return len(content) > 0 && content[0] == '{' && json.Valid(content)
+}
+
+func parseTOML(r io.Reader) (map[string]interface{}, error) {
+	tree, _, err := parseTOMLPositions(r)
+	return tree, err
+}
+
+func parseTOMLPositions(r io.Reader) (map[string]interface{}, map[string]Position, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	tree := make(map[string]interface{})
+	if _, err := toml.Decode(string(content), &tree); err != nil {
+		if pe, ok := err.(toml.ParseError); ok {
+			pos := newLineIndex(content).position(pe.Position.Start)
+			return nil, nil, errPkg.FailBy(err, "decode toml fail.", errPkg.Fields{"line": pe.Position.Line, "column": pos.Column})
+		}
+		return nil, nil, err
+	}
+	return normalizeTOML(tree).(map[string]interface{}), tomlPositions(content), nil
+}
+
+// [[array]] 被解析为 []map[string]interface{}, 转为树的节点类型 []interface{}
+func normalizeTOML(node interface{}) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for k, child := range n {
+			n[k] = normalizeTOML(child)
+		}
+	case []interface{}:
+		for i, child := range n {
+			n[i] = normalizeTOML(child)
+		}
+	case []map[string]interface{}:
+		items := make([]interface{}, len(n))
+		for i, child := range n {
+			items[i] = normalizeTOML(child)
+		}
+		return items
+	}
+	return node
+}
+
+func sniffTOML(content []byte) bool {
+	tree := make(map[string]interface{})
+	_, err := toml.Decode(string(content), &tree)
+	return err == nil && len(tree) > 0
+}
+
+// 所有有效行都是 [section] 或 key=value, 并且至少有一个 section
+func sniffINI(content []byte) bool {
+	hasSection := false
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || line[0] == ';' || line[0] == '#':
+		case line[0] == '[' && line[len(line)-1] == ']':
+			hasSection = true
+		case strings.IndexAny(line, "=:") > 0:
+		default:
+			return false
+		}
+	}
+	return hasSection
+}
+
+// 所有有效行都是 key=value 或 key:value
+func sniffProperties(content []byte) bool {
+	hasEntry := false
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || line[0] == '#' || line[0] == '!':
+		case line[0] == '[':
+			return false
+		case strings.IndexAny(line, "=:") > 0:
+			hasEntry = true
+		default:
+			if !hasEntry {
+				return false
+			}
+		}
+	}
+	return hasEntry
+}
+
+// INI:
+//   ; 注释, # 注释
+//   key = value            位于任何 section 之前的 key 属于根节点
+//   [log]                  section, 对应 FromFile 的 sections: []string{"log"}
+//   level = DEBUG
+//   [x.y.z]                嵌套的 section, 对应 []string{"x", "y", "z"}
+//   hosts[] = a            以 [] 结尾的 key 会被追加为数组
+//   hosts[] = b
+//
+// 值两边的引号会被去掉, 未被引号包裹的值, " ;" 和 " #" 之后的内容被视为注释
+func parseINI(r io.Reader) (map[string]interface{}, error) {
+	tree, _, err := parseINIPositions(r)
+	return tree, err
+}
+
+func parseINIPositions(r io.Reader) (map[string]interface{}, map[string]Position, error) {
+	tree := make(map[string]interface{})
+	positions := make(map[string]Position)
+	section := tree
+	var sectionKeys []string
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return nil, nil, errPkg.Fail("parse ini section fail.", errPkg.Fields{"line": lineNo, "content": line})
+			}
+			var err error
+			sectionKeys = splitKey(line[1:len(line)-1])
+			if section, err = makeSection(tree, sectionKeys); err != nil {
+				return nil, nil, errPkg.FailBy(err, "parse ini section fail.", errPkg.Fields{"line": lineNo, "content": line})
+			}
+			positions[strings.Join(sectionKeys, ".")] = iniPosition(scanner.Text(), lineNo)
+			continue
+		}
+
+		i := strings.IndexAny(line, "=:")
+		if i <= 0 {
+			return nil, nil, errPkg.Fail("parse ini key-value fail.", errPkg.Fields{"line": lineNo, "content": line})
+		}
+		key := strings.TrimSpace(line[:i])
+		value, err := iniValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, nil, errPkg.FailBy(err, "parse ini value fail.", errPkg.Fields{"line": lineNo, "content": line})
+		}
+
+		if strings.HasSuffix(key, "[]") {
+			key = strings.TrimSpace(strings.TrimSuffix(key, "[]"))
+			if _, ok := section[key]; !ok {
+				positions[strings.Join(append(sectionKeys, key), ".")] = iniPosition(scanner.Text(), lineNo)
+			}
+			items, _ := section[key].([]interface{})
+			section[key] = append(items, value)
+			continue
+		}
+		section[key] = value
+		positions[strings.Join(append(sectionKeys, key), ".")] = iniPosition(scanner.Text(), lineNo)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return tree, positions, nil
+}
+
+// 行首第一个非空白字符的位置
+func iniPosition(raw string, lineNo int) Position {
+	indent := len(raw) - len(strings.TrimLeft(raw, " \t\f"))
+	return Position{Line: lineNo, Column: utf8.RuneCountInString(raw[:indent]) + 1}
+}
+
+func iniValue(raw string) (string, error) {
+	if raw == "" {
+		return raw, nil
+	}
+	switch raw[0] {
+	case '"':
+		end := strings.LastIndex(raw, `"`)
+		if end == 0 {
+			return "", errPkg.Fail("unclosed double quote.", nil)
+		}
+		return strconv.Unquote(raw[:end+1])
+	case '\'':
+		end := strings.LastIndex(raw, "'")
+		if end == 0 {
+			return "", errPkg.Fail("unclosed single quote.", nil)
+		}
+		return raw[1:end], nil
+	}
+	for _, mark := range []string{" ;", " #", "\t;", "\t#"} {
+		if i := strings.Index(raw, mark); i >= 0 {
+			raw = raw[:i]
+		}
+	}
+	return strings.TrimSpace(raw), nil
+}
+
+// Java properties:
+//   # 注释, ! 注释
+//   key = value, key: value, key value
+//   以 \ 结尾的行和下一行拼接
+//   支持转义: \t \n \r \f \uXXXX
+//   key 中的 . 表示层级, db.url=... 等价于 {"db": {"url": "..."}}
+func parseProperties(r io.Reader) (map[string]interface{}, error) {
+	tree, _, err := parsePropertiesPositions(r)
+	return tree, err
+}
+
+func parsePropertiesPositions(r io.Reader) (map[string]interface{}, map[string]Position, error) {
+	tree := make(map[string]interface{})
+	positions := make(map[string]Position)
+
+	scanner := bufio.NewScanner(r)
+	logical := ""
+	startLine := 0
+	startPos := Position{}
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimLeft(scanner.Text(), " \t\f")
+		if logical == "" {
+			if line == "" || line[0] == '#' || line[0] == '!' {
+				continue
+			}
+			startLine = lineNo
+			startPos = iniPosition(scanner.Text(), lineNo)
+		}
+
+		if continued(line) {
+			logical += line[:len(line)-1]
+			continue
+		}
+		logical += line
+
+		key, value, err := propertiesEntry(logical)
+		if err != nil {
+			return nil, nil, errPkg.FailBy(err, "parse properties entry fail.", errPkg.Fields{"line": startLine, "content": logical})
+		}
+		if err = setPath(tree, splitKey(key), value); err != nil {
+			return nil, nil, errPkg.FailBy(err, "parse properties entry fail.", errPkg.Fields{"line": startLine, "content": logical})
+		}
+		positions[strings.Join(splitKey(key), ".")] = startPos
+		logical = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if logical != "" {
+		key, value, err := propertiesEntry(logical)
+		if err == nil {
+			err = setPath(tree, splitKey(key), value)
+		}
+		if err != nil {
+			return nil, nil, errPkg.FailBy(err, "parse properties entry fail.", errPkg.Fields{"line": startLine, "content": logical})
+		}
+		positions[strings.Join(splitKey(key), ".")] = startPos
+	}
+	return tree, positions, nil
+}
+
+// 行尾奇数个 \ 表示续行
+func continued(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+func propertiesEntry(logical string) (string, string, error) {
+	end := len(logical)
+	for i := 0; i < len(logical); i++ {
+		if logical[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte("=: \t\f", logical[i]) >= 0 {
+			end = i
+			break
+		}
+	}
+	key, err := unescapeProperties(logical[:end])
+	if err != nil {
+		return "", "", err
+	}
+
+	rest := strings.TrimLeft(logical[end:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	value, err := unescapeProperties(rest)
+	return key, value, err
+}
+
+func unescapeProperties(s string) (string, error) {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s, nil
+	}
+	var bf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			bf.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			bf.WriteByte('\t')
+		case 'n':
+			bf.WriteByte('\n')
+		case 'r':
+			bf.WriteByte('\r')
+		case 'f':
+			bf.WriteByte('\f')
+		case 'u':
+			if i+4 >= len(s) {
+				return "", errPkg.Fail("malformed \\uXXXX escape.", errPkg.Fields{"content": s})
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+			if err != nil {
+				return "", errPkg.FailBy(err, "malformed \\uXXXX escape.", errPkg.Fields{"content": s})
+			}
+			bf.WriteRune(rune(r))
+			i += 4
+		default:
+			bf.WriteByte(s[i])
+		}
+	}
+	return bf.String(), nil
+}
+
+func splitKey(key string) []string {
+	parts := strings.Split(key, ".")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
+
+// 返回 keys 对应的 section, 不存在则创建
+func makeSection(tree map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	cur := tree
+	for i, k := range keys {
+		if k == "" {
+			return nil, errPkg.Fail("empty section name.", errPkg.Fields{"section": strings.Join(keys, ".")})
+		}
+		switch child := cur[k].(type) {
+		case nil:
+			next := make(map[string]interface{})
+			cur[k] = next
+			cur = next
+		case map[string]interface{}:
+			cur = child
+		default:
+			return nil, errPkg.Fail("key is already a value, cannot be used as a section.",
+				errPkg.Fields{"key": strings.Join(keys[:i+1], ".")})
+		}
+	}
+	return cur, nil
+}
+
+func setPath(tree map[string]interface{}, keys []string, value interface{}) error {
+	section, err := makeSection(tree, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, ok := section[last].(map[string]interface{}); ok {
+		return errPkg.Fail("key is already a section, cannot be assigned a value.",
+			errPkg.Fields{"key": strings.Join(keys, ".")})
+	}
+	section[last] = value
+	return nil
+}
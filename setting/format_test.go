@@ -0,0 +1,204 @@
+package setting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	testingX "qing/go-helper/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+type formatConf struct {
+	Level   string        `json:"level"`
+	Size    int           `json:"size"`
+	Debug   bool          `json:"debug"`
+	Timeout time.Duration `json:"timeout"`
+	Hosts   []string      `json:"hosts"`
+	Ports   []int         `json:"ports"`
+}
+
+func Test_initFromFile_formats(t *testing.T) {
+	expected := formatConf{
+		Level:   "DEBUG",
+		Size:    10,
+		Debug:   true,
+		Timeout: 5 * time.Second,
+		Hosts:   []string{"a", "b"},
+		Ports:   []int{80, 443},
+	}
+
+	contents := map[string]string{
+		"1.toml": `
+[log]
+level = "DEBUG"
+size = 10
+debug = true
+timeout = "5s"
+hosts = ["a", "b"]
+ports = [80, 443]
+`,
+		"1.ini": `
+; comment
+[log]
+level = DEBUG ; inline comment
+size = 10
+debug = true
+timeout = 5s
+hosts = "a, b"
+ports[] = 80
+ports[] = 443
+`,
+		"1.properties": `
+# comment
+log.level = DEBUG
+log.size: 10
+log.debug true
+log.timeout = 5s
+log.hosts = a, \
+            b
+log.ports = 80,443
+`,
+	}
+
+	for path, content := range contents {
+		f := testingX.MockFile(path, content)
+		actual := new(struct {
+			Log formatConf `json:"log"`
+		})
+		err := initFromFile(path, actual)
+		f.Remove()
+		assert.Nil(t, err, path)
+		assert.Equal(t, expected, actual.Log, path)
+	}
+}
+
+type jsonListConf []string
+
+func (conf *jsonListConf) FromFile() (string, []string) {
+	return "list.json", nil
+}
+
+type jsonBytesConf struct {
+	Key []byte `json:"key"`
+}
+
+func (conf *jsonBytesConf) FromFile() (string, []string) {
+	return "bytes.json", nil
+}
+
+// 和 encoding/json 一致: 根节点可以不是对象, []byte 的值是 base64
+func Test_Init_jsonLikeEncodingJSON(t *testing.T) {
+	f := testingX.MockFile("list.json", `["a", "b"]`)
+	defer f.Remove()
+	list := new(jsonListConf)
+	assert.Nil(t, Init(list))
+	defer Forget(list)
+	assert.Equal(t, jsonListConf{"a", "b"}, *list)
+
+	tree, err := ReadFile("list.json")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"a", "b"}, tree.Node)
+
+	// 有 sections 时找不到节点, 保留原来的值
+	withSections := &sectionConf{sections: []string{"a"}, URL: "default"}
+	f2 := testingX.MockFile("sections.json", `["a"]`)
+	defer f2.Remove()
+	assert.Nil(t, InitFromFile(withSections))
+	assert.Equal(t, "default", withSections.URL)
+
+	f3 := testingX.MockFile("bytes.json", `{"key": "aGVsbG8="}`)
+	defer f3.Remove()
+	bs := new(jsonBytesConf)
+	assert.Nil(t, Init(bs))
+	defer Forget(bs)
+	assert.Equal(t, []byte("hello"), bs.Key)
+
+	assert.NotNil(t, bindTree(map[string]interface{}{"key": "not base64!"}, new(jsonBytesConf)))
+}
+
+func Test_parseINI(t *testing.T) {
+	tree, err := parseINI(strings.NewReader(`
+name = root
+[x.y]
+a = "quoted \"value\""
+b = 'single'
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name": "root",
+		"x": map[string]interface{}{
+			"y": map[string]interface{}{"a": `quoted "value"`, "b": "single"},
+		},
+	}, tree)
+
+	_, err = parseINI(strings.NewReader("[broken"))
+	assert.NotNil(t, err)
+}
+
+func Test_parseProperties(t *testing.T) {
+	tree, err := parseProperties(strings.NewReader(`
+! comment
+a\ b = x\ty
+c = 中
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"a b": "x\ty", "c": "中"}, tree)
+
+	_, err = parseProperties(strings.NewReader("db = 1\ndb.url = 2"))
+	assert.NotNil(t, err, "a value cannot be a section")
+}
+
+func Test_RegisterFormat(t *testing.T) {
+	RegisterFormat("kv", DecoderFunc(func(r io.Reader) (map[string]interface{}, error) {
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		tree := make(map[string]interface{})
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			kv := strings.SplitN(line, "|", 2)
+			tree[kv[0]] = kv[1]
+		}
+		return tree, nil
+	}))
+	defer func() {
+		formatsMu.Lock()
+		formats = formats[:len(formats)-1]
+		formatsMu.Unlock()
+	}()
+
+	path := "1.kv"
+	f := testingX.MockFile(path, "level|INFO\nsize|3")
+	defer f.Remove()
+
+	actual := new(formatConf)
+	assert.Nil(t, initFromFile(path, actual))
+	assert.Equal(t, formatConf{Level: "INFO", Size: 3}, *actual)
+}
+
+func Test_sniffFormat(t *testing.T) {
+	contents := map[string]string{
+		`{"log": {"level": "DEBUG"}}`:   ".json",
+		"[log]\nlevel = \"DEBUG\"\n":    ".toml",
+		"[log]\nlevel = DEBUG\n":        ".ini",
+		"log.level = DEBUG\nlog.size=1": ".properties",
+	}
+	for content, ext := range contents {
+		_, actual := sniffFormat([]byte(content))
+		assert.Equal(t, ext, actual, content)
+	}
+
+	_, actual := sniffFormat([]byte("just some words"))
+	assert.Equal(t, "", actual)
+
+	path := "app.conf"
+	f := testingX.MockFile(path, "[log]\nlevel = DEBUG\nsize = 10\n")
+	defer f.Remove()
+	conf := new(struct {
+		Log formatConf `json:"log"`
+	})
+	assert.Nil(t, initFromFile(path, conf))
+	assert.Equal(t, formatConf{Level: "DEBUG", Size: 10}, conf.Log)
+}
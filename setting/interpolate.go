@@ -0,0 +1,296 @@
+package setting
+
+import (
+	"fmt"
+	"os"
+	"qing/go-helper/error"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 配置文件中的字符串可以引用其他 key 和环境变量, 在解析成树之后, 绑定到 confObj 之前展开, 所以对所有格式都一样:
+//
+//   {
+//     "app": {"home": "/opt/app"},
+//     "logDir": "${app.home}/logs",              引用其他 key, 从根节点开始的路径, 数组元素写作 a.b[0]
+//     "host": "${HOSTNAME:-localhost}",          没有这个 key 时使用环境变量, 都没有时使用 :- 之后的默认值
+//     "backup": "${BACKUP_DIR:-${app.home}/bak}", 默认值中也可以引用
+//     "port": "${app.port}",                     整个值只是一个引用时, 保留被引用的值的类型 (数字, 数组, 对象 ...)
+//     "raw": "$${not.a.reference}"               $${ 转义为 ${
+//   }
+//
+// 循环引用会报错, 并给出引用的路径, 比如 a -> b -> a
+// ${env:...} 和 ${file:...} 是密钥引用, 不在这里处理, 见 secret.go; 转义得到的 ${env:...} 是普通的文本, 不是密钥引用,
+// 这样的值 (包括引用了它的值) 整体都不再解析密钥引用, 所以不要在同一个值中混用两者
+func interpolate(tree map[string]interface{}) error {
+	in := &interpolator{root: tree, done: make(map[string]bool)}
+	_, _, err := in.resolve(nil)
+	return err
+}
+
+type interpolator struct {
+	root  map[string]interface{}
+	done  map[string]bool
+	stack []string
+}
+
+// 展开 segs 指向的节点, 并写回树中
+func (in *interpolator) resolve(segs []interface{}) (interface{}, bool, error) {
+	key := formatKeyPath(segs)
+	for i, resolving := range in.stack {
+		if resolving == key {
+			chain := append(append([]string{}, in.stack[i:]...), key)
+			return nil, false, errPkg.Fail("circular reference in config.", errPkg.Fields{
+				"path": strings.Join(chain, " -> "),
+			})
+		}
+	}
+
+	node, ok := lookupKeyPath(in.root, segs)
+	if !ok {
+		return nil, false, nil
+	}
+	if in.done[key] {
+		return node, true, nil
+	}
+
+	in.stack = append(in.stack, key)
+	defer func() {
+		in.stack = in.stack[:len(in.stack)-1]
+	}()
+
+	switch n := node.(type) {
+	case string:
+		expanded, err := in.expand(n, key)
+		if err != nil {
+			return nil, false, err
+		}
+		node = expanded
+		setKeyPath(in.root, segs, node)
+	case map[string]interface{}:
+		// 按 key 排序, 循环引用的报错才是确定的
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if _, _, err := in.resolve(appendSeg(segs, k)); err != nil {
+				return nil, false, err
+			}
+		}
+	case []interface{}:
+		for i := range n {
+			if _, _, err := in.resolve(appendSeg(segs, i)); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+
+	in.done[key] = true
+	return node, true, nil
+}
+
+func appendSeg(segs []interface{}, seg interface{}) []interface{} {
+	return append(append(make([]interface{}, 0, len(segs)+1), segs...), seg)
+}
+
+func (in *interpolator) expand(s string, key string) (interface{}, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var bf strings.Builder
+	escaped := false
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], "$${") {
+			bf.WriteString("${")
+			escaped = true
+			i += 3
+			continue
+		}
+		if !strings.HasPrefix(s[i:], "${") {
+			bf.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		end := matchBrace(s, i+2)
+		if end < 0 {
+			return nil, errPkg.Fail("unclosed reference in config.", errPkg.Fields{"key": key, "value": s})
+		}
+		expr := s[i+2 : end]
+		if strings.HasPrefix(expr, "env:") || strings.HasPrefix(expr, "file:") {
+			bf.WriteString(s[i : end+1])
+			i = end + 1
+			continue
+		}
+
+		value, err := in.reference(expr, key)
+		if err != nil {
+			return nil, err
+		}
+		// 整个值只是一个引用, 保留类型
+		if i == 0 && end == len(s)-1 {
+			return value, nil
+		}
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, errPkg.Fail("cannot embed an object or array in a string.", errPkg.Fields{
+				"key":       key,
+				"reference": expr,
+			})
+		}
+		if _, ok := value.(escapedString); ok {
+			escaped = true
+		}
+		bf.WriteString(fmt.Sprint(value))
+		i = end + 1
+	}
+	if result := bf.String(); escaped && isSecretRef(result) {
+		return escapedString(result), nil
+	}
+	return bf.String(), nil
+}
+
+// 转义之后看起来像密钥引用的字符串, 绑定时作为普通的字符串, 见 bindValue
+type escapedString string
+
+// 引用的值: key > 环境变量 > 默认值
+func (in *interpolator) reference(expr string, key string) (interface{}, error) {
+	name, def, hasDef := expr, "", false
+	if i := strings.Index(expr, ":-"); i >= 0 {
+		name, def, hasDef = expr[:i], expr[i+2:], true
+	}
+	name = strings.TrimSpace(name)
+
+	if segs, err := parseKeyPath(name); err == nil {
+		value, ok, err := in.resolve(segs)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return value, nil
+		}
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value, nil
+	}
+	if hasDef {
+		return in.expand(def, key)
+	}
+
+	chain := append(append([]string{}, in.stack...), name)
+	return nil, errPkg.Fail("reference in config cannot be resolved, neither a key nor an env.", errPkg.Fields{
+		"key":       key,
+		"reference": name,
+		"path":      strings.Join(chain, " -> "),
+	})
+}
+
+// s[start:] 中和 ${ 匹配的 } 的位置
+func matchBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "${"):
+			depth++
+			i++
+		case s[i] == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// "services.db[0].url" -> ["services", "db", 0, "url"]
+func parseKeyPath(path string) ([]interface{}, error) {
+	segs := make([]interface{}, 0)
+	if path == "" {
+		return segs, nil
+	}
+	for _, part := range strings.Split(path, ".") {
+		name := part
+		indexes := ""
+		if i := strings.IndexByte(part, '['); i >= 0 {
+			name, indexes = part[:i], part[i:]
+		}
+		if name == "" && (indexes == "" || len(segs) == 0) {
+			return nil, errPkg.Fail("invalid key path.", errPkg.Fields{"path": path})
+		}
+		if name != "" {
+			segs = append(segs, name)
+		}
+		for indexes != "" {
+			end := strings.IndexByte(indexes, ']')
+			if indexes[0] != '[' || end < 0 {
+				return nil, errPkg.Fail("invalid key path.", errPkg.Fields{"path": path})
+			}
+			i, err := strconv.Atoi(indexes[1:end])
+			if err != nil || i < 0 {
+				return nil, errPkg.Fail("invalid index in key path.", errPkg.Fields{"path": path})
+			}
+			segs = append(segs, i)
+			indexes = indexes[end+1:]
+		}
+	}
+	return segs, nil
+}
+
+func formatKeyPath(segs []interface{}) string {
+	var bf strings.Builder
+	for _, seg := range segs {
+		switch s := seg.(type) {
+		case int:
+			bf.WriteString("[" + strconv.Itoa(s) + "]")
+		default:
+			if bf.Len() > 0 {
+				bf.WriteByte('.')
+			}
+			bf.WriteString(fmt.Sprint(s))
+		}
+	}
+	return bf.String()
+}
+
+func lookupKeyPath(node interface{}, segs []interface{}) (interface{}, bool) {
+	for _, seg := range segs {
+		switch s := seg.(type) {
+		case string:
+			obj, ok := node.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if node, ok = obj[s]; !ok {
+				return nil, false
+			}
+		case int:
+			items, ok := node.([]interface{})
+			if !ok || s >= len(items) {
+				return nil, false
+			}
+			node = items[s]
+		}
+	}
+	return node, true
+}
+
+func setKeyPath(root map[string]interface{}, segs []interface{}, value interface{}) {
+	if len(segs) == 0 {
+		return
+	}
+	parent, ok := lookupKeyPath(root, segs[:len(segs)-1])
+	if !ok {
+		return
+	}
+	switch s := segs[len(segs)-1].(type) {
+	case string:
+		parent.(map[string]interface{})[s] = value
+	case int:
+		parent.([]interface{})[s] = value
+	}
+}
@@ -0,0 +1,86 @@
+package setting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"qing/go-helper/error"
+	"strings"
+	"testing"
+)
+
+func Test_interpolate(t *testing.T) {
+	os.Setenv("SETTING_TEST_HOST", "example.com")
+	defer os.Unsetenv("SETTING_TEST_HOST")
+
+	tree, err := parseJSON(strings.NewReader(`{
+		"app": {"home": "/opt/app", "port": 8080, "tags": ["a", "b"]},
+		"logDir": "${app.home}/logs",
+		"host": "${SETTING_TEST_HOST:-localhost}",
+		"fallback": "${SETTING_TEST_NOT_SET:-${app.home}/bak}",
+		"port": "${app.port}",
+		"first": "${tags.first}",
+		"tags": {"first": "${app.tags[0]}"},
+		"raw": "$${not.a.reference}",
+		"secret": "${env:DB_PASS}"
+	}`))
+	assert.Nil(t, err)
+	assert.Nil(t, interpolate(tree))
+	assert.Equal(t, "/opt/app/logs", tree["logDir"])
+	assert.Equal(t, "example.com", tree["host"])
+	assert.Equal(t, "/opt/app/bak", tree["fallback"])
+	assert.Equal(t, int64(8080), tree["port"])
+	assert.Equal(t, "a", tree["first"])
+	assert.Equal(t, "${not.a.reference}", tree["raw"])
+	assert.Equal(t, "${env:DB_PASS}", tree["secret"])
+
+	tree, _ = parseJSON(strings.NewReader(`{"a": "${b}", "b": {"c": "${a}"}}`))
+	err = interpolate(tree)
+	known, ok := err.(*errPkg.Err)
+	if !ok {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "a -> b -> b.c -> a", known.Fields["path"])
+
+	tree, _ = parseJSON(strings.NewReader(`{"a": "${missing.key}"}`))
+	assert.NotNil(t, interpolate(tree))
+}
+
+type escapedRefConf struct {
+	Pattern string  `json:"pattern"`
+	Copy    string  `json:"copy"`
+	Token   *Secret `json:"token"`
+}
+
+// 转义得到的 ${env:...} 是普通的文本, 不会被当作密钥引用
+func Test_interpolate_escapedSecretRef(t *testing.T) {
+	os.Setenv("SETTING_TEST_ESCAPED", "plaintext")
+	defer os.Unsetenv("SETTING_TEST_ESCAPED")
+
+	tree, err := parseJSON(strings.NewReader(`{
+		"pattern": "$${env:SETTING_TEST_ESCAPED}",
+		"copy": "value: ${pattern}",
+		"token": "$${file:/run/secrets/token}"
+	}`))
+	assert.Nil(t, err)
+	assert.Nil(t, interpolate(tree))
+	conf := new(escapedRefConf)
+	assert.Nil(t, bindTree(tree, conf))
+	assert.Equal(t, "${env:SETTING_TEST_ESCAPED}", conf.Pattern)
+	assert.Equal(t, "value: ${env:SETTING_TEST_ESCAPED}", conf.Copy)
+	assert.Equal(t, Secret("${file:/run/secrets/token}"), *conf.Token)
+
+	// schema 检查时也是字符串
+	assert.Empty(t, GenerateSchema("", conf).Check(tree, false))
+}
+
+func Test_parseKeyPath(t *testing.T) {
+	segs, err := parseKeyPath("services.db[0][1].url")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"services", "db", 0, 1, "url"}, segs)
+	assert.Equal(t, "services.db[0][1].url", formatKeyPath(segs))
+
+	for _, invalid := range []string{"a..b", "a[x]", "a[1", "[0]"} {
+		_, err = parseKeyPath(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
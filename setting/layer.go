@@ -0,0 +1,282 @@
+package setting
+
+import (
+	"os"
+	"path/filepath"
+	"qing/go-helper/error"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 配置文件的分层
+//
+// 1. include: 配置文件可以包含其他文件, 路径相对于当前文件, 支持 glob
+//   {
+//     "include": ["base.json", "conf.d/*.json"],
+//     "log": {...}
+//   }
+//   按顺序合并被包含的文件, 后面的覆盖前面的, 当前文件覆盖所有被包含的文件; 被包含的文件也可以 include
+//   glob 没有匹配到文件不算错误, 非 glob 的文件不存在则报错
+//
+// 2. profile: 依次合并 (存在的话)
+//   conf.json              FromFile 返回的文件, 必须存在
+//   conf.<profile>.json    profile 来自 SetProfile, 或者运行参数 --profile=prod, 或者环境变量 SETTING_PROFILE
+//   conf.local.json        本地的覆盖, 不应该提交到代码库中
+//
+// 合并时 map 深度合并, 数组按 SetArrayPolicy 的策略, 默认替换
+const (
+	includeKey = "include"
+	ProfileEnv = "SETTING_PROFILE"
+	localLayer = "local"
+)
+
+type ArrayPolicy int
+
+const (
+	// 上层的数组替换下层的数组
+	ArrayReplace ArrayPolicy = iota
+	// 上层的数组追加到下层的数组之后
+	ArrayAppend
+)
+
+var (
+	layerMu     sync.RWMutex
+	profile     string
+	arrayPolicy = ArrayReplace
+)
+
+// 设置 profile, 优先于运行参数和环境变量
+func SetProfile(p string) {
+	layerMu.Lock()
+	defer layerMu.Unlock()
+	profile = p
+}
+
+// 当前的 profile: SetProfile > --profile > SETTING_PROFILE
+func Profile() string {
+	layerMu.RLock()
+	p := profile
+	layerMu.RUnlock()
+	if p != "" {
+		return p
+	}
+	if p = profileFromArgs(os.Args[1:]); p != "" {
+		return p
+	}
+	return os.Getenv(ProfileEnv)
+}
+
+func profileFromArgs(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		for _, name := range []string{"--profile", "-profile"} {
+			if strings.HasPrefix(arg, name+"=") {
+				return strings.TrimPrefix(arg, name+"=")
+			}
+			if arg == name && i+1 < len(args) {
+				return args[i+1]
+			}
+		}
+	}
+	return ""
+}
+
+func SetArrayPolicy(policy ArrayPolicy) {
+	layerMu.Lock()
+	defer layerMu.Unlock()
+	arrayPolicy = policy
+}
+
+func currentArrayPolicy() ArrayPolicy {
+	layerMu.RLock()
+	defer layerMu.RUnlock()
+	return arrayPolicy
+}
+
+// conf.json 的 profile 层和 local 层的文件路径
+func layerFiles(path string) []string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	files := make([]string, 0, 2)
+	if p := Profile(); p != "" && p != localLayer {
+		files = append(files, base+"."+p+ext)
+	}
+	return append(files, base+"."+localLayer+ext)
+}
+
+// 查找 path (见 ResolvePath), 加载它以及它的 include 和 profile 层, 合并成一棵树
+// 同时返回所有读取了的文件, 第一个是 path 实际使用的文件
+func loadFileTree(path string) (map[string]interface{}, []string, error) {
+	layers, files, err := loadFileLayers(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mergeLayers(layers, currentArrayPolicy()), files, nil
+}
+
+// 一个配置文件解析出来的树, include 的内容不包含在内
+type fileLayer struct {
+	file      string
+	tree      map[string]interface{}
+	positions map[string]Position
+	// key 是环境变量名 (.env), 加载时才能按 confObj 转换, 见 envLayer
+	envNames bool
+}
+
+// 按合并的顺序 (优先级从低到高) 返回 path 的所有层: 被 include 的文件在 include 它的文件之前, profile 层和 local 层在最后
+func loadFileLayers(path string) ([]fileLayer, []string, error) {
+	if err := checkFormat(path); err != nil {
+		return nil, nil, err
+	}
+	path, err := ResolvePath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	loader := &layerLoader{}
+	if err = loader.load(path); err != nil {
+		return nil, nil, err
+	}
+
+	for _, layer := range layerFiles(path) {
+		if _, err := os.Stat(layer); os.IsNotExist(err) {
+			continue
+		}
+		if err = loader.load(layer); err != nil {
+			return nil, nil, err
+		}
+	}
+	return loader.layers, loader.files, nil
+}
+
+// 依次合并, 不会修改 layers 中的树
+func mergeLayers(layers []fileLayer, policy ArrayPolicy) map[string]interface{} {
+	tree := make(map[string]interface{})
+	for _, layer := range layers {
+		mergeTree(tree, cloneTree(layer.tree).(map[string]interface{}), policy)
+	}
+	return tree
+}
+
+func cloneTree(node interface{}) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(n))
+		for k, child := range n {
+			result[k] = cloneTree(child)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(n))
+		for i, child := range n {
+			result[i] = cloneTree(child)
+		}
+		return result
+	}
+	return node
+}
+
+type layerLoader struct {
+	layers  []fileLayer
+	files   []string
+	loading []string
+}
+
+func (loader *layerLoader) load(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	for i, loading := range loader.loading {
+		if loading == abs {
+			return errPkg.Fail("circular include in config files.", errPkg.Fields{
+				"path": strings.Join(append(append([]string{}, loader.loading[i:]...), abs), " -> "),
+			})
+		}
+	}
+	loader.loading = append(loader.loading, abs)
+	defer func() {
+		loader.loading = loader.loading[:len(loader.loading)-1]
+	}()
+
+	tree, positions, envNames, err := readFileTree(path)
+	if err != nil {
+		return err
+	}
+	loader.files = append(loader.files, path)
+
+	includes, err := includePaths(path, tree[includeKey])
+	if err != nil {
+		return err
+	}
+	delete(tree, includeKey)
+
+	for _, include := range includes {
+		if err := loader.load(include); err != nil {
+			return errPkg.FailBy(err, "load included config file fail.", errPkg.Fields{"file": path, "include": include})
+		}
+	}
+	loader.layers = append(loader.layers, fileLayer{file: path, tree: tree, positions: positions, envNames: envNames})
+	return nil
+}
+
+func includePaths(path string, node interface{}) ([]string, error) {
+	patterns := make([]string, 0)
+	switch n := node.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		patterns = append(patterns, n)
+	case []interface{}:
+		for _, item := range n {
+			s, ok := item.(string)
+			if !ok {
+				return nil, errPkg.Fail("include must be a string or an array of strings.", errPkg.Fields{"file": path})
+			}
+			patterns = append(patterns, s)
+		}
+	default:
+		return nil, errPkg.Fail("include must be a string or an array of strings.", errPkg.Fields{"file": path})
+	}
+
+	dir := filepath.Dir(path)
+	result := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		if !strings.ContainsAny(pattern, "*?[") {
+			result = append(result, pattern)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errPkg.FailBy(err, "invalid include pattern.", errPkg.Fields{"file": path, "pattern": pattern})
+		}
+		sort.Strings(matches)
+		result = append(result, matches...)
+	}
+	return result, nil
+}
+
+// 将 src 合并到 dst 中, src 优先
+func mergeTree(dst, src map[string]interface{}, policy ArrayPolicy) {
+	for k, srcChild := range src {
+		switch s := srcChild.(type) {
+		case map[string]interface{}:
+			if d, ok := dst[k].(map[string]interface{}); ok {
+				mergeTree(d, s, policy)
+				continue
+			}
+		case []interface{}:
+			if d, ok := dst[k].([]interface{}); ok && policy == ArrayAppend {
+				dst[k] = append(append([]interface{}{}, d...), s...)
+				continue
+			}
+		}
+		dst[k] = srcChild
+	}
+}
@@ -0,0 +1,61 @@
+package setting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_loadFileTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("base.toml", "[db]\nurl = \"base\"\nport = 3306\nhosts = [\"a\"]\n")
+	write("conf.d/1.json", `{"log": {"level": "DEBUG", "dir": "/var/log"}}`)
+	write("conf.d/2.json", `{"log": {"level": "INFO"}}`)
+	write("conf.json", `{"include": ["base.toml", "conf.d/*.json"], "db": {"url": "conf", "hosts": ["b"]}}`)
+	write("conf.prod.json", `{"db": {"url": "prod"}}`)
+	write("conf.local.json", `{"log": {"dir": "/tmp"}}`)
+
+	SetProfile("prod")
+	defer SetProfile("")
+
+	tree, files, err := loadFileTree(filepath.Join(dir, "conf.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"db":  map[string]interface{}{"url": "prod", "port": int64(3306), "hosts": []interface{}{"b"}},
+		"log": map[string]interface{}{"level": "INFO", "dir": "/tmp"},
+	}, tree)
+	assert.Equal(t, 6, len(files))
+
+	SetArrayPolicy(ArrayAppend)
+	defer SetArrayPolicy(ArrayReplace)
+	tree, _, err = loadFileTree(filepath.Join(dir, "conf.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"a", "b"}, tree["db"].(map[string]interface{})["hosts"])
+
+	write("loop-a.json", `{"include": "loop-b.json"}`)
+	write("loop-b.json", `{"include": "loop-a.json"}`)
+	_, _, err = loadFileTree(filepath.Join(dir, "loop-a.json"))
+	assert.NotNil(t, err, "circular include")
+
+	write("missing.json", `{"include": "not-exist.json"}`)
+	_, _, err = loadFileTree(filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+}
+
+func Test_profileFromArgs(t *testing.T) {
+	assert.Equal(t, "prod", profileFromArgs([]string{"-v", "--profile=prod"}))
+	assert.Equal(t, "dev", profileFromArgs([]string{"-profile", "dev"}))
+	assert.Equal(t, "", profileFromArgs([]string{"--", "--profile=prod"}))
+}
@@ -0,0 +1,144 @@
+package setting
+
+import (
+	"context"
+	"fmt"
+	"qing/go-helper/error"
+)
+
+// 泛型的加载方式, 不需要在 confObj 上实现 FromFile 等接口, 由 Option 指定源
+//
+//   type Conf struct {
+//     URL string `json:"url" validate:"required"`
+//   }
+//
+//   conf := setting.MustLoad[Conf](setting.WithFile("conf.json", "db"), setting.WithEnvPrefix("DB"))
+//
+// T 的指针实现了 FromFile 等接口时, 接口指定的源同样生效, Option 覆盖同一个源的接口
+// 各个源的优先级, 合并, 校验和 Init 相同; Explain 同样适用于返回的指针
+type Option func(*loadPlan)
+
+// 从配置文件加载, sections 见 FromFile
+func WithFile(path string, sections ...string) Option {
+	return func(plan *loadPlan) {
+		plan.fromFile, plan.path, plan.sections = true, path, sections
+	}
+}
+
+// 配置文件中的节点, 需要和 WithFile 或者 FromFile 一起使用
+func WithSections(sections ...string) Option {
+	return func(plan *loadPlan) {
+		plan.sections = sections
+	}
+}
+
+// 从环境变量加载, prefix 见 FromOsEnvs
+func WithEnvPrefix(prefix string) Option {
+	return func(plan *loadPlan) {
+		plan.fromEnvs, plan.envPrefix = true, prefix
+	}
+}
+
+// 从运行参数加载, prefix 见 FromOsArgs
+func WithArgsPrefix(prefix string) Option {
+	return func(plan *loadPlan) {
+		plan.fromArgs, plan.argsPrefix = true, prefix
+	}
+}
+
+// 从配置中心加载, 见 FromApollo 和 SetConfigCenter
+func WithNamespace(namespace string) Option {
+	return func(plan *loadPlan) {
+		plan.fromCenter, plan.namespace = true, namespace
+	}
+}
+
+// 只使用 kinds 中的源: OriginFile, OriginEnv, OriginFlag, OriginConfigCenter, 自定义的源是 OriginKind(Source.Name())
+// 比如测试中 WithSources(setting.OriginFile), 不受环境变量和运行参数的影响
+func WithSources(kinds ...OriginKind) Option {
+	return func(plan *loadPlan) {
+		plan.only = make(map[OriginKind]bool, len(kinds))
+		for _, kind := range kinds {
+			plan.only[kind] = true
+		}
+	}
+}
+
+// 严格模式, 见 strict.go
+func WithStrict(on bool) Option {
+	return func(plan *loadPlan) {
+		plan.strict = on
+	}
+}
+
+func planFor(v interface{}, opts []Option) loadPlan {
+	plan := planOf(v)
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&plan)
+		}
+	}
+	return plan
+}
+
+// 加载一个新的 T, 各个源见 Option
+// 返回值的来源会被记录 (见 Explain), 临时使用的返回值用完后应该 Forget
+func Load[T any](opts ...Option) (*T, error) {
+	v := new(T)
+	prov, err := initWith(context.Background(), v, planFor(v, opts), newLoadSession())
+	if err != nil {
+		return nil, errPkg.FailBy(err, "load config fail.", errPkg.Fields{"type": fmt.Sprintf("%T", v)})
+	}
+	recordProvenance(v, prov)
+	return v, nil
+}
+
+// 同 Load, 失败时 panic, 适合在 init() 或者 main() 的开始使用
+func MustLoad[T any](opts ...Option) *T {
+	v, err := Load[T](opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return v
+}
+
+// 热加载的 T, 见 Watch
+type Value[T any] struct {
+	w *Watcher
+}
+
+// 加载一个新的 T, 并开始监听它的变化, watchOpts 见 WatchOptions, 可以为 nil
+func LoadValue[T any](watchOpts *WatchOptions, opts ...Option) (*Value[T], error) {
+	v := new(T)
+	w, err := watch(v, planFor(v, opts), watchOpts)
+	if err != nil {
+		return nil, errPkg.FailBy(err, "load config fail.", errPkg.Fields{"type": fmt.Sprintf("%T", v)})
+	}
+	return &Value[T]{w: w}, nil
+}
+
+// 最新的配置, 返回的值不要修改
+func (v *Value[T]) Get() *T {
+	return v.w.Get().(*T)
+}
+
+// 订阅配置的变化, 只有值真正发生变化时才会通知
+func (v *Value[T]) Subscribe(fn func(old, new *T)) {
+	// fn 的类型一定正确
+	_ = v.w.Subscribe(fn)
+}
+
+// 同 Subscribe, 同时得到具体变化的 key, 见 Diff
+func (v *Value[T]) SubscribeDiff(fn func(old, new *T, diff Diff)) {
+	_ = v.w.Subscribe(fn)
+}
+
+// 立即重新加载, 见 Watcher.Reload
+func (v *Value[T]) Reload() error {
+	return v.w.Reload()
+}
+
+// 停止监听
+func (v *Value[T]) Close() error {
+	return v.w.Close()
+}
@@ -0,0 +1,102 @@
+package setting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type loadConf struct {
+	URL  string `json:"url" validate:"required"`
+	Port int    `json:"port" default:"3306"`
+}
+
+func Test_Load(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.json")
+	if err = ioutil.WriteFile(path, []byte(`{"db": {"url": "mysql://a"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("LOAD_PORT", "3307")
+	defer os.Unsetenv("LOAD_PORT")
+
+	conf, err := Load[loadConf](WithFile(path), WithSections("db"), WithEnvPrefix("LOAD"))
+	assert.Nil(t, err)
+	assert.Equal(t, &loadConf{URL: "mysql://a", Port: 3307}, conf)
+	defer Forget(conf)
+	e, err := Explain(conf)
+	assert.Nil(t, err)
+	assert.Equal(t, OriginEnv, e.Entries[1].Source.Kind)
+
+	// Forget 之后不再记录来源
+	temp, err := Load[loadConf](WithFile(path, "db"))
+	assert.Nil(t, err)
+	_, recorded := provenances.Load(temp)
+	assert.True(t, recorded)
+	Forget(temp)
+	_, recorded = provenances.Load(temp)
+	assert.False(t, recorded)
+	e, err = Explain(temp)
+	assert.Nil(t, err)
+	assert.Equal(t, OriginPreset, e.Entries[0].Source.Kind)
+
+	conf, err = Load[loadConf](WithSources(OriginFile), WithFile(path, "db"), WithEnvPrefix("LOAD"))
+	assert.Nil(t, err)
+	assert.Equal(t, &loadConf{URL: "mysql://a", Port: 3306}, conf)
+	defer Forget(conf)
+
+	_, err = Load[loadConf]()
+	assert.NotNil(t, err, "url is required")
+	assert.Panics(t, func() { MustLoad[loadConf]() })
+
+	hosts := MustLoad[[]string](WithFile(path, "hosts"))
+	defer Forget(hosts)
+	assert.Nil(t, *hosts)
+}
+
+func Test_LoadValue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.json")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"url": "mysql://a"}`)
+
+	value, err := LoadValue[loadConf](&WatchOptions{Debounce: 10 * time.Millisecond}, WithFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer value.Close()
+	assert.Equal(t, "mysql://a", value.Get().URL)
+
+	changes := make(chan *loadConf, 10)
+	value.Subscribe(func(old, new *loadConf) {
+		changes <- new
+	})
+	write(`{"url": "mysql://b"}`)
+	select {
+	case change := <-changes:
+		assert.Equal(t, "mysql://b", change.URL)
+		assert.Equal(t, change, value.Get())
+	case <-time.After(3 * time.Second):
+		t.Fatal("no change notified")
+	}
+
+	write(`{"port": 1}`)
+	assert.NotNil(t, value.Reload())
+	assert.Equal(t, "mysql://b", value.Get().URL)
+}
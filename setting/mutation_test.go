@@ -0,0 +1,236 @@
+package setting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type mutationConf struct {
+	Name    string            `json:"name"`
+	Port    int               `json:"port" validate:"min=1,max=65535"`
+	Debug   bool              `json:"debug"`
+	Timeout time.Duration     `json:"timeout" default:"3s"`
+	Hosts   []string          `json:"hosts"`
+	Labels  map[string]string `json:"labels"`
+	DB      *mutationDB       `json:"db"`
+
+	path string
+}
+
+type mutationDB struct {
+	URL     string `json:"url"`
+	MaxIdle int    `json:"maxIdle"`
+}
+
+func (conf *mutationConf) FromFile() (string, []string) {
+	return conf.path, nil
+}
+
+func (conf *mutationConf) FromOsEnvs() string {
+	return "MUTATION"
+}
+
+// 加载前 confObj 中已经有的值, 失败后应该原样保留, 包括没有被赋 default 值的 Timeout
+func presetMutationConf(path string) *mutationConf {
+	return &mutationConf{
+		Name:   "preset",
+		Port:   1,
+		Hosts:  []string{"preset-host"},
+		Labels: map[string]string{"preset": "1"},
+		DB:     &mutationDB{URL: "preset-url", MaxIdle: 1},
+		path:   path,
+	}
+}
+
+var mutationContents = map[string]string{
+	".json": `{
+  "name": "app",
+  "port": 8080,
+  "debug": true,
+  "timeout": "5s",
+  "hosts": ["a", "b"],
+  "labels": {"zone": "z1", "tier": "web"},
+  "db": {"url": "mysql://localhost", "maxIdle": 10}
+}`,
+	".toml": `name = "app"
+port = 8080
+debug = true
+timeout = "5s"
+hosts = ["a", "b"]
+
+[labels]
+zone = "z1"
+tier = "web"
+
+[db]
+url = "mysql://localhost"
+maxIdle = 10
+`,
+	".ini": `name = app
+port = 8080
+debug = true
+timeout = 5s
+hosts[] = a
+hosts[] = b
+
+[labels]
+zone = z1
+tier = web
+
+[db]
+url = mysql://localhost
+maxIdle = 10
+`,
+	".properties": `name=app
+port=8080
+debug=true
+timeout=5s
+hosts=a, b
+labels.zone=z1
+labels.tier=web
+db.url=mysql://localhost
+db.maxIdle=10
+`,
+	".env": `NAME=app
+PORT=8080
+DEBUG=true
+TIMEOUT=5s
+HOSTS=a,b
+LABELS="zone=z1,tier=web"
+DB_URL=mysql://localhost
+DB_MAX_IDLE=10
+`,
+}
+
+// 在随机的位置截断, 插入, 删除或者替换一个字节
+func corrupt(rnd *rand.Rand, content string) string {
+	const noise = "{}[]\"'=:,#\\\n x9-.$"
+	offset := rnd.Intn(len(content) + 1)
+	b := noise[rnd.Intn(len(noise))]
+	switch rnd.Intn(4) {
+	case 0:
+		return content[:offset]
+	case 1:
+		return content[:offset] + string(b) + content[offset:]
+	case 2:
+		if offset == len(content) {
+			return content
+		}
+		return content[:offset] + content[offset+1:]
+	default:
+		if offset == len(content) {
+			return content + string(b)
+		}
+		return content[:offset] + string(b) + content[offset+1:]
+	}
+}
+
+func Test_Init_noPartialMutation_files(t *testing.T) {
+	seed := time.Now().UnixNano()
+	rnd := rand.New(rand.NewSource(seed))
+	dir, err := ioutil.TempDir("", "setting-mutation")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for ext, content := range mutationContents {
+		path := filepath.Join(dir, "conf"+ext)
+
+		// 没有损坏时可以完整加载
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+		v := presetMutationConf(path)
+		if assert.Nil(t, Init(v), ext) {
+			assert.Equal(t, &mutationConf{
+				Name: "app", Port: 8080, Debug: true, Timeout: 5 * time.Second, Hosts: []string{"a", "b"},
+				Labels: map[string]string{"preset": "1", "zone": "z1", "tier": "web"},
+				DB:     &mutationDB{URL: "mysql://localhost", MaxIdle: 10}, path: path,
+			}, v, ext)
+		}
+
+		failed := 0
+		for i := 0; i < 300; i++ {
+			corrupted := corrupt(rnd, content)
+			assert.Nil(t, ioutil.WriteFile(path, []byte(corrupted), 0644))
+
+			v := presetMutationConf(path)
+			expected := cloneValue(reflect.ValueOf(v)).Interface()
+			if err := Init(v); err != nil {
+				failed++
+				if !assert.Equal(t, expected, v, "seed %d, %s:\n%s", seed, ext, corrupted) {
+					return
+				}
+			}
+		}
+		assert.True(t, failed > 0, "seed %d, %s: no corrupted content fails", seed, ext)
+	}
+}
+
+// 前面的源成功, 后面的源 (或者校验) 失败时, 前面的源也不能生效
+func Test_Init_noPartialMutation_sources(t *testing.T) {
+	seed := time.Now().UnixNano()
+	rnd := rand.New(rand.NewSource(seed))
+	dir, err := ioutil.TempDir("", "setting-mutation")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "conf.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"name": "app", "db": {"url": "mysql://localhost"}}`), 0644))
+
+	envs := map[string]string{
+		"MUTATION_PORT":        "8080",
+		"MUTATION_DEBUG":       "true",
+		"MUTATION_TIMEOUT":     "5s",
+		"MUTATION_DB_MAX_IDLE": "10",
+	}
+	defer func() {
+		for name := range envs {
+			os.Unsetenv(name)
+		}
+	}()
+
+	failed := 0
+	for i := 0; i < 300; i++ {
+		for name, value := range envs {
+			if rnd.Intn(2) == 0 {
+				value = corrupt(rnd, value)
+			}
+			os.Setenv(name, value)
+		}
+
+		v := presetMutationConf(path)
+		expected := cloneValue(reflect.ValueOf(v)).Interface()
+		if err := Init(v); err != nil {
+			failed++
+			if !assert.Equal(t, expected, v, "seed %d", seed) {
+				return
+			}
+			continue
+		}
+		assert.Equal(t, "app", v.Name)
+		assert.Equal(t, "mysql://localhost", v.DB.URL)
+	}
+	assert.True(t, failed > 0, "seed %d: no corrupted env fails", seed)
+}
+
+func Test_InitFromFile_noPartialMutation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-mutation")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// port 之前的字段已经绑定了, port 失败
+	path := filepath.Join(dir, "conf.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"db": {"url": "mysql://x"}, "hosts": ["x"], "name": "x", "port": "abc"}`), 0644))
+
+	v := presetMutationConf(path)
+	expected := cloneValue(reflect.ValueOf(v)).Interface()
+	assert.NotNil(t, InitFromFile(v))
+	assert.Equal(t, expected, v)
+
+	assert.NotNil(t, initFromFile(path, v))
+	assert.Equal(t, expected, v)
+}
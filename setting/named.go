@@ -0,0 +1,310 @@
+package setting
+
+import (
+	"fmt"
+	"qing/go-helper/error"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// 同一个 section 下的多个同类配置, 比如多个数据库连接, 多个 Kafka 集群:
+//
+//   {
+//     "db": {
+//       "_default": {"maxIdle": 10, "timeout": "3s"},
+//       "primary":  {"url": "mysql://primary"},
+//       "replica1": {"url": "mysql://replica1", "maxIdle": 5}
+//     }
+//   }
+//
+//   dbs, err := setting.InitNamed[DBConf]("conf.json", []string{"db"})
+//   // dbs["primary"].MaxIdle == 10, dbs["replica1"].MaxIdle == 5
+//
+// 每个实例的值: default tag < _default 节点 < 实例自己的节点 (深度合并, 数组按 SetArrayPolicy), 然后分别执行校验
+// (validate tag 和 CanChecked.Access); 实例名就是节点的 key, 不要包含 . 和 [
+const NamedDefault = "_default"
+
+// 加载 path 中 sections 节点下的所有实例, 文件的解析同 FromFile (include, profile 层, interpolate ...)
+// 没有该节点时返回空的 map; 任何一个实例失败时返回 error, error 中包含所有失败的实例
+// 每个实例的来源会被记录 (见 Explain), 不再使用的实例应该 Forget
+func InitNamed[T any](path string, sections []string) (map[string]*T, error) {
+	instances, provs, err := loadNamed[T](newLoadSession(), path, sections)
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range instances {
+		recordProvenance(v, provs[name])
+	}
+	return instances, nil
+}
+
+func loadNamed[T any](session *loadSession, path string, sections []string) (map[string]*T, map[string]*provenance, error) {
+	typ := reflect.TypeOf(new(T))
+	tree, err := loadFileSource(session, path, sections, nil, nil)
+	if err != nil {
+		return nil, nil, errPkg.FailBy(err, "load named configs fail.", errPkg.Fields{"file": path, "sections": sections})
+	}
+	instances := make(map[string]*T)
+	provs := make(map[string]*provenance)
+	if tree == nil {
+		return instances, provs, nil
+	}
+	obj, ok := tree.Node.(map[string]interface{})
+	if !ok {
+		return nil, nil, errPkg.Fail("named configs must be an object.", errPkg.Fields{
+			"file":     path,
+			"key":      formatKeyPath(tree.prefix),
+			"found":    fmt.Sprintf("%#v", tree.Node),
+			"sections": sections,
+		})
+	}
+
+	defaults := namedTree(tree, NamedDefault)
+	failed := make(map[string]error)
+	names := make([]string, 0)
+	for _, name := range sortedKeys(obj) {
+		if name == NamedDefault {
+			continue
+		}
+		v := new(T)
+		prov, err := bindNamed(v, defaults, namedTree(tree, name), path)
+		if err != nil {
+			failed[name] = err
+			names = append(names, name)
+			continue
+		}
+		instances[name], provs[name] = v, prov
+	}
+	if len(failed) > 0 {
+		return nil, nil, errPkg.Fail("init named configs fail.", errPkg.Fields{
+			"instances": failed,
+			"names":     names,
+			"type":      typ.String(),
+			"file":      path,
+		})
+	}
+	return instances, provs, nil
+}
+
+// tree 中 name 节点对应的子树, 层的 Origin 仍然相对于文件的根节点; 没有该节点时返回 nil
+func namedTree(tree *SourceTree, name string) *SourceTree {
+	obj, _ := tree.Node.(map[string]interface{})
+	node, ok := obj[name]
+	if !ok {
+		return nil
+	}
+	sub := &SourceTree{Node: node, prefix: appendSeg(tree.prefix, name)}
+	for _, layer := range tree.Layers {
+		layer := layer
+		layerObj, _ := layer.Node.(map[string]interface{})
+		layerNode, ok := layerObj[name]
+		if !ok {
+			continue
+		}
+		sub.Layers = append(sub.Layers, &SourceTree{Node: layerNode, Origin: func(segs []interface{}) Origin {
+			return layer.Origin(append([]interface{}{name}, segs...))
+		}})
+	}
+	return sub
+}
+
+// 依次绑定 _default 和实例自己的节点, 再校验
+func bindNamed(v interface{}, defaults, instance *SourceTree, path string) (*provenance, error) {
+	if err := applyDefaults(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	if _, ok := instance.Node.(map[string]interface{}); !ok {
+		return nil, errPkg.Fail("named config must be an object.", errPkg.Fields{
+			"key":   formatKeyPath(instance.prefix),
+			"found": fmt.Sprintf("%#v", instance.Node),
+		})
+	}
+
+	// 合并后的树, 层的顺序就是优先级, 用于定位出错的 key 和 Explain
+	merged := &SourceTree{Node: cloneTree(instance.Node), prefix: instance.prefix}
+	if defaults != nil {
+		node, ok := cloneTree(defaults.Node).(map[string]interface{})
+		if !ok {
+			return nil, errPkg.Fail("named config must be an object.", errPkg.Fields{
+				"key":   formatKeyPath(defaults.prefix),
+				"found": fmt.Sprintf("%#v", defaults.Node),
+			})
+		}
+		mergeTree(node, merged.Node.(map[string]interface{}), currentArrayPolicy())
+		merged.Node = node
+		merged.Layers = append(merged.Layers, defaults.Layers...)
+	}
+	merged.Layers = append(merged.Layers, instance.Layers...)
+
+	origin := func(segs []interface{}) Origin {
+		return Origin{Kind: OriginFile, Name: path}
+	}
+	if isStrict(v) {
+		unknown := findUnknownKeys(merged.Node, reflect.TypeOf(v), nil, func(segs []interface{}) string {
+			return locateKey(merged, origin, segs).String()
+		})
+		if err := unknownKeysFail(unknown); err != nil {
+			return nil, errPkg.FailBy(err, "config file has unknown keys in strict mode.", errPkg.Fields{"file": path})
+		}
+	}
+	if err := bindTree(merged.Node, v); err != nil {
+		return nil, bindSourceFail(err, merged, origin, "unmarshal config file's content bytes to confObj fail.",
+			errPkg.Fields{"file": path})
+	}
+	if err := Validate(v); err != nil {
+		return nil, err
+	}
+	if needCheck, ok := v.(CanChecked); ok {
+		if err := needCheck.Access(); err != nil {
+			return nil, errPkg.FailBy(err, "do check by Access() fail.", nil)
+		}
+	}
+
+	prov := newProvenance()
+	prov.addSource(merged, origin)
+	return prov, nil
+}
+
+type NamedEventType string
+
+const (
+	NamedAdded   NamedEventType = "added"
+	NamedRemoved NamedEventType = "removed"
+	NamedChanged NamedEventType = "changed"
+)
+
+// 重新加载后一个实例的变化: 新增时 Old 为 nil, 删除时 New 为 nil; Diff 是具体变化的 key, 见 Diff
+type NamedEvent[T any] struct {
+	Type NamedEventType
+	Name string
+	Old  *T
+	New  *T
+	Diff Diff
+}
+
+// 热加载的多实例配置, 见 WatchNamed
+type Named[T any] struct {
+	path     string
+	sections []string
+	current  atomic.Value
+
+	reloadMu    sync.Mutex
+	subMu       sync.RWMutex
+	subscribers []func(e NamedEvent[T])
+	notify      notifier
+
+	loop *watchLoop
+}
+
+// 同 InitNamed, 并开始监听配置文件的变化, watchOpts 见 WatchOptions, 可以为 nil
+// 重新加载时所有实例都成功才一起替换, 否则通过 OnError 报告并继续使用上一次成功的配置
+func WatchNamed[T any](path string, sections []string, watchOpts *WatchOptions) (*Named[T], error) {
+	n := &Named[T]{path: path, sections: sections}
+	instances, err := InitNamed[T](path, sections)
+	if err != nil {
+		return nil, err
+	}
+	n.current.Store(instances)
+
+	opts := watchOptions(watchOpts)
+	n.loop = newWatchLoop(opts, func() {
+		if err := n.Reload(); err != nil && opts.OnError != nil {
+			opts.OnError(err)
+		}
+	})
+	plan := loadPlan{fromFile: true, path: path, sections: sections, only: map[OriginKind]bool{OriginFile: true}}
+	if err = n.loop.watchSources(new(T), plan); err != nil {
+		n.loop.close()
+		return nil, err
+	}
+	n.loop.run()
+	return n, nil
+}
+
+// 最新的所有实例, 返回的 map 和值都不要修改
+func (n *Named[T]) Get() map[string]*T {
+	return n.current.Load().(map[string]*T)
+}
+
+// 最新的一个实例
+func (n *Named[T]) Instance(name string) (*T, bool) {
+	v, ok := n.Get()[name]
+	return v, ok
+}
+
+// 订阅实例的新增, 删除和变化, 每次重新加载按实例名的顺序通知, 没有变化的实例不会通知
+func (n *Named[T]) Subscribe(fn func(e NamedEvent[T])) {
+	n.subMu.Lock()
+	defer n.subMu.Unlock()
+	n.subscribers = append(n.subscribers, fn)
+}
+
+// 立即重新加载, 任何一个实例不合法时返回 error 并继续使用上一次成功的配置
+// 订阅者在重新加载完成之后才被通知, 订阅者中也可以调用 Reload
+func (n *Named[T]) Reload() error {
+	n.reloadMu.Lock()
+	err := n.reload()
+	n.reloadMu.Unlock()
+	n.notify.drain()
+	return err
+}
+
+// 替换实例, 事件放入 n.notify, 由 Reload 在释放 reloadMu 之后发出
+func (n *Named[T]) reload() error {
+	fresh, provs, err := loadNamed[T](newLoadSession(), n.path, n.sections)
+	if err != nil {
+		return errPkg.FailBy(err, "reload named configs fail, keep the last good ones.",
+			errPkg.Fields{"type": reflect.TypeOf(new(T)).String()})
+	}
+	old := n.Get()
+
+	events := make([]NamedEvent[T], 0)
+	for name, v := range fresh {
+		prev, ok := old[name]
+		if ok && reflect.DeepEqual(prev, v) {
+			// 没有变化, 继续使用原来的实例
+			fresh[name] = prev
+			continue
+		}
+		recordProvenance(v, provs[name])
+		if !ok {
+			events = append(events, NamedEvent[T]{Type: NamedAdded, Name: name, New: v, Diff: changesOf(v, nil)})
+			continue
+		}
+		events = append(events, NamedEvent[T]{Type: NamedChanged, Name: name, Old: prev, New: v,
+			Diff: changesOf(v, snapshotLeaves(prev))})
+		Forget(prev)
+	}
+	for name, prev := range old {
+		if _, ok := fresh[name]; !ok {
+			events = append(events, NamedEvent[T]{Type: NamedRemoved, Name: name, Old: prev,
+				Diff: compareLeaves(snapshotLeaves(prev), nil)})
+			Forget(prev)
+		}
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	n.current.Store(fresh)
+
+	sort.Slice(events, func(i, j int) bool { return events[i].Name < events[j].Name })
+	n.subMu.RLock()
+	subscribers := append([]func(e NamedEvent[T]){}, n.subscribers...)
+	n.subMu.RUnlock()
+	n.notify.push(func() {
+		for _, e := range events {
+			for _, fn := range subscribers {
+				fn(e)
+			}
+		}
+	})
+	return nil
+}
+
+// 停止监听
+func (n *Named[T]) Close() error {
+	n.loop.close()
+	return nil
+}
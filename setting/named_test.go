@@ -0,0 +1,187 @@
+package setting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"qing/go-helper/error"
+	"testing"
+	"time"
+)
+
+type namedConf struct {
+	URL     string        `json:"url" validate:"required"`
+	MaxIdle int           `json:"maxIdle"`
+	Timeout time.Duration `json:"timeout" default:"3s"`
+	Tags    []string      `json:"tags"`
+}
+
+func Test_InitNamed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-named")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.json")
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{
+  "services": {
+    "db": {
+      "_default": {"maxIdle": 10, "tags": ["base"]},
+      "primary": {"url": "mysql://primary"},
+      "replica1": {"url": "mysql://replica1", "maxIdle": 5, "timeout": "1s"}
+    }
+  }
+}`), 0644))
+
+	dbs, err := InitNamed[namedConf](path, []string{"services", "db"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]*namedConf{
+		"primary":  {URL: "mysql://primary", MaxIdle: 10, Timeout: 3 * time.Second, Tags: []string{"base"}},
+		"replica1": {URL: "mysql://replica1", MaxIdle: 5, Timeout: time.Second, Tags: []string{"base"}},
+	}, dbs)
+
+	// 来源: 继承的值指向 _default 节点
+	e, err := Explain(dbs["primary"])
+	assert.Nil(t, err)
+	sources := make(map[string]Origin)
+	for _, entry := range e.Entries {
+		sources[entry.Key] = entry.Source
+	}
+	assert.Equal(t, Origin{Kind: OriginFile, Name: path, Line: 4, Column: 20}, sources["maxIdle"])
+	assert.Equal(t, Origin{Kind: OriginFile, Name: path, Line: 5, Column: 19}, sources["url"])
+
+	// 没有该节点
+	dbs, err = InitNamed[namedConf](path, []string{"services", "kafka"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]*namedConf{}, dbs)
+
+	// 所有失败的实例都会报告, 绑定错误带有位置
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{
+  "db": {
+    "_default": {"maxIdle": 10},
+    "a": {"url": "mysql://a", "maxIdle": "ten"},
+    "b": {"maxIdle": 1},
+    "c": {"url": "mysql://c"}
+  }
+}`), 0644))
+	_, err = InitNamed[namedConf](path, []string{"db"})
+	if assert.NotNil(t, err) {
+		known := err.(*errPkg.Err)
+		assert.Equal(t, []string{"a", "b"}, known.Fields["names"])
+		instances := known.Fields["instances"].(map[string]error)
+		bindErr := instances["a"].(*errPkg.Err)
+		assert.Equal(t, "db.a.maxIdle", bindErr.Fields["key"])
+		assert.Equal(t, 4, bindErr.Fields["line"])
+		assert.NotNil(t, instances["b"])
+	}
+
+	// 实例名和 error 的其他字段同名时不会互相覆盖
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"db": {"file": {"maxIdle": 1}, "type": {"maxIdle": 1}}}`), 0644))
+	_, err = InitNamed[namedConf](path, []string{"db"})
+	if assert.NotNil(t, err) {
+		known := err.(*errPkg.Err)
+		assert.Equal(t, path, known.Fields["file"])
+		assert.Equal(t, "*setting.namedConf", known.Fields["type"])
+		assert.Len(t, known.Fields["instances"], 2)
+	}
+}
+
+func Test_WatchNamed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-named")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.json")
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"db": {
+  "_default": {"maxIdle": 10},
+  "primary": {"url": "mysql://primary"},
+  "replica1": {"url": "mysql://replica1"},
+  "replica2": {"url": "mysql://replica2"}
+}}`), 0644))
+
+	// 只通过 Reload 重新加载
+	named, err := WatchNamed[namedConf](path, []string{"db"}, &WatchOptions{Debounce: time.Hour})
+	assert.Nil(t, err)
+	defer named.Close()
+	assert.Len(t, named.Get(), 3)
+	replica2, _ := named.Instance("replica2")
+
+	events := make([]NamedEvent[namedConf], 0)
+	named.Subscribe(func(e NamedEvent[namedConf]) {
+		events = append(events, e)
+	})
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"db": {
+  "_default": {"maxIdle": 10},
+  "primary": {"url": "mysql://primary", "maxIdle": 20},
+  "replica2": {"url": "mysql://replica2"},
+  "replica3": {"url": "mysql://replica3"}
+}}`), 0644))
+	assert.Nil(t, named.Reload())
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, NamedChanged, events[0].Type)
+		assert.Equal(t, "primary", events[0].Name)
+		assert.Equal(t, 10, events[0].Old.MaxIdle)
+		assert.Equal(t, 20, events[0].New.MaxIdle)
+		if assert.Len(t, events[0].Diff.Changed, 1) {
+			assert.Equal(t, "maxIdle", events[0].Diff.Changed[0].Key)
+		}
+
+		assert.Equal(t, NamedRemoved, events[1].Type)
+		assert.Equal(t, "replica1", events[1].Name)
+		assert.Nil(t, events[1].New)
+		assert.Equal(t, "mysql://replica1", events[1].Old.URL)
+		assert.NotEmpty(t, events[1].Diff.Removed)
+
+		assert.Equal(t, NamedAdded, events[2].Type)
+		assert.Equal(t, "replica3", events[2].Name)
+		assert.Nil(t, events[2].Old)
+		assert.Equal(t, 10, events[2].New.MaxIdle)
+		assert.NotEmpty(t, events[2].Diff.Added)
+	}
+	// 没有变化的实例还是原来的值
+	current, _ := named.Instance("replica2")
+	assert.True(t, replica2 == current)
+
+	// 不合法的配置不会生效
+	events = events[:0]
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"db": {"primary": {"url": ""}}}`), 0644))
+	assert.NotNil(t, named.Reload())
+	assert.Empty(t, events)
+	assert.Len(t, named.Get(), 3)
+
+	// 没有变化时不通知
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"db": {
+  "_default": {"maxIdle": 10},
+  "primary": {"url": "mysql://primary", "maxIdle": 20},
+  "replica2": {"url": "mysql://replica2"},
+  "replica3": {"url": "mysql://replica3"}
+}}`), 0644))
+	assert.Nil(t, named.Reload())
+	assert.Empty(t, events)
+
+	// 订阅者中可以再次 Reload
+	var nestedErr error
+	named.Subscribe(func(e NamedEvent[namedConf]) {
+		if e.Type == NamedAdded && e.Name == "replica4" {
+			assert.Nil(t, ioutil.WriteFile(path, []byte(`{"db": {"primary": {"url": "mysql://primary"}}}`), 0644))
+			nestedErr = named.Reload()
+		}
+	})
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"db": {
+  "primary": {"url": "mysql://primary"},
+  "replica4": {"url": "mysql://replica4"}
+}}`), 0644))
+	done := make(chan error, 1)
+	go func() {
+		done <- named.Reload()
+	}()
+	select {
+	case err = <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reload in a subscriber deadlocks")
+	}
+	assert.Nil(t, nestedErr)
+	assert.Len(t, named.Get(), 1)
+}
@@ -0,0 +1,263 @@
+package setting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"qing/go-helper/error"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// 配置项在文件中的位置, Line 和 Column 都从 1 开始, Column 按字符 (rune) 计算
+type Position struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+}
+
+func (pos Position) String() string {
+	switch {
+	case pos.Line == 0:
+		return pos.File
+	case pos.Column == 0:
+		return fmt.Sprintf("%s:%d", pos.File, pos.Line)
+	}
+	return fmt.Sprintf("%s:%d:%d", pos.File, pos.Line, pos.Column)
+}
+
+// Decoder 可以选择实现该接口, 在解析的同时返回每个 key 的位置, 用于 Explain 和错误信息
+// positions 的 key 是从根节点开始的路径, 形如 db.replicas[1].url
+type PositionDecoder interface {
+	DecodePositions(r io.Reader) (tree map[string]interface{}, positions map[string]Position, err error)
+}
+
+// 行首的 offset, 用于将 offset 转换为行列
+type lineIndex struct {
+	content []byte
+	starts  []int
+}
+
+func newLineIndex(content []byte) *lineIndex {
+	starts := []int{0}
+	for i, b := range content {
+		if b == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return &lineIndex{content: content, starts: starts}
+}
+
+func (index *lineIndex) position(offset int) Position {
+	if offset > len(index.content) {
+		offset = len(index.content)
+	}
+	line := sort.Search(len(index.starts), func(i int) bool {
+		return index.starts[i] > offset
+	}) - 1
+	if line < 0 {
+		line = 0
+	}
+	column := utf8.RuneCount(index.content[index.starts[line]:offset]) + 1
+	return Position{Line: line + 1, Column: column}
+}
+
+func decodeJSONPositions(r io.Reader) (map[string]interface{}, map[string]Position, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	d := &jsonPositionDecoder{
+		content:   content,
+		index:     newLineIndex(content),
+		decoder:   json.NewDecoder(bytes.NewReader(content)),
+		positions: make(map[string]Position),
+	}
+	d.decoder.UseNumber()
+
+	node, err := d.value(nil)
+	if err != nil {
+		return nil, nil, d.wrap(err)
+	}
+	return wrapRoot(node), d.positions, nil
+}
+
+type jsonPositionDecoder struct {
+	content   []byte
+	index     *lineIndex
+	decoder   *json.Decoder
+	positions map[string]Position
+}
+
+// json 的语法错误带上行列
+func (d *jsonPositionDecoder) wrap(err error) error {
+	var offset int64 = -1
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		offset = int64(len(d.content))
+	}
+	if offset < 0 {
+		return err
+	}
+	pos := d.index.position(int(offset))
+	return errPkg.FailBy(err, "decode json fail.", errPkg.Fields{"line": pos.Line, "column": pos.Column})
+}
+
+func (d *jsonPositionDecoder) value(segs []interface{}) (interface{}, error) {
+	token, err := d.decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '{' {
+			return d.object(segs)
+		}
+		if t == '[' {
+			return d.array(segs)
+		}
+		return nil, &json.SyntaxError{Offset: d.decoder.InputOffset()}
+	case json.Number:
+		return normalizeNumbers(t), nil
+	}
+	return token, nil
+}
+
+func (d *jsonPositionDecoder) object(segs []interface{}) (map[string]interface{}, error) {
+	obj := make(map[string]interface{})
+	for d.decoder.More() {
+		token, err := d.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return nil, &json.SyntaxError{Offset: d.decoder.InputOffset()}
+		}
+		keySegs := appendSeg(segs, key)
+		d.positions[formatKeyPath(keySegs)] = d.index.position(d.keyStart(int(d.decoder.InputOffset())))
+
+		if obj[key], err = d.value(keySegs); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := d.decoder.Token(); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (d *jsonPositionDecoder) array(segs []interface{}) ([]interface{}, error) {
+	items := make([]interface{}, 0)
+	for d.decoder.More() {
+		itemSegs := appendSeg(segs, len(items))
+		d.positions[formatKeyPath(itemSegs)] = d.index.position(d.valueStart(int(d.decoder.InputOffset())))
+		item, err := d.value(itemSegs)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if _, err := d.decoder.Token(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// end 是 key 的结束引号之后的 offset, 向前找到开始的引号
+func (d *jsonPositionDecoder) keyStart(end int) int {
+	for i := end - 2; i >= 0; i-- {
+		if d.content[i] == '"' && (i == 0 || d.content[i-1] != '\\') {
+			return i
+		}
+	}
+	return end
+}
+
+// 跳过空白和逗号, 找到下一个值的开始
+func (d *jsonPositionDecoder) valueStart(offset int) int {
+	for offset < len(d.content) && strings.IndexByte(" \t\r\n,", d.content[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// toml 的解析库没有公开每个 key 的位置, 这里按行扫描 [table], [[array]] 和 key = value
+// 不支持 inline table 中的 key 和多行字符串中形似 key 的内容
+func tomlPositions(content []byte) map[string]Position {
+	positions := make(map[string]Position)
+	var table []interface{}
+	arrayCounts := make(map[string]int)
+	inMultiline := ""
+
+	for i, raw := range strings.Split(string(content), "\n") {
+		line := strings.TrimSpace(raw)
+		column := utf8.RuneCountInString(raw[:len(raw)-len(strings.TrimLeft(raw, " \t"))]) + 1
+
+		if inMultiline != "" {
+			if strings.Contains(line, inMultiline) {
+				inMultiline = ""
+			}
+			continue
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[[") {
+			end := strings.Index(line, "]]")
+			if end < 0 {
+				continue
+			}
+			key := formatKeyPath(tomlKeySegs(line[2:end]))
+			segs := append(tomlKeySegs(line[2:end]), arrayCounts[key])
+			arrayCounts[key]++
+			table = segs
+			positions[formatKeyPath(segs)] = Position{Line: i + 1, Column: column}
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				continue
+			}
+			table = tomlKeySegs(line[1:end])
+			positions[formatKeyPath(table)] = Position{Line: i + 1, Column: column}
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq <= 0 {
+			continue
+		}
+		segs := append(append([]interface{}{}, table...), tomlKeySegs(line[:eq])...)
+		positions[formatKeyPath(segs)] = Position{Line: i + 1, Column: column}
+
+		value := strings.TrimSpace(line[eq+1:])
+		for _, quote := range []string{`"""`, `'''`} {
+			if strings.HasPrefix(value, quote) && !strings.Contains(value[3:], quote) {
+				inMultiline = quote
+			}
+		}
+	}
+	return positions
+}
+
+func tomlKeySegs(key string) []interface{} {
+	segs := make([]interface{}, 0)
+	for _, part := range strings.Split(key, ".") {
+		part = strings.Trim(strings.TrimSpace(part), `"'`)
+		if part != "" {
+			segs = append(segs, part)
+		}
+	}
+	return segs
+}
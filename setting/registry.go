@@ -0,0 +1,290 @@
+package setting
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"qing/go-helper/error"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// 多个 confObj 共用同一个配置文件 (不同的 section) 时, 各自 Init 会把文件解析多次
+// Register 之后通过 LoadAll 一起加载, 每个配置文件 (以及它的 include / profile 层) 和配置中心的 namespace 只解析一次
+//
+//   package log
+//   func init() {
+//     setting.Register(conf)
+//   }
+//
+//   package main
+//   func main() {
+//     if err := setting.LoadAll(); err != nil {
+//       panic(err.Error())
+//     }
+//   }
+//
+// LoadAll 先把所有 confObj 加载到各自的拷贝上, 全部成功 (包括 validate 和 Access) 之后才一起赋值, 任何一个失败时所有 confObj
+// 都保持不变, 返回的 error 包含每个失败的 confObj 的原因
+// 热加载见 WatchAll, 每个值的来源同样可以通过 Explain 查看
+
+// 一次加载中解析过的配置文件和配置中心的 namespace
+type loadSession struct {
+	mu      sync.Mutex
+	files   map[string]*loadedFile
+	centers map[string]*loadedCenter
+}
+
+type loadedFile struct {
+	layers []fileLayer
+	err    error
+}
+
+type loadedCenter struct {
+	tree map[string]interface{}
+	err  error
+}
+
+func newLoadSession() *loadSession {
+	return &loadSession{files: make(map[string]*loadedFile), centers: make(map[string]*loadedCenter)}
+}
+
+// 返回的 layers 不能被修改, 合并见 mergeLayers
+func (s *loadSession) fileLayers(path string) ([]fileLayer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loaded, ok := s.files[path]
+	if !ok {
+		loaded = &loadedFile{}
+		loaded.layers, _, loaded.err = loadFileLayers(path)
+		s.files[path] = loaded
+	}
+	return loaded.layers, loaded.err
+}
+
+// 每次返回一份拷贝
+func (s *loadSession) centerTree(namespace string) (map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loaded, ok := s.centers[namespace]
+	if !ok {
+		loaded = &loadedCenter{}
+		loaded.tree, loaded.err = loadConfigCenterTree(namespace)
+		s.centers[namespace] = loaded
+	}
+	if loaded.err != nil {
+		return nil, loaded.err
+	}
+	return cloneTree(loaded.tree).(map[string]interface{}), nil
+}
+
+type registration struct {
+	v reflect.Value
+	// Register 时 v 的值, 每次加载都以它为默认值
+	base reflect.Value
+	// WatchAll 期间不为 nil
+	watcher *Watcher
+}
+
+var (
+	registryMu    sync.Mutex
+	registrations []*registration
+	watching      *watchGroup
+	// 串行执行 LoadAll; 加载时不持有 registryMu, 以便 Access 等回调中调用 Registered, WatcherOf
+	loadAllMu sync.Mutex
+)
+
+// 注册 confObj, 之后由 LoadAll 加载, v 必须是非 nil 的指针, 重复注册会被忽略
+// v 在 Register 时的值作为默认值, 所以应该先设置好代码中的默认值再 Register
+func Register(v interface{}) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		panic(fmt.Sprintf("setting: Register confObj must be a non-nil pointer, got %T", v))
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if lookupRegistration(v) != nil {
+		return
+	}
+	registrations = append(registrations, &registration{v: rv, base: cloneValue(rv)})
+}
+
+// 已注册的 confObj, 按注册的顺序
+func Registered() []interface{} {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	result := make([]interface{}, 0, len(registrations))
+	for _, reg := range registrations {
+		result = append(result, reg.v.Interface())
+	}
+	return result
+}
+
+func lookupRegistration(v interface{}) *registration {
+	for _, reg := range registrations {
+		if reg.v.Interface() == v {
+			return reg
+		}
+	}
+	return nil
+}
+
+// 加载所有注册的 confObj, 见 Register
+// WatchAll 期间调用时, 等同于 WatchAll 的重新加载, 新的值需要通过 WatcherOf(v).Get() 读取
+func LoadAll() error {
+	loadAllMu.Lock()
+	defer loadAllMu.Unlock()
+
+	registryMu.Lock()
+	group := watching
+	regs := append([]*registration(nil), registrations...)
+	registryMu.Unlock()
+	if group != nil {
+		return group.reload()
+	}
+
+	fresh, provs, err := loadRegistrations(regs)
+	if err != nil {
+		return err
+	}
+	for i, reg := range regs {
+		v := reg.v.Interface()
+		var old []leafSnapshot
+		_, reinit := provenances.Load(v)
+		if reinit {
+			old = snapshotLeaves(v)
+		}
+		reg.v.Elem().Set(fresh[i].Elem())
+		recordProvenance(v, provs[i])
+		if reinit {
+			changesOf(v, old)
+		}
+	}
+	return nil
+}
+
+// 在 regs 的 base 的拷贝上加载, 共用一个 session; 返回的 error 包含所有失败的 confObj
+func loadRegistrations(regs []*registration) ([]reflect.Value, []*provenance, error) {
+	session := newLoadSession()
+	fresh := make([]reflect.Value, len(regs))
+	provs := make([]*provenance, len(regs))
+	failed := make(map[string]error)
+	types := make([]string, 0)
+	for i, reg := range regs {
+		fresh[i] = cloneValue(reg.base)
+		prov, err := initWith(context.Background(), fresh[i].Interface(), planOf(fresh[i].Interface()), session)
+		if err != nil {
+			name := reg.v.Type().String()
+			if _, ok := failed[name]; ok {
+				name = fmt.Sprintf("%s#%d", name, i)
+			}
+			failed[name] = err
+			types = append(types, name)
+			continue
+		}
+		provs[i] = prov
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(types)
+		return nil, nil, errPkg.Fail("load registered confObjs fail.", errPkg.Fields{"failed": failed, "types": types})
+	}
+	return fresh, provs, nil
+}
+
+// 对所有注册的 confObj 执行 LoadAll, 并开始监听它们的变化 (选项同 Watch)
+// 任何一个源变化时, 所有 confObj 一起重新加载, 全部成功才一起替换, 否则通过 OnError 报告并继续使用上一次成功的配置
+// 每个 confObj 最新的值和订阅见 WatcherOf; WatchAll 之后注册的 confObj 不会被监听
+func WatchAll(opts *WatchOptions) (io.Closer, error) {
+	if err := LoadAll(); err != nil {
+		return nil, err
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if watching != nil {
+		return nil, errPkg.Fail("registered confObjs are already watched.", nil)
+	}
+
+	group := &watchGroup{regs: append([]*registration(nil), registrations...)}
+	o := watchOptions(opts)
+	group.loop = newWatchLoop(o, func() {
+		if err := group.reload(); err != nil && o.OnError != nil {
+			o.OnError(err)
+		}
+	})
+	for _, reg := range group.regs {
+		w := newWatcher(reg.base, o)
+		w.reloadAll = group.reload
+		w.current.Store(reg.v.Interface())
+		reg.watcher = w
+		group.watchers = append(group.watchers, w)
+
+		if err := group.loop.watchSources(reg.v.Interface(), planOf(reg.v.Interface())); err != nil {
+			group.loop.close()
+			group.detach()
+			return nil, err
+		}
+	}
+	group.loop.run()
+	watching = group
+	return group, nil
+}
+
+// WatchAll 期间 v 的 Watcher, v 没有注册或者没有在监听时返回 nil
+// Watcher.Reload 会重新加载所有注册的 confObj
+func WatcherOf(v interface{}) *Watcher {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if reg := lookupRegistration(v); reg != nil {
+		return reg.watcher
+	}
+	return nil
+}
+
+type watchGroup struct {
+	regs     []*registration
+	watchers []*Watcher
+	loop     *watchLoop
+	reloadMu sync.Mutex
+}
+
+// 订阅者在释放 reloadMu 之后才被通知, 见 notifier
+func (g *watchGroup) reload() error {
+	g.reloadMu.Lock()
+	fresh, provs, err := loadRegistrations(g.regs)
+	if err != nil {
+		g.reloadMu.Unlock()
+		return errPkg.FailBy(err, "reload config fail, keep the last good one.", nil)
+	}
+	for i, w := range g.watchers {
+		recordProvenance(fresh[i].Interface(), provs[i])
+		w.swap(fresh[i])
+	}
+	g.reloadMu.Unlock()
+
+	for _, w := range g.watchers {
+		w.notify.drain()
+	}
+	return nil
+}
+
+// 停止监听, 之后 WatcherOf 返回 nil, 可以再次 WatchAll
+func (g *watchGroup) Close() error {
+	g.loop.close()
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	g.detach()
+	if watching == g {
+		watching = nil
+	}
+	return nil
+}
+
+func (g *watchGroup) detach() {
+	for _, reg := range g.regs {
+		reg.watcher = nil
+	}
+}
@@ -0,0 +1,215 @@
+package setting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type registryLog struct {
+	path  string
+	Level string `json:"level" validate:"oneof=DEBUG INFO WARN"`
+}
+
+func (conf *registryLog) FromFile() (string, []string) {
+	return conf.path, []string{"log"}
+}
+
+type registryDB struct {
+	path string
+	URL  string `json:"url" validate:"required"`
+	Port int    `json:"port" default:"3306"`
+}
+
+func (conf *registryDB) FromFile() (string, []string) {
+	return conf.path, []string{"db"}
+}
+
+func resetRegistry() {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registrations, watching = nil, nil
+}
+
+func Test_LoadAll(t *testing.T) {
+	defer resetRegistry()
+	dir, err := ioutil.TempDir("", "setting-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var decoded int32
+	RegisterFormat(".cnt", DecoderFunc(func(r io.Reader) (map[string]interface{}, error) {
+		atomic.AddInt32(&decoded, 1)
+		return parseJSON(r)
+	}))
+	defer func() {
+		formatsMu.Lock()
+		formats = formats[:len(formats)-1]
+		formatsMu.Unlock()
+	}()
+	path := filepath.Join(dir, "conf.cnt")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"log": {"level": "DEBUG"}, "db": {"url": "mysql://a"}}`)
+
+	log := &registryLog{path: path, Level: "INFO"}
+	db := &registryDB{path: path}
+	Register(log)
+	Register(db)
+	Register(log)
+	assert.Equal(t, []interface{}{log, db}, Registered())
+	assert.Panics(t, func() { Register(registryLog{}) })
+
+	assert.Nil(t, LoadAll())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&decoded), "file is parsed once")
+	assert.Equal(t, "DEBUG", log.Level)
+	assert.Equal(t, &registryDB{path: path, URL: "mysql://a", Port: 3306}, db)
+	defer Forget(log)
+	defer Forget(db)
+	e, err := Explain(db)
+	assert.Nil(t, err)
+	assert.Equal(t, OriginFile, e.Entries[0].Source.Kind)
+
+	// 每个 confObj 的错误都在同一个 error 中, 所有 confObj 都不变
+	write(`{"log": {"level": "TRACE"}, "db": {"port": 3307}}`)
+	err = LoadAll()
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "*setting.registryLog")
+		assert.Contains(t, err.Error(), "*setting.registryDB")
+	}
+	assert.Equal(t, "DEBUG", log.Level)
+	assert.Equal(t, 3306, db.Port)
+
+	// 默认值来自 Register 时的值
+	write(`{"db": {"url": "mysql://b"}}`)
+	assert.Nil(t, LoadAll())
+	assert.Equal(t, "INFO", log.Level)
+	assert.Equal(t, "mysql://b", db.URL)
+}
+
+type registryAccess struct {
+	path       string
+	URL        string `json:"url"`
+	registered int
+	watcher    *Watcher
+}
+
+func (conf *registryAccess) FromFile() (string, []string) {
+	return conf.path, []string{"db"}
+}
+
+func (conf *registryAccess) Access() error {
+	conf.registered = len(Registered())
+	conf.watcher = WatcherOf(conf)
+	return nil
+}
+
+// 加载时不持有注册表的锁, Access 中可以调用 Registered 和 WatcherOf
+func Test_LoadAll_access(t *testing.T) {
+	defer resetRegistry()
+	dir, err := ioutil.TempDir("", "setting-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.json")
+	if err = ioutil.WriteFile(path, []byte(`{"db": {"url": "mysql://a"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &registryAccess{path: path}
+	Register(conf)
+	defer Forget(conf)
+	done := make(chan error, 1)
+	go func() {
+		done <- LoadAll()
+	}()
+	select {
+	case err = <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("LoadAll deadlocks when Access calls Registered")
+	}
+	assert.Equal(t, "mysql://a", conf.URL)
+	assert.Equal(t, 1, conf.registered)
+}
+
+func Test_WatchAll(t *testing.T) {
+	defer resetRegistry()
+	dir, err := ioutil.TempDir("", "setting-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.json")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"log": {"level": "DEBUG"}, "db": {"url": "mysql://a"}}`)
+
+	log := &registryLog{path: path, Level: "INFO"}
+	db := &registryDB{path: path}
+	Register(log)
+	Register(db)
+	assert.Nil(t, WatcherOf(log))
+
+	closer, err := WatchAll(&WatchOptions{Debounce: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = WatchAll(nil)
+	assert.NotNil(t, err, "already watched")
+
+	changes := make(chan *registryDB, 10)
+	assert.Nil(t, WatcherOf(db).Subscribe(func(old, new *registryDB) {
+		changes <- new
+	}))
+	write(`{"log": {"level": "WARN"}, "db": {"url": "mysql://b"}}`)
+	select {
+	case change := <-changes:
+		assert.Equal(t, "mysql://b", change.URL)
+		assert.Equal(t, "WARN", WatcherOf(log).Get().(*registryLog).Level)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no change notified")
+	}
+
+	// 一个不合法, 都不替换
+	write(`{"log": {"level": "TRACE"}, "db": {"url": "mysql://c"}}`)
+	assert.NotNil(t, WatcherOf(db).Reload())
+	assert.Equal(t, "mysql://b", WatcherOf(db).Get().(*registryDB).URL)
+
+	// 订阅者中可以调用 LoadAll
+	loaded := make(chan error, 1)
+	assert.Nil(t, WatcherOf(log).Subscribe(func(old, new *registryLog) {
+		if new.Level == "DEBUG" {
+			loaded <- LoadAll()
+		}
+	}))
+	write(`{"log": {"level": "DEBUG"}, "db": {"url": "mysql://d"}}`)
+	done := make(chan error, 1)
+	go func() {
+		done <- WatcherOf(db).Reload()
+	}()
+	select {
+	case err = <-done:
+		assert.Nil(t, err)
+		assert.Nil(t, <-loaded)
+	case <-time.After(5 * time.Second):
+		t.Fatal("LoadAll in a subscriber deadlocks")
+	}
+
+	assert.Nil(t, closer.Close())
+	assert.Nil(t, WatcherOf(db))
+}
@@ -0,0 +1,311 @@
+package setting
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 字段的说明, 用于生成 JSON Schema, Markdown 文档和示例配置文件, 见 docs.go
+//
+//   type Conf struct {
+//     Level string `json:"level" default:"INFO" validate:"oneof=DEBUG INFO WARN" desc:"日志级别"`
+//   }
+const descTag = "desc"
+
+const (
+	jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+	// time.Duration 可以写作 "1h30m" 或者整数 (纳秒)
+	durationPattern = `^(-?[0-9]+|-?([0-9]*\.?[0-9]+(ns|us|µs|ms|s|m|h))+)$`
+)
+
+// 自定义的类型可以实现该接口, 给出它在配置中的写法, 比如 setting/types 中的类型; 返回的 schema 会被拷贝
+type SchemaProvider interface {
+	JSONSchema() *JSONSchema
+}
+
+var schemaProviderType = reflect.TypeOf((*SchemaProvider)(nil)).Elem()
+
+// JSON Schema (draft-07) 中用到的部分
+// XValidate 是 validate tag 的原文, 不能被 JSON Schema 表达的规则 (比如 file-exists) 也保留在这里
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Default              interface{}            `json:"default,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	MinProperties        *int                   `json:"minProperties,omitempty"`
+	MaxProperties        *int                   `json:"maxProperties,omitempty"`
+	WriteOnly            bool                   `json:"writeOnly,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+	XValidate            string                 `json:"x-validate,omitempty"`
+}
+
+func (s *JSONSchema) JSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// 生成 objs 所在配置文件的 JSON Schema, 实现了 FromFile 的 confObj 放在它的 sections 下
+// objs 应该使用同一个配置文件, 不同的文件请分别生成
+func GenerateSchema(title string, objs ...interface{}) *JSONSchema {
+	root := &JSONSchema{Schema: jsonSchemaDraft, Title: title, Type: "object", Properties: make(map[string]*JSONSchema)}
+	for _, v := range objs {
+		node := root
+		for _, seg := range fileSections(v) {
+			node = schemaChild(node, seg)
+		}
+
+		s := typeSchema(reflect.TypeOf(v), make(map[reflect.Type]bool))
+		if s.Properties == nil && node != root {
+			// confObj 不是 struct
+			*node = *s
+			continue
+		}
+		for k, p := range s.Properties {
+			node.Properties[k] = p
+		}
+		node.Required = append(node.Required, s.Required...)
+	}
+	return root
+}
+
+// section 路径中的一段, 数组下标对应数组的元素
+func schemaChild(node *JSONSchema, seg interface{}) *JSONSchema {
+	if _, ok := seg.(int); ok {
+		if node.Items == nil {
+			node.Type, node.Properties = "array", nil
+			node.Items = &JSONSchema{Type: "object", Properties: make(map[string]*JSONSchema)}
+		}
+		return node.Items
+	}
+
+	key := fmt.Sprint(seg)
+	if node.Properties == nil {
+		node.Properties = make(map[string]*JSONSchema)
+	}
+	child, ok := node.Properties[key]
+	if !ok {
+		child = &JSONSchema{Type: "object", Properties: make(map[string]*JSONSchema)}
+		node.Properties[key] = child
+	}
+	return child
+}
+
+// FromFile 的 sections 解析后的路径, 见 sectionPath
+func fileSections(v interface{}) []interface{} {
+	if f, ok := v.(FromFile); ok {
+		_, sections := f.FromFile()
+		if segs, err := sectionPath(sections); err == nil {
+			return segs
+		}
+	}
+	return nil
+}
+
+func typeSchema(t reflect.Type, visiting map[reflect.Type]bool) *JSONSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case durationType:
+		return &JSONSchema{Type: "string", Pattern: durationPattern}
+	case timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	case secretType:
+		return &JSONSchema{Type: "string", WriteOnly: true}
+	}
+	pt := reflect.PtrTo(t)
+	if pt.Implements(schemaProviderType) {
+		if s := reflect.New(t).Interface().(SchemaProvider).JSONSchema(); s != nil {
+			provided := *s
+			return &provided
+		}
+	}
+	if pt.Implements(jsonUnmarshalerType) {
+		return &JSONSchema{}
+	}
+	if pt.Implements(textUnmarshalerType) {
+		return &JSONSchema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		zero := float64(0)
+		return &JSONSchema{Type: "integer", Minimum: &zero}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: "array", Items: typeSchema(t.Elem(), visiting)}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: typeSchema(t.Elem(), visiting)}
+	case reflect.Struct:
+	default:
+		return &JSONSchema{}
+	}
+
+	s := &JSONSchema{Type: "object", Properties: make(map[string]*JSONSchema)}
+	if visiting[t] {
+		return s
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for _, f := range structFields(t) {
+		fs := typeSchema(f.field.Type, visiting)
+		if applyFieldTags(fs, f.field) {
+			s.Required = append(s.Required, f.name)
+		}
+		s.Properties[f.name] = fs
+	}
+	return s
+}
+
+// 将 desc, default 和 validate tag 转换为 schema 的约束, 返回字段是否 required
+// 有 default tag 的字段不是 required: 配置中缺少时使用默认值, 和加载时一致
+func applyFieldTags(s *JSONSchema, field reflect.StructField) (required bool) {
+	s.Description = field.Tag.Get(descTag)
+	if literal, ok := field.Tag.Lookup(defaultTag); ok {
+		s.Default = typedDefault(literal, field.Type)
+	}
+
+	tag := field.Tag.Get(validateTag)
+	if tag == "" {
+		return false
+	}
+	s.XValidate = tag
+
+	t := field.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for _, r := range splitRules(tag) {
+		switch r.name {
+		case "required":
+			_, hasDefault := field.Tag.Lookup(defaultTag)
+			required = !hasDefault
+		case "min", "max", "len":
+			applySizeRule(s, t, r)
+		case "oneof":
+			for _, option := range strings.Fields(r.param) {
+				s.Enum = append(s.Enum, typedDefault(option, t))
+			}
+		case "regex":
+			s.Pattern = r.param
+		case "url":
+			s.Format = "uri"
+		}
+	}
+	return required
+}
+
+func applySizeRule(s *JSONSchema, t reflect.Type, r rule) {
+	if t == durationType {
+		return
+	}
+	var min, max **int
+	switch t.Kind() {
+	case reflect.String:
+		min, max = &s.MinLength, &s.MaxLength
+	case reflect.Slice, reflect.Array:
+		min, max = &s.MinItems, &s.MaxItems
+	case reflect.Map:
+		min, max = &s.MinProperties, &s.MaxProperties
+	default:
+		// 写作字符串的数值类型 (比如 setting/types 中的 ByteSize), 它的 min, max 不是 JSON 中的数值
+		if s.Type != "integer" && s.Type != "number" {
+			return
+		}
+		f, err := strconv.ParseFloat(r.param, 64)
+		if err != nil {
+			return
+		}
+		if r.name != "max" {
+			s.Minimum = &f
+		}
+		if r.name != "min" {
+			s.Maximum = &f
+		}
+		return
+	}
+
+	n, err := strconv.Atoi(r.param)
+	if err != nil {
+		return
+	}
+	if r.name != "max" {
+		*min = &n
+	}
+	if r.name != "min" {
+		*max = &n
+	}
+}
+
+// tag 中的字面值转换为 JSON 中的值, 比如 "3306" -> 3306, "a,b" -> ["a", "b"], 转换失败时保留字符串
+func typedDefault(literal string, t reflect.Type) interface{} {
+	rv := reflect.New(t).Elem()
+	if err := bindValue(defaultNode(literal, t), rv, ""); err != nil {
+		return literal
+	}
+	return jsonValue(rv)
+}
+
+// 可以被 json 编码的值, time.Duration 等转为字符串, 自定义的基础类型 (比如 Secret) 转为对应的基础类型
+func jsonValue(rv reflect.Value) interface{} {
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if _, ok := rv.Interface().(encoding.TextMarshaler); ok || rv.Type() == durationType {
+		return explainValue(rv)
+	}
+
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]interface{}, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			items = append(items, jsonValue(rv.Index(i)))
+		}
+		return items
+	case reflect.Map:
+		obj := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			obj[fmt.Sprint(iter.Key().Interface())] = jsonValue(iter.Value())
+		}
+		return obj
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint()
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	return explainValue(rv)
+}
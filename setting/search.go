@@ -0,0 +1,124 @@
+package setting
+
+import (
+	"os"
+	"path/filepath"
+	"qing/go-helper/error"
+	"qing/go-helper/util"
+	"strings"
+	"sync"
+)
+
+// FromFile 返回的相对路径, 按以下顺序查找, 使用第一个存在的文件:
+//   1. 工作目录
+//   2. util.GetRuntimeDir(), 即可执行文件所在的目录
+//   3. $XDG_CONFIG_HOME/<app>, XDG_CONFIG_HOME 默认为 ~/.config
+//   4. /etc/<app>
+//   5. 环境变量 SETTING_CONFIG_PATH 中的目录, 以 os.PathListSeparator 分隔
+// 绝对路径直接使用
+//
+// <app> 来自 SetAppName, 默认为可执行文件的文件名 (不含扩展名)
+// 被 systemd / cron 从 / 启动的程序, 工作目录下找不到配置文件时, 依然可以找到可执行文件旁边的配置文件
+const ConfigPathEnv = "SETTING_CONFIG_PATH"
+
+var (
+	searchMu sync.RWMutex
+	appName  string
+	// 请求的路径 -> 实际使用的路径
+	resolvedPaths = make(map[string]string)
+)
+
+func SetAppName(name string) {
+	searchMu.Lock()
+	defer searchMu.Unlock()
+	appName = name
+}
+
+func AppName() string {
+	searchMu.RLock()
+	name := appName
+	searchMu.RUnlock()
+	if name != "" {
+		return name
+	}
+	ex, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	base := filepath.Base(ex)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// 查找 path 时依次尝试的位置
+func SearchPaths(path string) []string {
+	if filepath.IsAbs(path) {
+		return []string{path}
+	}
+
+	candidates := make([]string, 0, 6)
+	if wd, err := os.Getwd(); err == nil {
+		candidates = append(candidates, filepath.Join(wd, path))
+	} else {
+		candidates = append(candidates, path)
+	}
+	if dir, err := util.GetRuntimeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(dir, path))
+	}
+
+	if app := AppName(); app != "" {
+		configHome := os.Getenv("XDG_CONFIG_HOME")
+		if configHome == "" {
+			if home, err := os.UserHomeDir(); err == nil {
+				configHome = filepath.Join(home, ".config")
+			}
+		}
+		if configHome != "" {
+			candidates = append(candidates, filepath.Join(configHome, app, path))
+		}
+		candidates = append(candidates, filepath.Join("/etc", app, path))
+	}
+
+	for _, dir := range filepath.SplitList(os.Getenv(ConfigPathEnv)) {
+		if dir != "" {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		if !seen[candidate] {
+			seen[candidate] = true
+			result = append(result, candidate)
+		}
+	}
+	return result
+}
+
+// 按 SearchPaths 的顺序查找配置文件, 找不到时 error 中列出所有尝试过的位置
+func ResolvePath(path string) (string, error) {
+	tried := SearchPaths(path)
+	for _, candidate := range tried {
+		info, err := os.Stat(candidate)
+		if err == nil && !info.IsDir() {
+			searchMu.Lock()
+			resolvedPaths[path] = candidate
+			searchMu.Unlock()
+			return candidate, nil
+		}
+	}
+
+	_, err := os.Open(tried[0])
+	return "", errPkg.FailBy(err, "open config file fail.", errPkg.Fields{"file": path, "tried": tried})
+}
+
+// 每个配置文件路径实际使用的文件, 用于诊断
+func ResolvedPaths() map[string]string {
+	searchMu.RLock()
+	defer searchMu.RUnlock()
+	result := make(map[string]string, len(resolvedPaths))
+	for k, v := range resolvedPaths {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,48 @@
+package setting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"qing/go-helper/util"
+	"testing"
+)
+
+func TestSearchPaths(t *testing.T) {
+	SetAppName("demo")
+	defer SetAppName("")
+	t.Setenv("XDG_CONFIG_HOME", "/xdg")
+	t.Setenv(ConfigPathEnv, "/a"+string(os.PathListSeparator)+"/b")
+
+	wd, _ := os.Getwd()
+	runtimeDir, _ := util.GetRuntimeDir()
+	assert.Equal(t, []string{
+		filepath.Join(wd, "conf.json"),
+		filepath.Join(runtimeDir, "conf.json"),
+		"/xdg/demo/conf.json",
+		"/etc/demo/conf.json",
+		"/a/conf.json",
+		"/b/conf.json",
+	}, SearchPaths("conf.json"))
+	assert.Equal(t, []string{"/abs/conf.json"}, SearchPaths("/abs/conf.json"))
+}
+
+func TestResolvePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "search.json"), []byte(`{"a": 1}`), 0644)
+
+	t.Setenv(ConfigPathEnv, dir)
+
+	resolved, err := ResolvePath("search.json")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "search.json"), resolved)
+	assert.Equal(t, resolved, ResolvedPaths()["search.json"])
+
+	_, err = ResolvePath("not-exist.json")
+	assert.NotNil(t, err)
+}
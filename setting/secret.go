@@ -0,0 +1,212 @@
+package setting
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"qing/go-helper/error"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// 配置中的值可以引用密钥等敏感信息, 绑定到 confObj 时被解析:
+//   ${env:DB_PASS}             环境变量
+//   ${file:/run/secrets/db}    文件内容 (去掉末尾的换行)
+//   enc:AES256-GCM:xxxx        使用 AES-256-GCM 加密的值, 见 EncryptSecret 和 cmd/setting-secret
+//
+// ${env:...} 和 ${file:...} 可以出现在字符串中间, enc: 必须是整个值
+// 解析出的值还可以是另一个引用, 比如环境变量中存放的是加密的值
+// 引用只能绑定到 Secret 类型的字段 (包括 []Secret, map[string]Secret 等), 其他类型的字段会报错, 以免明文出现在打印和 error 中
+//
+// 解密的 key 是 32 字节, 以 base64 或 hex 编码, 来自环境变量 SETTING_SECRET_KEY, 或者 SETTING_SECRET_KEY_FILE 指向的文件
+const (
+	SecretKeyEnv     = "SETTING_SECRET_KEY"
+	SecretKeyFileEnv = "SETTING_SECRET_KEY_FILE"
+
+	encryptedPrefix = "enc:AES256-GCM:"
+	maxSecretDepth  = 8
+)
+
+var secretRefPattern = regexp.MustCompile(`\$\{(env|file):([^}]+)\}`)
+
+// 敏感的配置值, 打印 (fmt 的 %v %+v %s %#v) 和 json 序列化时都会被遮盖, 通过 Value() 获取明文
+//
+//   type Conf struct {
+//     URL      string         `json:"url"`
+//     Password setting.Secret `json:"password"` // "${env:DB_PASS}"
+//   }
+type Secret string
+
+const secretMask = "******"
+
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return secretMask
+}
+
+func (s Secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + s.String() + `"`), nil
+}
+
+var secretType = reflect.TypeOf(Secret(""))
+
+// t 去掉指针, 数组和 map 之后的元素类型是 Secret
+func isSecretType(t reflect.Type) bool {
+	for {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+			t = t.Elem()
+		default:
+			return t == secretType
+		}
+	}
+}
+
+func isSecretRef(s string) bool {
+	return strings.HasPrefix(s, encryptedPrefix) || secretRefPattern.MatchString(s)
+}
+
+func resolveSecret(s string) (string, error) {
+	for depth := 0; isSecretRef(s); depth++ {
+		if depth == maxSecretDepth {
+			return "", errPkg.Fail("secret reference is nested too deep.", errPkg.Fields{"depth": maxSecretDepth})
+		}
+
+		if strings.HasPrefix(s, encryptedPrefix) {
+			key, err := SecretKey()
+			if err != nil {
+				return "", err
+			}
+			if s, err = DecryptSecret(s, key); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		var resolveErr error
+		s = secretRefPattern.ReplaceAllStringFunc(s, func(ref string) string {
+			match := secretRefPattern.FindStringSubmatch(ref)
+			switch match[1] {
+			case "env":
+				value, ok := os.LookupEnv(match[2])
+				if !ok && resolveErr == nil {
+					resolveErr = errPkg.Fail("env of secret reference is not set.", errPkg.Fields{"env": match[2]})
+				}
+				return value
+			default:
+				content, err := ioutil.ReadFile(match[2])
+				if err != nil && resolveErr == nil {
+					resolveErr = errPkg.FailBy(err, "read file of secret reference fail.", errPkg.Fields{"file": match[2]})
+				}
+				return strings.TrimRight(string(content), "\r\n")
+			}
+		})
+		if resolveErr != nil {
+			return "", resolveErr
+		}
+	}
+	return s, nil
+}
+
+// 读取解密用的 key, 见 SecretKeyEnv, SecretKeyFileEnv
+func SecretKey() ([]byte, error) {
+	if encoded, ok := os.LookupEnv(SecretKeyEnv); ok {
+		return decodeSecretKey(encoded)
+	}
+	if file, ok := os.LookupEnv(SecretKeyFileEnv); ok {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errPkg.FailBy(err, "read secret key file fail.", errPkg.Fields{"file": file})
+		}
+		return decodeSecretKey(string(content))
+	}
+	return nil, errPkg.Fail("secret key is not configured.", errPkg.Fields{"envs": []string{SecretKeyEnv, SecretKeyFileEnv}})
+}
+
+func decodeSecretKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errPkg.Fail("secret key must be 32 bytes encoded by hex or base64.", nil)
+}
+
+// 生成一个新的 key, base64 编码
+func GenerateSecretKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", errPkg.FailBy(err, "generate secret key fail.", nil)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// 加密, 返回 enc:AES256-GCM:base64(nonce + ciphertext)
+func EncryptSecret(plain string, key []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errPkg.FailBy(err, "generate nonce fail.", nil)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// 解密 EncryptSecret 的结果
+func DecryptSecret(value string, key []byte) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return "", errPkg.Fail("value is not encrypted.", errPkg.Fields{"prefix": encryptedPrefix})
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", errPkg.FailBy(err, "decode encrypted value fail.", nil)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errPkg.Fail("encrypted value is too short.", nil)
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errPkg.FailBy(err, "decrypt value fail, wrong key or corrupted value.", nil)
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errPkg.Fail("secret key must be 32 bytes.", errPkg.Fields{"len": len(key)})
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errPkg.FailBy(err, "create aes cipher fail.", nil)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errPkg.FailBy(err, "create gcm fail.", nil)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,81 @@
+package setting
+
+import (
+	"qing/go-helper/error"
+	"strconv"
+	"strings"
+)
+
+// FromFile 的 sections 在解析后的树上查找, 不要求是合法的 Go 标识符 (比如 "db-primary", "1x")
+// 每个 section 都可以是一个 key 路径, 比如 []string{"services.db[0]"} 和 []string{"services", "db[0]"} 相同, 语法见 parseKeyPath
+// key 本身包含 . 或 [ 时, 把 section 写成 Go 的带引号的字符串, 整体作为一个 key, 比如 []string{`"a.b"`, strconv.Quote("svc[1]")}
+// key 先精确匹配, 找不到时再忽略大小写匹配, 和字段的匹配规则相同
+// section 对应的节点可以是任意类型, confObj 也不必是 struct, 比如 map, slice 或者一个标量
+func sectionPath(sections []string) ([]interface{}, error) {
+	segs := make([]interface{}, 0, len(sections))
+	for _, section := range sections {
+		if strings.HasPrefix(section, `"`) {
+			key, err := strconv.Unquote(section)
+			if err != nil {
+				return nil, errPkg.FailBy(err, "invalid quoted section.", errPkg.Fields{"sections": sections, "section": section})
+			}
+			segs = append(segs, key)
+			continue
+		}
+		sub, err := parseKeyPath(section)
+		if err != nil {
+			return nil, errPkg.FailBy(err, "invalid section.", errPkg.Fields{"sections": sections})
+		}
+		if len(sub) == 0 {
+			return nil, errPkg.Fail("invalid section.", errPkg.Fields{"sections": sections})
+		}
+		segs = append(segs, sub...)
+	}
+	return segs, nil
+}
+
+// 返回 segs 对应的节点, 以及它在树中实际的路径 (忽略大小写匹配时 key 可能和 segs 不同)
+// 根节点不是对象 (见 rootKey) 时, 只有没有 sections 才能找到它
+func lookupSection(node interface{}, segs []interface{}) (interface{}, []interface{}, bool) {
+	if obj, ok := node.(map[string]interface{}); ok && len(obj) == 1 {
+		if root, ok := obj[rootKey]; ok {
+			return root, nil, len(segs) == 0
+		}
+	}
+	resolved := make([]interface{}, 0, len(segs))
+	for _, seg := range segs {
+		switch s := seg.(type) {
+		case string:
+			obj, ok := node.(map[string]interface{})
+			if !ok {
+				return nil, nil, false
+			}
+			key, ok := sectionKey(obj, s)
+			if !ok {
+				return nil, nil, false
+			}
+			node = obj[key]
+			resolved = append(resolved, key)
+		case int:
+			items, ok := node.([]interface{})
+			if !ok || s >= len(items) {
+				return nil, nil, false
+			}
+			node = items[s]
+			resolved = append(resolved, s)
+		}
+	}
+	return node, resolved, true
+}
+
+func sectionKey(obj map[string]interface{}, key string) (string, bool) {
+	if _, ok := obj[key]; ok {
+		return key, true
+	}
+	for _, k := range sortedKeys(obj) {
+		if strings.EqualFold(k, key) {
+			return k, true
+		}
+	}
+	return "", false
+}
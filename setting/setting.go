@@ -21,71 +21,248 @@
 //   Apollo... / Apollo 那样的配置中心
 //
 // 每一个源, 我都提供了一个接口. 只要 confObj 实现这个接口, 该 confObj 将被视为可以通过相应的源的方式来获取赋值
-// 一个 confObj 可以实现多个接口, 而源的优先级见上
+// 其他的源 (比如 etcd) 可以实现 Source 接口, 通过 RegisterSource 注册, 见 sources.go
+// 一个 confObj 可以实现多个接口, 而源的优先级见上, 各个源的值会合并, 高优先级的源覆盖低优先级的源提供的 key
+// 每个值最终来自哪个源, 可以通过 Explain 查看, 见 explain.go
+// 也可以不实现接口, 通过泛型的 Load[T] 和 Option 指定源, 见 load.go
+//
+// 不兼容的变化:
+//   Init 之前只使用第一个成功的源 (实际上只有配置文件), 现在依次使用所有实现了的源, 任何一个源出错都返回 error
+//   FromOsArgs, FromOsEnvs, FromApollo 之前是没有方法的标记接口, 现在需要实现同名的方法, 给出参数名前缀,
+//   环境变量名前缀和 namespace; 只实现了旧的标记接口的 confObj 不再被视为使用这些源
 //
 // 一些源的功能实现, 使用了 reflect , 所以建议该包的使用场景位于 所在包的 init() 中
 package setting
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
+	"bytes"
+	"context"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"qing/go-helper/error"
 	"reflect"
-	"strings"
 )
 
-func Init(v interface{}) (err error) {
-	defer func() {
-		if err != nil {
-			return
-		}
-		if needCheck, ok := v.(CanChecked); ok {
-			if err = needCheck.Access(); err != nil {
-				err = errPkg.FailBy(err, "do check by Access() fail.", nil)
-			}
+// 按优先级从低到高依次从各个源赋值: default tag < 配置中心 < 环境变量 < 配置文件 < 运行参数, 自定义的源见 RegisterSource
+// 每个源只覆盖它提供了的 key, 成功后可以通过 Explain 查看每个值的来源
+// 多个 confObj 共用同一个配置文件时, 建议 Register 之后通过 LoadAll 一起加载, 见 registry.go
+func Init(v interface{}) error {
+	return InitContext(context.Background(), v)
+}
+
+// 同 Init, ctx 超时或者被取消时返回 error; 每个源还受自己的超时限制 (见 SetDefaultTimeout), 超时的源会被跳过, 见 timeout.go
+// 再次 Init 同一个 v 时, 配置的变化会写入审计日志, 见 SetAuditFile
+// 所有的源都在 v 的拷贝上绑定, 全部成功并且校验 (Validate, CanChecked) 通过后才写回 v, 失败时 v 保持不变, 见 onCopy
+func InitContext(ctx context.Context, v interface{}) error {
+	var old []leafSnapshot
+	_, reinit := provenances.Load(v)
+	if reinit {
+		old = snapshotLeaves(v)
+	}
+	prov, err := initWith(ctx, v, planOf(v), newLoadSession())
+	if err != nil {
+		return err
+	}
+	recordProvenance(v, prov)
+	if reinit {
+		changesOf(v, old)
+	}
+	return nil
+}
+
+// confObj 从哪些源加载, 通常由它实现的接口 (FromFile 等) 决定, 见 planOf; Load 通过 Option 指定, 见 load.go
+type loadPlan struct {
+	fromCenter bool
+	namespace  string
+	fromEnvs   bool
+	envPrefix  string
+	fromFile   bool
+	path       string
+	sections   []string
+	fromArgs   bool
+	argsPrefix string
+	strict     bool
+	// 不为 nil 时只使用其中的源, 见 WithSources
+	only map[OriginKind]bool
+}
+
+func planOf(v interface{}) loadPlan {
+	plan := loadPlan{strict: isStrict(v)}
+	if confObj, ok := v.(FromApollo); ok {
+		plan.fromCenter, plan.namespace = true, confObj.FromApollo()
+	}
+	if confObj, ok := v.(FromOsEnvs); ok {
+		plan.fromEnvs, plan.envPrefix = true, confObj.FromOsEnvs()
+	}
+	if confObj, ok := v.(FromFile); ok {
+		plan.fromFile = true
+		plan.path, plan.sections = confObj.FromFile()
+	}
+	if confObj, ok := v.(FromOsArgs); ok {
+		plan.fromArgs, plan.argsPrefix = true, confObj.FromOsArgs()
+	}
+	return plan
+}
+
+// 严格模式下检查不认识的 key 时使用的类型, 见 strict.go
+func (plan loadPlan) strictType(v interface{}) reflect.Type {
+	if !plan.strict {
+		return nil
+	}
+	return reflect.TypeOf(v)
+}
+
+// 没有被 WithSources 排除的源
+func (plan loadPlan) enabled(src Source) bool {
+	return plan.only == nil || plan.only[sourceKind(src)]
+}
+
+// 解析过的配置文件和配置中心的 namespace 缓存在 session 中, 可以被多个 confObj 共用
+func initWith(ctx context.Context, v interface{}, plan loadPlan, session *loadSession) (*provenance, error) {
+	var prov *provenance
+	err := onCopy(v, func(fresh interface{}) (err error) {
+		prov, err = loadInto(ctx, fresh, plan, session)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prov, nil
+}
+
+// 在 v 的拷贝上执行 fn, 成功后才一次性写回 v; fn 失败时 v (包括它的 default 值) 保持不变
+// 所以任何源在任何位置解析或者绑定失败, 以及校验失败, 都不会留下改了一半的 confObj
+// v 不是非 nil 指针时直接在 v 上执行, 由 fn 报告错误
+func onCopy(v interface{}, fn func(fresh interface{}) error) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fn(v)
+	}
+	fresh := cloneValue(rv)
+	if err := fn(fresh.Interface()); err != nil {
+		return err
+	}
+	rv.Elem().Set(fresh.Elem())
+	return nil
+}
+
+// 依次从各个源加载到 v, 再校验; v 是 initWith 中的拷贝, 出错时会被丢弃
+func loadInto(ctx context.Context, v interface{}, plan loadPlan, session *loadSession) (*provenance, error) {
+	prov := newProvenance()
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		if err := applyDefaults(rv); err != nil {
+			return nil, err
 		}
-	}()
+	}
 
-	notImp := errors.New("not impl")
 	var unmarshalErr *errPkg.Err
-	wrapUnmarshal := func(source string, optErr error) bool {
+	wrapUnmarshal := func(source string, optErr error) {
 		if optErr == nil {
-			unmarshalErr = nil
-			return true
+			return
 		}
 		if unmarshalErr == nil {
 			unmarshalErr = errPkg.Fail("do unmarshal fail.", nil)
 		}
-		unmarshalErr.SetField(source, source)
-		return false
+		unmarshalErr.SetField(source, optErr)
+	}
+
+	ctx = withLoadState(ctx, plan, session)
+	for _, src := range currentSources() {
+		if !plan.enabled(src) {
+			continue
+		}
+		if p, ok := src.(plannedSource); ok && !p.planned(plan) {
+			continue
+		}
+		tree, skipped, err := loadSource(ctx, src, v)
+		if ctx.Err() != nil {
+			return nil, errPkg.FailBy(ctx.Err(), "init canceled.", errPkg.Fields{"source": src.Name()})
+		}
+		if skipped {
+			prov.skipped = append(prov.skipped, SkippedSource{Source: src.Name(), Reason: err.Error()})
+			continue
+		}
+		if err == nil {
+			err = initFromSource(src, tree, v, plan, prov)
+		}
+		wrapUnmarshal("from "+src.Name(), err)
 	}
 
-	// cry :(: connot fallthrough in type switch...
-	if _, ok := v.(FromOsArgs); ok && wrapUnmarshal("from os-args", notImp) {
-		return unmarshalErr
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
 	}
 
-	if confObj, ok := v.(FromFile); ok && wrapUnmarshal("from file", InitFromFile(confObj)) {
-		return unmarshalErr
+	if err := Validate(v); err != nil {
+		return nil, err
+	}
+	if needCheck, ok := v.(CanChecked); ok {
+		if err := needCheck.Access(); err != nil {
+			return nil, errPkg.FailBy(err, "do check by Access() fail.", nil)
+		}
 	}
+	return prov, nil
+}
+
+// 从运行参数拉取配置, 参数的格式见 loadArgsTree
+// WARN 注意每种 soruce 的实现, 出错不能改变 v 默认值
+type FromOsArgs interface {
 
-	if _, ok := v.(FromOsEnvs); ok && wrapUnmarshal("from os-envs", notImp) {
-		return unmarshalErr
+	// prefix: 参数名的前缀, 比如 "db", 那么 --db.url=xxx 对应 url 字段; 为空时参数名就是 key 路径
+	FromOsArgs() (prefix string)
+}
+
+// 将 src 加载的 tree 绑定到 v
+func initFromSource(src Source, tree *SourceTree, v interface{}, plan loadPlan, prov *provenance) (err error) {
+	if tree == nil || tree.Node == nil {
+		return nil
 	}
 
-	if _, ok := v.(FromApollo); ok && wrapUnmarshal("from apollo", notImp) {
-		return unmarshalErr
+	origin := func(segs []interface{}) Origin {
+		return Origin{Kind: OriginKind(src.Name())}
+	}
+	if tree.Origin != nil {
+		origin = tree.Origin
+	}
+	if _, ok := src.(selfStrictSource); !ok && plan.strict {
+		unknown := findUnknownKeys(tree.Node, reflect.TypeOf(v), nil, func(segs []interface{}) string {
+			return origin(segs).String()
+		})
+		if err = unknownKeysFail(unknown); err != nil {
+			return errPkg.FailBy(err, "source has unknown keys in strict mode.", errPkg.Fields{"source": src.Name()})
+		}
 	}
 
+	if err = bindTree(tree.Node, v); err != nil {
+		return bindSourceFail(err, tree, origin, "unmarshal source's config to confObj fail.", errPkg.Fields{"source": src.Name()})
+	}
+	prov.addSource(tree, origin)
 	return nil
 }
 
-// TODO 实现从运行参数中拉取配置, 参考或使用 github.com/jessevdk/go-flags (https://github.com/jessevdk/go-flags)
-// WARN 注意每种 soruce 的实现, 出错不能改变 v 默认值
-type FromOsArgs interface {
+// 运行参数, 见 FromOsArgs
+type argsSource struct{}
+
+func (argsSource) Name() string     { return "os-args" }
+func (argsSource) Priority() int    { return PriorityArgs }
+func (argsSource) kind() OriginKind { return OriginFlag }
+
+// 运行参数不检查严格模式, 不认识的参数可能属于程序自己的 flag
+func (argsSource) selfStrict()                {}
+func (argsSource) planned(plan loadPlan) bool { return plan.fromArgs }
+
+func (argsSource) Load(ctx context.Context, v interface{}) (*SourceTree, error) {
+	plan, _ := loadStateOf(ctx, v)
+	if !plan.fromArgs {
+		return nil, nil
+	}
+	tree, names := loadArgsTree(plan.argsPrefix, reflect.TypeOf(v), os.Args[1:])
+	if len(tree) == 0 {
+		return nil, nil
+	}
+	return &SourceTree{Node: tree, Origin: func(segs []interface{}) Origin {
+		return Origin{Kind: OriginFlag, Name: names[formatKeyPath(segs)]}
+	}}, nil
 }
 
 // 从配置文件拉取配置
@@ -93,8 +270,15 @@ type FromOsArgs interface {
 type FromFile interface {
 
 	// path: 配置文件路径
-	//   将会根据文件的扩展名来判断解析的方法, 目前支持 json
+	//   将会根据文件的扩展名来判断解析的方法, 内置支持 .json, .toml, .ini, .properties, .env (见 dotenv.go), 可以通过 RegisterFormat 扩展
+	//   没有扩展名或者扩展名有歧义 (如 .conf) 时, 根据文件内容探测格式
+	//   配置文件可以 include 其他文件, 并且会自动合并 conf.<profile>.json 和 conf.local.json, 见 layer.go
+	//   相对路径会在工作目录, 可执行文件所在目录等位置查找, 见 search.go
+	//   ini 的 [section] (嵌套用 [x.y.z]) 和 properties 的 x.y.key 都对应下面的 sections
+	//   默认忽略不认识的 key, 严格模式下报错, 见 strict.go
 	// sections: 配置节点名
+	//   可以是任意 key (比如 "db-primary"), 也可以是 key 路径 (比如 "services.db[0]"), 见 section.go
+	//   key 中包含 . 或 [ 时写成带引号的字符串 (比如 `"a.b"`), 作为一个 key
 	//   当多个 struct 配置的 path 相同, 那么建议为每个 struct 配置一个 section
 	//   例如:
 	//   package log
@@ -127,101 +311,287 @@ type FromFile interface {
 	FromFile() (path string, sections []string)
 }
 
+// 同 Init 一样, 失败时 v 保持不变
 func InitFromFile(v FromFile) error {
-	path, sections := v.FromFile()
-
-	if len(sections) == 0 {
-		return initFromFile(path, v)
+	prov := newProvenance()
+	plan := planOf(v)
+	err := onCopy(v, func(fresh interface{}) error {
+		if err := applyDefaults(reflect.ValueOf(fresh)); err != nil {
+			return err
+		}
+		return bindFile(newLoadSession(), plan.path, plan.sections, fresh, plan.strictType(v), prov)
+	})
+	if err != nil {
+		return err
 	}
+	recordProvenance(v, prov)
+	return nil
+}
 
-	curSection := sections[len(sections)-1]
-	trueVType := reflect.StructOf([]reflect.StructField{
-		{
-			Name: strings.ToUpper(curSection),
-			Type: reflect.TypeOf(v).Elem(),
-			Tag:  reflect.StructTag(fmt.Sprintf(`json:"%s"`, sections[len(sections)-1])),
-		},
+func initFromFile(path string, v interface{}) error {
+	return onCopy(v, func(fresh interface{}) error {
+		return bindFile(newLoadSession(), path, nil, fresh, nil, nil)
 	})
-	for i := len(sections) - 1; i > 0; i-- {
-		curSection = sections[i-1]
-		trueVType = reflect.StructOf([]reflect.StructField{
-			{
-				Name: strings.ToUpper(curSection),
-				Type: trueVType,
-				Tag:  reflect.StructTag(fmt.Sprintf(`json:"%s"`, curSection)),
-			},
-		})
+}
+
+// 配置文件, 见 FromFile
+type fileSource struct{}
+
+func (fileSource) Name() string               { return "file" }
+func (fileSource) Priority() int              { return PriorityFile }
+func (fileSource) kind() OriginKind           { return OriginFile }
+func (fileSource) selfStrict()                {}
+func (fileSource) planned(plan loadPlan) bool { return plan.fromFile }
+
+func (fileSource) Load(ctx context.Context, v interface{}) (*SourceTree, error) {
+	plan, session := loadStateOf(ctx, v)
+	if !plan.fromFile {
+		return nil, nil
 	}
+	return loadFileSource(session, plan.path, plan.sections, plan.strictType(v), reflect.TypeOf(v))
+}
 
-	trueV := reflect.New(trueVType)
-	if err := initFromFile(path, trueV.Interface()); err != nil {
+// 监听配置文件, 以及它 include 的文件和 profile 层的文件
+func (fileSource) Watch(ctx context.Context, v interface{}, changed func()) error {
+	plan, _ := loadStateOf(ctx, v)
+	if !plan.fromFile {
+		return nil
+	}
+	files := watchedFiles(plan.path)
+	if err := watchFiles(ctx, files, changed); err != nil {
+		return errPkg.FailBy(err, "watch config file fail.", errPkg.Fields{"files": files})
+	}
+	return nil
+}
+
+// v 对应配置文件中 sections 指定的节点 (见 section.go), 文件通过 session 加载, 同一次加载中只解析一次
+// strictType 不为 nil 时, 每个文件中 sections 节点下 strictType 没有的 key 都会报错, 见 strict.go
+// prov 不为 nil 时记录每个 key 来自哪个文件的哪一行
+// 出错时 v 可能已经被修改了一部分, 调用者应该传入拷贝, 见 onCopy
+func bindFile(session *loadSession, path string, sections []string, v interface{}, strictType reflect.Type, prov *provenance) error {
+	tree, err := loadFileSource(session, path, sections, strictType, reflect.TypeOf(v))
+	if err != nil || tree == nil {
 		return err
 	}
+	if err = bindTree(tree.Node, v); err != nil {
+		origin := func(segs []interface{}) Origin {
+			return Origin{Kind: OriginFile, Name: path}
+		}
+		return bindSourceFail(err, tree, origin, "unmarshal config file's content bytes to confObj fail.",
+			errPkg.Fields{"file": path})
+	}
+	if prov != nil {
+		prov.addSource(tree, nil)
+	}
+	return nil
+}
 
-	vVal := trueV.Elem()
-	for range sections {
-		vVal = vVal.Field(0)
+// 合并所有的层, 返回 sections 对应的节点; 文件中没有该节点时返回 nil, 保留 confObj 原来的值
+// confType 是 confObj 的类型, 用于把 .env 的变量名转换成 key 路径 (见 envLayer), 可以为 nil
+func loadFileSource(session *loadSession, path string, sections []string, strictType, confType reflect.Type) (*SourceTree, error) {
+	prefix, err := sectionPath(sections)
+	if err != nil {
+		return nil, errPkg.FailBy(err, "resolve sections fail.", errPkg.Fields{"file": path})
 	}
+	layers, err := session.fileLayers(path)
+	if err != nil {
+		return nil, err
+	}
+	// session 中的层是共享的, 转换后的层是新的
+	converted := make([]fileLayer, len(layers))
+	for i, layer := range layers {
+		if converted[i] = layer; layer.envNames {
+			converted[i] = envLayer(layer, prefix, confType)
+		}
+	}
+	layers = converted
 
-	var setErr error
-	defer func() {
-		if panicO := recover(); panicO != nil {
-			setErr = errPkg.Fail("using reflect do set fail.", errPkg.Fields{"panic": fmt.Sprint(panicO)})
+	if strictType != nil {
+		unknown := make([]unknownKey, 0)
+		for _, layer := range layers {
+			layer := layer
+			node, segs, ok := lookupSection(layer.tree, prefix)
+			if !ok {
+				continue
+			}
+			unknown = append(unknown, findUnknownKeys(node, strictType, segs, func(segs []interface{}) string {
+				pos := layer.positions[formatKeyPath(segs)]
+				pos.File = layer.file
+				return pos.String()
+			})...)
 		}
-	}()
-	reflect.ValueOf(v).Elem().Set(vVal)
-	return setErr
-}
+		if err = unknownKeysFail(unknown); err != nil {
+			return nil, errPkg.FailBy(err, "config file has unknown keys in strict mode.", errPkg.Fields{"file": path})
+		}
+	}
+	tree := mergeLayers(layers, currentArrayPolicy())
 
-func initFromFile(path string, v interface{}) error {
-	// 不同格式的文件解析成对象的方法
-	// TODO X support more file type, like YAML, XML...
-	type fromFile func(f *os.File, v interface{}) error
-	fromFileRecords := map[string]fromFile{
-		".json": func(f *os.File, v interface{}) error {
-			return json.NewDecoder(f).Decode(v)
-		},
+	if err = interpolate(tree); err != nil {
+		return nil, errPkg.FailBy(err, "interpolate config file fail.", errPkg.Fields{"file": path})
 	}
 
-	parse := fromFileRecords[filepath.Ext(path)]
-	if parse == nil {
-		return errPkg.Fail("file format is not supported.", errPkg.Fields{
-			"file": path,
-			"supported extensions": func() []string {
-				exts := make([]string, 0)
-				for k, _ := range fromFileRecords {
-					exts = append(exts, k)
-				}
-				return exts
-			}(),
-		})
+	node, resolved, ok := lookupSection(tree, prefix)
+	if !ok {
+		return nil, nil
+	}
+	result := &SourceTree{Node: node, prefix: resolved}
+	for _, layer := range layers {
+		layer := layer
+		node, segs, ok := lookupSection(layer.tree, prefix)
+		if !ok {
+			continue
+		}
+		result.Layers = append(result.Layers, &SourceTree{Node: node, Origin: func(sub []interface{}) Origin {
+			full := append(append([]interface{}{}, segs...), sub...)
+			pos, ok := layer.positions[formatKeyPath(full)]
+			if !ok {
+				pos = layer.positions[formatKeyPath(appendSeg(full, 0))]
+			}
+			return Origin{Kind: OriginFile, Name: layer.file, Line: pos.Line, Column: pos.Column}
+		}})
 	}
+	return result, nil
+}
+
+// 根据扩展名 (见 RegisterFormat) 选择 Decoder, 将配置文件解析成通用的树
+// 没有扩展名或者扩展名有歧义的文件, 根据内容探测格式
+// Decoder 实现了 PositionDecoder 时, 同时返回每个 key 在文件中的位置; 返回的 bool 表示 key 是环境变量名 (.env)
+// TODO X support more file type, like YAML, XML...
+func readFileTree(path string) (map[string]interface{}, map[string]Position, bool, error) {
+	if err := checkFormat(path); err != nil {
+		return nil, nil, false, err
+	}
+	decoder := lookupFormat(filepath.Ext(path))
 
 	f, err := os.Open(path)
 	defer f.Close()
 	if err != nil {
-		return errPkg.FailBy(err, "open config file fail.", errPkg.Fields{"file": path})
+		return nil, nil, false, errPkg.FailBy(err, "open config file fail.", errPkg.Fields{"file": path})
 	}
 
-	if err = parse(f, v); err != nil {
-		return errPkg.FailBy(err, "unmarshal config file's content bytes to confObj fail.",
-			errPkg.Fields{"file": path})
+	// 读出全部内容, 出错时用于探测格式和显示出错的那一行
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, nil, false, errPkg.FailBy(err, "read config file fail.", errPkg.Fields{"file": path})
+	}
+	if decoder == nil {
+		if decoder, _ = sniffFormat(content); decoder == nil {
+			return nil, nil, false, errPkg.Fail("detect file format by content fail.", errPkg.Fields{
+				"file":                 path,
+				"supported extensions": supportedExts(),
+			})
+		}
+	}
+
+	var tree map[string]interface{}
+	positions := make(map[string]Position)
+	if pd, ok := decoder.(PositionDecoder); ok {
+		tree, positions, err = pd.DecodePositions(bytes.NewReader(content))
+	} else {
+		tree, err = decoder.Decode(bytes.NewReader(content))
 	}
+	if err != nil {
+		return nil, nil, false, decodeFail(err, path, content)
+	}
+	for key, pos := range positions {
+		pos.File = path
+		positions[key] = pos
+	}
+	_, envNames := decoder.(envNamesDecoder)
+	return tree, positions, envNames, nil
+}
 
+func checkFormat(path string) error {
+	ext := filepath.Ext(path)
+	if lookupFormat(ext) == nil && !ambiguousExts[normalizeExt(ext)] {
+		return errPkg.Fail("file format is not supported.", errPkg.Fields{
+			"file":                 path,
+			"supported extensions": supportedExts(),
+		})
+	}
 	return nil
 }
 
-// TODO 实现从环境变量中拉取配置
+// 从环境变量拉取配置, 环境变量名见 envName
 // WARN 注意每种 soruce 的实现, 出错不能改变 v 默认值
 type FromOsEnvs interface {
+
+	// prefix: 环境变量名的前缀, 比如 "APP", 那么 APP_DB_URL 对应 db.url 字段
+	FromOsEnvs() (prefix string)
+}
+
+// 环境变量, 见 FromOsEnvs
+type envSource struct{}
+
+func (envSource) Name() string               { return "os-envs" }
+func (envSource) Priority() int              { return PriorityEnv }
+func (envSource) kind() OriginKind           { return OriginEnv }
+func (envSource) selfStrict()                {}
+func (envSource) planned(plan loadPlan) bool { return plan.fromEnvs }
+
+func (envSource) Load(ctx context.Context, v interface{}) (*SourceTree, error) {
+	plan, _ := loadStateOf(ctx, v)
+	if !plan.fromEnvs {
+		return nil, nil
+	}
+	prefix := plan.envPrefix
+	if strictType := plan.strictType(v); strictType != nil {
+		if err := unknownKeysFail(unknownEnvs(prefix, strictType)); err != nil {
+			return nil, errPkg.FailBy(err, "os envs have unknown keys in strict mode.", errPkg.Fields{"prefix": prefix})
+		}
+	}
+	tree, names := loadEnvTree(prefix, reflect.TypeOf(v))
+	if len(tree) == 0 {
+		return nil, nil
+	}
+	return &SourceTree{Node: tree, Origin: func(segs []interface{}) Origin {
+		return Origin{Kind: OriginEnv, Name: names[formatKeyPath(segs)]}
+	}}, nil
 }
 
-// TODO 实现从 Apollo 拉取配置
+// 从 Apollo 这样的配置中心拉取配置, 客户端通过 SetConfigCenter 注入
 // WARN 注意每种 soruce 的实现, 出错不能改变 v 默认值
 type FromApollo interface {
+
+	// namespace: 配置中心的 namespace, 其中的配置结构同配置文件
+	FromApollo() (namespace string)
+}
+
+// 配置中心, 见 FromApollo
+type centerSource struct{}
+
+func (centerSource) Name() string               { return "apollo" }
+func (centerSource) Priority() int              { return PriorityConfigCenter }
+func (centerSource) kind() OriginKind           { return OriginConfigCenter }
+func (centerSource) selfStrict()                {}
+func (centerSource) planned(plan loadPlan) bool { return plan.fromCenter }
+
+func (centerSource) Load(ctx context.Context, v interface{}) (*SourceTree, error) {
+	plan, session := loadStateOf(ctx, v)
+	if !plan.fromCenter {
+		return nil, nil
+	}
+	namespace := plan.namespace
+	tree, err := session.centerTree(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if strictType := plan.strictType(v); strictType != nil {
+		unknown := findUnknownKeys(tree, strictType, nil, func([]interface{}) string {
+			return "namespace " + namespace
+		})
+		if err = unknownKeysFail(unknown); err != nil {
+			return nil, errPkg.FailBy(err, "config center has unknown keys in strict mode.", errPkg.Fields{"namespace": namespace})
+		}
+	}
+	return &SourceTree{Node: tree, Origin: func([]interface{}) Origin {
+		return Origin{Kind: OriginConfigCenter, Name: namespace}
+	}}, nil
 }
 
 // confObj 实现该接口表示希望被校验, 具体校验逻辑在 Access() 中, 当然一些默认值的设置也可以在该方法中
+// 简单的默认值建议使用 default tag, 见 defaults.go; 常见的校验建议使用 validate tag, 见 validate.go
+// Access() 在 validate tag 的规则都通过之后执行
 type CanChecked interface {
 	Access() error
 }
@@ -3,10 +3,11 @@ package setting
 import (
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
 	"qing/go-helper/error"
 	testingX "qing/go-helper/testing"
 	"testing"
-	"encoding/json"
+	"strconv"
 	"strings"
 )
 
@@ -16,7 +17,7 @@ func Test_initFromFile(t *testing.T) {
 	err := initFromFile(path, nil)
 	wantedErr := errPkg.Fail("file format is not supported.", errPkg.Fields{
 		"file": path,
-		"supported extensions": []string{".json"},
+		"supported extensions": []string{".env", ".ini", ".json", ".properties", ".toml"},
 	})
 	assert.Equal(t, testingX.IgnoreCreatedAt(wantedErr), testingX.IgnoreCreatedAt(err),
 		"situation 1: not support config file format")
@@ -25,8 +26,9 @@ func Test_initFromFile(t *testing.T) {
 	path = "not-exist.json"
 	err = initFromFile(path, nil)
 	wantedErr = func(file string) *errPkg.Err {
-		_, err := os.Open(file)
-		return errPkg.FailBy(err, "open config file fail.", errPkg.Fields{"file": file})
+		tried := SearchPaths(file)
+		_, err := os.Open(tried[0])
+		return errPkg.FailBy(err, "open config file fail.", errPkg.Fields{"file": file, "tried": tried})
 	}(path)
 	assert.Equal(t, testingX.IgnoreCreatedAt(wantedErr), testingX.IgnoreCreatedAt(err),
 		"situaton 2: open file fail")
@@ -38,11 +40,24 @@ func Test_initFromFile(t *testing.T) {
 	f := testingX.MockFile(path, mockJson)
 	err = initFromFile(path, &v1)
 	wantedErr = func(jsonStr string) *errPkg.Err {
-		//err := json.Unmarshal([]byte(jsonStr), &v1)
-		err := json.NewDecoder(strings.NewReader(jsonStr)).Decode(&v1)
-		return errPkg.FailBy(err, "unmarshal config file's content bytes to confObj fail.",
-			errPkg.Fields{"file": path})
+		tree, _ := parseJSON(strings.NewReader(jsonStr))
+		err := bindTree(tree, &v1)
+		// 出错的是根节点, 位置是文件的开始
+		file, _ := filepath.Abs(path)
+		return errPkg.FailBy(testingX.IgnoreCreatedAt(err), "unmarshal config file's content bytes to confObj fail.",
+			errPkg.Fields{
+				"file":     file,
+				"line":     1,
+				"column":   1,
+				"key":      "",
+				"expected": "[]string",
+				"found":    `map[string]interface {}{"omg":true, "size":10}`,
+				"snippet":  "1 | " + jsonStr + "\n  | ^",
+			})
 	}(mockJson)
+	if known, ok := err.(*errPkg.Err); ok {
+		known.Cause = testingX.IgnoreCreatedAt(known.Cause)
+	}
 	assert.Equal(t, testingX.IgnoreCreatedAt(wantedErr), testingX.IgnoreCreatedAt(err),
 		"situation 3: unmarshal fail")
 	f.Remove()
@@ -88,3 +103,81 @@ type OneConf struct {
 func (conf OneConf) FromFile() (string, []string) {
 	return "conf.json", []string{"x", "y", "z"}
 }
+
+type sectionConf struct {
+	sections []string
+	URL      string `json:"url"`
+}
+
+func (conf *sectionConf) FromFile() (string, []string) {
+	return "sections.json", conf.sections
+}
+
+type sectionHosts []string
+
+func (hosts *sectionHosts) FromFile() (string, []string) {
+	return "sections.json", []string{"hosts"}
+}
+
+type sectionLimits map[string]int
+
+func (limits *sectionLimits) FromFile() (string, []string) {
+	return "sections.json", []string{"services", "limits"}
+}
+
+func Test_InitFromFile_sections(t *testing.T) {
+	f := testingX.MockFile("sections.json", `{
+		"db-primary": {"url": "a"},
+		"1x": {"url": "b"},
+		"services": {"db": [{"url": "c"}, {"url": "d"}], "limits": {"qps": 10}},
+		"hosts": ["h1", "h2"],
+		"a.b": {"url": "e"},
+		"a": {"b": {"url": "f"}},
+		"svc[1]": {"url": "g"}
+	}`)
+	defer f.Remove()
+
+	for sections, expected := range map[string]string{
+		"db-primary":     "a",
+		"1x":             "b",
+		"services.db[0]": "c",
+		"services.DB[1]": "d",
+	} {
+		conf := &sectionConf{sections: []string{sections}}
+		assert.Nil(t, InitFromFile(conf), sections)
+		assert.Equal(t, expected, conf.URL, sections)
+	}
+
+	conf := &sectionConf{sections: []string{"services", "db[1]"}}
+	assert.Nil(t, InitFromFile(conf))
+	assert.Equal(t, "d", conf.URL)
+
+	// 没有对应的节点时保留原来的值
+	conf = &sectionConf{sections: []string{"services.db[2]"}, URL: "default"}
+	assert.Nil(t, InitFromFile(conf))
+	assert.Equal(t, "default", conf.URL)
+
+	conf = &sectionConf{sections: []string{"services..db"}}
+	assert.NotNil(t, InitFromFile(conf))
+
+	// 带引号的 section 整体作为一个 key
+	for sections, expected := range map[string]string{
+		`"a.b"`:                 "e",
+		"a.b":                   "f",
+		strconv.Quote("svc[1]"): "g",
+	} {
+		conf := &sectionConf{sections: []string{sections}}
+		assert.Nil(t, InitFromFile(conf), sections)
+		assert.Equal(t, expected, conf.URL, sections)
+	}
+	conf = &sectionConf{sections: []string{`"a.b`}}
+	assert.NotNil(t, InitFromFile(conf))
+
+	hosts := new(sectionHosts)
+	assert.Nil(t, InitFromFile(hosts))
+	assert.Equal(t, sectionHosts{"h1", "h2"}, *hosts)
+
+	limits := new(sectionLimits)
+	assert.Nil(t, InitFromFile(limits))
+	assert.Equal(t, sectionLimits{"qps": 10}, *limits)
+}
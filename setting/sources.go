@@ -0,0 +1,374 @@
+package setting
+
+import (
+	"context"
+	"os"
+	"qing/go-helper/error"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// 配置源, 通过 RegisterSource 注册, Init 按 Priority 从低到高依次加载并合并, 高优先级的源覆盖低优先级的源提供的 key
+// 内置的源: 配置中心 (FromApollo), 环境变量 (FromOsEnvs), 配置文件 (FromFile), 运行参数 (FromOsArgs)
+// 自定义的源 (比如 etcd, Consul, HTTP) 通常也定义一个接口, 由 confObj 实现来表示使用该源:
+//
+//   type FromEtcd interface {
+//     FromEtcd() (key string)
+//   }
+//
+//   type etcdSource struct{ client *clientv3.Client }
+//
+//   func (s *etcdSource) Name() string  { return "etcd" }
+//   func (s *etcdSource) Priority() int { return setting.PriorityConfigCenter + 10 }
+//   func (s *etcdSource) Load(ctx context.Context, confObj interface{}) (*setting.SourceTree, error) {
+//     confObj, ok := confObj.(FromEtcd)
+//     if !ok {
+//       return nil, nil
+//     }
+//     ... // 读取 confObj.FromEtcd() 对应的值, 解析成 map[string]interface{}
+//   }
+//
+//   setting.RegisterSource(&etcdSource{client})
+//
+// WARN 注意每种 soruce 的实现, 出错不能改变 confObj: Load 只返回树, 由 Init 负责绑定
+type Source interface {
+	// 源的名称, 用于报错 (from <name>), 也是 Explain 中来源的 Kind, 见 WithSources
+	Name() string
+	// 内置的源见 PriorityConfigCenter 等
+	Priority() int
+	// 加载 confObj 的配置, 树从 confObj 的根节点开始, 结构同配置文件解析出来的树, 见 bindTree
+	// confObj 不使用该源, 或者该源中没有 confObj 的配置时, 返回 nil, nil
+	Load(ctx context.Context, confObj interface{}) (*SourceTree, error)
+}
+
+// 内置的源的优先级
+const (
+	PriorityConfigCenter = 100
+	PriorityEnv          = 200
+	PriorityFile         = 300
+	PriorityArgs         = 400
+)
+
+// Source 加载的结果
+type SourceTree struct {
+	Node interface{}
+	// Node 中每个 key (相对于 confObj 的路径) 的来源, 用于 Explain; 为 nil 时是 Origin{Kind: <Source 的名称>}
+	Origin func(segs []interface{}) Origin
+	// 可选, 源内部相互覆盖的层 (比如配置文件 include 的文件和 profile 层), 按覆盖的顺序, 用于 Explain 中的 Overridden
+	Layers []*SourceTree
+
+	// Node 在源中的路径, 比如配置文件的 sections, 用于错误信息中完整的 key
+	prefix []interface{}
+}
+
+// Source 可以选择实现该接口, 表示可以监听配置的变化, 见 Watch
+type WatchableSource interface {
+	Source
+	// 监听 confObj 对应的配置, 变化时调用 changed (可以连续调用, 会被合并); ctx 结束后停止监听
+	// 不应阻塞, 需要时自己启动 goroutine
+	Watch(ctx context.Context, confObj interface{}, changed func()) error
+}
+
+var (
+	sourcesMu sync.RWMutex
+	// 按优先级从低到高
+	sources []Source
+)
+
+func init() {
+	RegisterSource(centerSource{})
+	RegisterSource(envSource{})
+	RegisterSource(fileSource{})
+	RegisterSource(argsSource{})
+}
+
+// 注册配置源, 和已注册的源同名时替换它 (包括内置的源)
+func RegisterSource(src Source) {
+	if src == nil {
+		panic("setting: RegisterSource source is nil")
+	}
+
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	for i, s := range sources {
+		if s.Name() == src.Name() {
+			sources = append(sources[:i], sources[i+1:]...)
+			break
+		}
+	}
+	sources = append(sources, src)
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].Priority() < sources[j].Priority()
+	})
+}
+
+func currentSources() []Source {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	return append([]Source(nil), sources...)
+}
+
+// 内置的源对应的 OriginKind, 见 WithSources
+type kindSource interface {
+	kind() OriginKind
+}
+
+func sourceKind(src Source) OriginKind {
+	if k, ok := src.(kindSource); ok {
+		return k.kind()
+	}
+	return OriginKind(src.Name())
+}
+
+// 内置的源根据 loadPlan 判断 confObj 是否使用它, 不使用的源不会被加载, 也不会因为超时被跳过
+type plannedSource interface {
+	planned(plan loadPlan) bool
+}
+
+// 内置的源自己检查严格模式下不认识的 key, 其他的源由 Init 检查 Load 返回的树
+type selfStrictSource interface {
+	selfStrict()
+}
+
+// Init 通过 ctx 将加载方式 (见 loadPlan) 和缓存传给内置的源
+type loadStateKey struct{}
+
+type loadState struct {
+	plan    loadPlan
+	session *loadSession
+}
+
+func withLoadState(ctx context.Context, plan loadPlan, session *loadSession) context.Context {
+	return context.WithValue(ctx, loadStateKey{}, &loadState{plan: plan, session: session})
+}
+
+// 不是由 Init 调用时, 加载方式由 confObj 实现的接口决定
+func loadStateOf(ctx context.Context, confObj interface{}) (loadPlan, *loadSession) {
+	if state, ok := ctx.Value(loadStateKey{}).(*loadState); ok {
+		session := state.session
+		if session == nil {
+			session = newLoadSession()
+		}
+		return state.plan, session
+	}
+	return planOf(confObj), newLoadSession()
+}
+
+// 配置中心的客户端, 比如 Apollo, 由使用者通过 SetConfigCenter 注入
+type ConfigCenter interface {
+	// 拉取 namespace 下的配置, 返回的树和配置文件解析出来的树结构相同
+	Load(namespace string) (map[string]interface{}, error)
+}
+
+var (
+	configCenterMu sync.RWMutex
+	configCenter   ConfigCenter
+)
+
+func SetConfigCenter(center ConfigCenter) {
+	configCenterMu.Lock()
+	defer configCenterMu.Unlock()
+	configCenter = center
+}
+
+func currentConfigCenter() ConfigCenter {
+	configCenterMu.RLock()
+	defer configCenterMu.RUnlock()
+	return configCenter
+}
+
+func loadConfigCenterTree(namespace string) (map[string]interface{}, error) {
+	center := currentConfigCenter()
+	if center == nil {
+		return nil, errPkg.Fail("config center is not set, see SetConfigCenter.", errPkg.Fields{"namespace": namespace})
+	}
+	tree, err := center.Load(namespace)
+	if err != nil {
+		return nil, errPkg.FailBy(err, "load config from config center fail.", errPkg.Fields{"namespace": namespace})
+	}
+	return tree, nil
+}
+
+// confObj 中可以用一个字符串赋值的 key, 用于环境变量和运行参数
+type leafKey struct {
+	segs []string
+	typ  reflect.Type
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// 不再展开的类型: 非 struct, time.Time, 以及自己实现了反序列化的类型
+func isLeafType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return true
+	}
+	pt := reflect.PtrTo(t)
+	if pt.Implements(textUnmarshalerType) || pt.Implements(jsonUnmarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return false
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return isLeafType(t.Elem())
+	}
+	return true
+}
+
+// 数组和 map 的元素是 struct 时无法用一个字符串表示, 不包含在内
+func leafKeys(t reflect.Type) []leafKey {
+	result := make([]leafKey, 0)
+	collectLeafKeys(t, nil, &result, make(map[reflect.Type]bool))
+	return result
+}
+
+func collectLeafKeys(t reflect.Type, segs []string, result *[]leafKey, visiting map[reflect.Type]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if isLeafType(t) {
+		if len(segs) > 0 {
+			*result = append(*result, leafKey{segs: segs, typ: t})
+		}
+		return
+	}
+	if t.Kind() != reflect.Struct || visiting[t] {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for _, f := range structFields(t) {
+		collectLeafKeys(f.field.Type, append(append([]string{}, segs...), f.name), result, visiting)
+	}
+}
+
+// 环境变量名: 前缀和 key 路径的每一段转为大写下划线形式, 以 _ 连接
+// 比如 prefix "APP", key "db.maxIdle" -> APP_DB_MAX_IDLE
+func envName(prefix string, segs []string) string {
+	parts := make([]string, 0, len(segs)+1)
+	for _, seg := range append([]string{prefix}, segs...) {
+		if seg = envSegment(seg); seg != "" {
+			parts = append(parts, seg)
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+func envSegment(s string) string {
+	runes := []rune(s)
+	var bf strings.Builder
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			bf.WriteByte('_')
+			continue
+		}
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				bf.WriteByte('_')
+			}
+		}
+		bf.WriteRune(unicode.ToUpper(r))
+	}
+	parts := strings.FieldsFunc(bf.String(), func(r rune) bool { return r == '_' })
+	return strings.Join(parts, "_")
+}
+
+// 从环境变量构造一棵树, 同时返回每个 key 对应的环境变量名
+func loadEnvTree(prefix string, t reflect.Type) (map[string]interface{}, map[string]string) {
+	tree := make(map[string]interface{})
+	names := make(map[string]string)
+	for _, leaf := range leafKeys(t) {
+		name := envName(prefix, leaf.segs)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if setPath(tree, leaf.segs, defaultNode(value, leaf.typ)) == nil {
+			names[strings.Join(leaf.segs, ".")] = name
+		}
+	}
+	return tree, names
+}
+
+// 从运行参数构造一棵树, 同时返回每个 key 对应的参数名
+//   --db.url=xxx, --db.url xxx, -db.url xxx
+//   bool 类型的 key 可以省略值, --debug 等价于 --debug=true
+//   数组类型的 key 可以重复, --hosts a --hosts b
+//   prefix 不为空时参数名为 --<prefix>.<key>
+// 不认识的参数被忽略 (它们可能属于程序自己的 flag), -- 之后的参数不再解析
+func loadArgsTree(prefix string, t reflect.Type, args []string) (map[string]interface{}, map[string]string) {
+	leaves := make(map[string]leafKey)
+	for _, leaf := range leafKeys(t) {
+		name := strings.Join(leaf.segs, ".")
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		leaves[strings.ToLower(name)] = leaf
+	}
+
+	values := make(map[string][]string)
+	names := make(map[string]string)
+	order := make([]leafKey, 0)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		value, hasValue := "", false
+		if j := strings.IndexByte(name, '='); j >= 0 {
+			name, value, hasValue = name[:j], name[j+1:], true
+		}
+		leaf, ok := leaves[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		if !hasValue {
+			if leaf.typ.Kind() == reflect.Bool {
+				value = "true"
+			} else if i+1 < len(args) {
+				i++
+				value = args[i]
+			} else {
+				continue
+			}
+		}
+
+		key := strings.Join(leaf.segs, ".")
+		if _, ok := values[key]; !ok {
+			order = append(order, leaf)
+		}
+		values[key] = append(values[key], value)
+		names[key] = arg[:len(arg)-len(strings.TrimLeft(arg, "-"))] + name
+	}
+
+	tree := make(map[string]interface{})
+	for _, leaf := range order {
+		key := strings.Join(leaf.segs, ".")
+		var node interface{} = defaultNode(values[key][len(values[key])-1], leaf.typ)
+		if k := leaf.typ.Kind(); (k == reflect.Slice || k == reflect.Array) && len(values[key]) > 1 {
+			items := make([]interface{}, 0, len(values[key]))
+			for _, value := range values[key] {
+				items = append(items, value)
+			}
+			node = items
+		}
+		if setPath(tree, leaf.segs, node) != nil {
+			delete(names, key)
+		}
+	}
+	return tree, names
+}
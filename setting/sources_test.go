@@ -0,0 +1,214 @@
+package setting
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type sourcesConf struct {
+	MaxIdle int               `json:"maxIdle"`
+	HTTPURL string            `json:"HTTPURL"`
+	Debug   bool              `json:"debug"`
+	Hosts   []string          `json:"hosts"`
+	Labels  map[string]string `json:"labels"`
+	DB      struct {
+		URL string `json:"db-url"`
+	} `json:"db"`
+	Replicas []struct {
+		URL string `json:"url"`
+	} `json:"replicas"`
+}
+
+func Test_envName(t *testing.T) {
+	assert.Equal(t, "APP_MAX_IDLE", envName("app", []string{"maxIdle"}))
+	assert.Equal(t, "HTTPURL", envName("", []string{"HTTPURL"}))
+	assert.Equal(t, "APP_HTTP_PORT", envName("APP", []string{"HTTPPort"}))
+	assert.Equal(t, "APP_DB_DB_URL", envName("APP", []string{"db", "db-url"}))
+}
+
+func Test_loadEnvTree(t *testing.T) {
+	os.Setenv("SRC_MAX_IDLE", "10")
+	os.Setenv("SRC_LABELS", "a=1,b=2")
+	os.Setenv("SRC_DB_DB_URL", "mysql://")
+	defer os.Unsetenv("SRC_MAX_IDLE")
+	defer os.Unsetenv("SRC_LABELS")
+	defer os.Unsetenv("SRC_DB_DB_URL")
+
+	tree, names := loadEnvTree("SRC", reflect.TypeOf(sourcesConf{}))
+	assert.Equal(t, map[string]interface{}{
+		"maxIdle": "10",
+		"labels":  map[string]interface{}{"a": "1", "b": "2"},
+		"db":      map[string]interface{}{"db-url": "mysql://"},
+	}, tree)
+	assert.Equal(t, "SRC_DB_DB_URL", names["db.db-url"])
+
+	conf := new(sourcesConf)
+	assert.Nil(t, bindTree(tree, conf))
+	assert.Equal(t, 10, conf.MaxIdle)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, conf.Labels)
+}
+
+func Test_loadArgsTree(t *testing.T) {
+	tree, names := loadArgsTree("", reflect.TypeOf(sourcesConf{}), []string{
+		"-v", "--debug", "--maxidle=3", "--hosts", "a", "-hosts=b", "--db.db-url", "x", "--", "--debug=false",
+	})
+	assert.Equal(t, map[string]interface{}{
+		"debug":   "true",
+		"maxIdle": "3",
+		"hosts":   []interface{}{"a", "b"},
+		"db":      map[string]interface{}{"db-url": "x"},
+	}, tree)
+	assert.Equal(t, "--maxidle", names["maxIdle"])
+
+	tree, _ = loadArgsTree("src", reflect.TypeOf(sourcesConf{}), []string{"--debug", "--src.debug=false"})
+	assert.Equal(t, map[string]interface{}{"debug": "false"}, tree)
+}
+
+type centerFunc func(namespace string) (map[string]interface{}, error)
+
+func (f centerFunc) Load(namespace string) (map[string]interface{}, error) {
+	return f(namespace)
+}
+
+type centerConf struct {
+	URL string `json:"url"`
+}
+
+func (conf *centerConf) FromApollo() string {
+	return "application"
+}
+
+func Test_FromApollo(t *testing.T) {
+	assert.NotNil(t, Init(new(centerConf)), "config center is not set")
+
+	SetConfigCenter(centerFunc(func(namespace string) (map[string]interface{}, error) {
+		return map[string]interface{}{"url": namespace}, nil
+	}))
+	defer SetConfigCenter(nil)
+	conf := new(centerConf)
+	assert.Nil(t, Init(conf))
+	assert.Equal(t, "application", conf.URL)
+
+	e, _ := Explain(conf)
+	assert.Equal(t, Origin{Kind: OriginConfigCenter, Name: "application"}, e.Entries[0].Source)
+}
+
+type fromMemory interface {
+	FromMemory() (key string)
+}
+
+// 测试用的源, 值保存在内存中
+type memorySource struct {
+	mu      sync.Mutex
+	values  map[string]map[string]interface{}
+	changed []func()
+}
+
+func (s *memorySource) Name() string  { return "memory" }
+func (s *memorySource) Priority() int { return PriorityEnv + 1 }
+
+func (s *memorySource) Load(ctx context.Context, confObj interface{}) (*SourceTree, error) {
+	v, ok := confObj.(fromMemory)
+	if !ok {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tree, ok := s.values[v.FromMemory()]
+	if !ok {
+		return nil, nil
+	}
+	return &SourceTree{Node: cloneTree(tree)}, nil
+}
+
+func (s *memorySource) Watch(ctx context.Context, confObj interface{}, changed func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed = append(s.changed, changed)
+	return nil
+}
+
+func (s *memorySource) set(key string, tree map[string]interface{}) {
+	s.mu.Lock()
+	s.values[key] = tree
+	changed := append([]func(){}, s.changed...)
+	s.mu.Unlock()
+	for _, fn := range changed {
+		fn()
+	}
+}
+
+type memoryConf struct {
+	URL  string `json:"url"`
+	Port int    `json:"port"`
+}
+
+func (conf *memoryConf) FromMemory() string {
+	return "db"
+}
+
+func (conf *memoryConf) FromOsEnvs() string {
+	return "MEMORY"
+}
+
+func Test_RegisterSource(t *testing.T) {
+	src := &memorySource{values: map[string]map[string]interface{}{"db": {"url": "a", "port": int64(1)}}}
+	RegisterSource(src)
+	defer func() {
+		sourcesMu.Lock()
+		for i, s := range sources {
+			if s == Source(src) {
+				sources = append(sources[:i], sources[i+1:]...)
+				break
+			}
+		}
+		sourcesMu.Unlock()
+	}()
+
+	// 优先级高于环境变量
+	os.Setenv("MEMORY_URL", "env")
+	os.Setenv("MEMORY_PORT", "2")
+	defer os.Unsetenv("MEMORY_URL")
+	defer os.Unsetenv("MEMORY_PORT")
+	conf := new(memoryConf)
+	assert.Nil(t, Init(conf))
+	defer Forget(conf)
+	assert.Equal(t, &memoryConf{URL: "a", Port: 1}, conf)
+	e, _ := Explain(conf)
+	assert.Equal(t, Origin{Kind: "memory"}, e.Entries[0].Source)
+	assert.Equal(t, OriginEnv, e.Entries[0].Overridden[0].Source.Kind)
+
+	conf, err := Load[memoryConf](WithSources(OriginEnv))
+	assert.Nil(t, err)
+	defer Forget(conf)
+	assert.Equal(t, &memoryConf{URL: "env", Port: 2}, conf)
+
+	// 严格模式
+	src.set("db", map[string]interface{}{"url": "a", "prot": int64(1)})
+	_, err = Load[memoryConf](WithStrict(true))
+	assert.NotNil(t, err)
+
+	// 热加载
+	src.set("db", map[string]interface{}{"url": "a"})
+	value, err := LoadValue[memoryConf](&WatchOptions{Debounce: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer value.Close()
+	changes := make(chan *memoryConf, 1)
+	value.Subscribe(func(old, new *memoryConf) {
+		changes <- new
+	})
+	src.set("db", map[string]interface{}{"url": "b"})
+	select {
+	case change := <-changes:
+		assert.Equal(t, "b", change.URL)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no change notified")
+	}
+}
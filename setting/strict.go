@@ -0,0 +1,230 @@
+package setting
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 严格模式: 配置中出现 confObj 没有的 key 时报错, 而不是忽略, 避免拼写错误 (比如 "levle") 被默默地当作默认值
+//   配置文件 (所有格式) 和配置中心: confObj 对应的节点下不认识的 key
+//   环境变量: 以 <prefix>_ 开头, 但不对应任何字段的环境变量 (prefix 为空时不检查)
+// 运行参数不检查, 不认识的参数可能属于程序自己的 flag
+//
+// 报错时列出所有不认识的 key, 配置文件中的 key 带上文件名和行列, 并给出拼写最接近的字段作为建议
+//
+// 通过 SetStrict 全局开启, 或者 confObj 实现 Strict 接口单独开启 / 关闭 (优先于全局设置)
+type Strict interface {
+	Strict() bool
+}
+
+var (
+	strictMu sync.RWMutex
+	strict   bool
+)
+
+func SetStrict(on bool) {
+	strictMu.Lock()
+	defer strictMu.Unlock()
+	strict = on
+}
+
+func isStrict(v interface{}) bool {
+	if s, ok := v.(Strict); ok {
+		return s.Strict()
+	}
+	strictMu.RLock()
+	defer strictMu.RUnlock()
+	return strict
+}
+
+// 一个不认识的 key
+type unknownKey struct {
+	key string
+	// 来源, 比如 conf.json:3:5 或者 env APP_LEVLE
+	where      string
+	suggestion string
+}
+
+func (u unknownKey) reason() string {
+	reason := "unknown key"
+	if u.where != "" {
+		reason = u.where + ": " + reason
+	}
+	if u.suggestion != "" {
+		reason += fmt.Sprintf(", did you mean %q?", u.suggestion)
+	}
+	return reason
+}
+
+func unknownKeysFail(unknown []unknownKey) error {
+	if len(unknown) == 0 {
+		return nil
+	}
+	reasons := make(map[string]string, len(unknown))
+	for _, u := range unknown {
+		reasons[u.key] = u.reason()
+	}
+	return violationsFail("unknown keys in config.", reasons)
+}
+
+// 找出 node 中 t 没有的 key, segs 是 node 在树中的路径, where 返回 key 的来源
+func findUnknownKeys(node interface{}, t reflect.Type, segs []interface{}, where func(segs []interface{}) string) []unknownKey {
+	result := make([]unknownKey, 0)
+	collectUnknownKeys(node, t, segs, where, &result)
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].key < result[j].key
+	})
+	return result
+}
+
+func collectUnknownKeys(node interface{}, t reflect.Type, segs []interface{}, where func(segs []interface{}) string, result *[]unknownKey) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType || reflect.PtrTo(t).Implements(jsonUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields := structFields(t)
+		for k, child := range obj {
+			keySegs := appendSeg(segs, k)
+			f, ok := lookupStructField(fields, k)
+			if !ok {
+				names := make([]string, len(fields))
+				for i := range fields {
+					names[i] = fields[i].name
+				}
+				*result = append(*result, unknownKey{
+					key:        formatKeyPath(keySegs),
+					where:      where(keySegs),
+					suggestion: suggest(k, names),
+				})
+				continue
+			}
+			collectUnknownKeys(child, f.field.Type, keySegs, where, result)
+		}
+
+	case reflect.Map:
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for k, child := range obj {
+			collectUnknownKeys(child, t.Elem(), appendSeg(segs, k), where, result)
+		}
+
+	case reflect.Slice, reflect.Array:
+		items, ok := node.([]interface{})
+		if !ok {
+			return
+		}
+		for i, item := range items {
+			collectUnknownKeys(item, t.Elem(), appendSeg(segs, i), where, result)
+		}
+	}
+}
+
+// 和 fieldByKey 的匹配规则相同
+func lookupStructField(fields []structField, key string) (structField, bool) {
+	for _, f := range fields {
+		if f.name == key {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, key) {
+			return f, true
+		}
+	}
+	return structField{}, false
+}
+
+// 以 <prefix>_ 开头, 但不对应 t 的任何字段的环境变量
+func unknownEnvs(prefix string, t reflect.Type) []unknownKey {
+	result := make([]unknownKey, 0)
+	if prefix = envSegment(prefix); prefix == "" {
+		return result
+	}
+
+	known := make(map[string]bool)
+	names := make([]string, 0)
+	for _, leaf := range leafKeys(t) {
+		name := envName(prefix, leaf.segs)
+		known[name] = true
+		names = append(names, name)
+	}
+	for _, env := range os.Environ() {
+		name := strings.SplitN(env, "=", 2)[0]
+		if strings.HasPrefix(name, prefix+"_") && !known[name] {
+			result = append(result, unknownKey{key: name, where: "env", suggestion: suggest(name, names)})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].key < result[j].key
+	})
+	return result
+}
+
+// 编辑距离最小的候选, 距离太大 (超过 key 长度的一半, 最多 3) 时不建议
+func suggest(key string, candidates []string) string {
+	limit := len([]rune(key)) / 2
+	if limit > 3 {
+		limit = 3
+	}
+	if limit < 1 {
+		limit = 1
+	}
+
+	best, bestDistance := "", limit+1
+	for _, candidate := range candidates {
+		if d := editDistance(strings.ToLower(key), strings.ToLower(candidate)); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// Damerau-Levenshtein (optimal string alignment) 距离, 相邻字符交换算一次编辑, 比如 levle -> level
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func minInt(first int, rest ...int) int {
+	for _, n := range rest {
+		if n < first {
+			first = n
+		}
+	}
+	return first
+}
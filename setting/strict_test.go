@@ -0,0 +1,88 @@
+package setting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"qing/go-helper/error"
+	testingX "qing/go-helper/testing"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type strictConf struct {
+	Level string `json:"level"`
+	DB    struct {
+		URL string `json:"url"`
+	} `json:"db"`
+	Replicas []struct {
+		Port int `json:"port"`
+	} `json:"replicas"`
+}
+
+func (conf *strictConf) FromFile() (string, []string) {
+	return "strict.json", []string{"app"}
+}
+
+func (conf *strictConf) FromOsEnvs() string {
+	return "STRICT"
+}
+
+type looseConf struct {
+	strictConf
+}
+
+func (conf *looseConf) Strict() bool {
+	return false
+}
+
+func Test_Strict(t *testing.T) {
+	f := testingX.MockFile("strict.json", `{
+  "app": {
+    "levle": "DEBUG",
+    "db": {"url": "x", "ulr": "y"},
+    "replicas": [{"port": 1}, {"prot": 2}]
+  },
+  "other": {"anything": true}
+}`)
+	defer f.Remove()
+
+	assert.Nil(t, Init(new(strictConf)), "not strict by default")
+
+	SetStrict(true)
+	defer SetStrict(false)
+	conf := new(strictConf)
+	err := Init(conf)
+	if !assert.NotNil(t, err) {
+		return
+	}
+	assert.Equal(t, "", conf.Level, "confObj is not changed")
+
+	fileErr := err.(*errPkg.Err).Fields["from file"].(*errPkg.Err).Cause.(*errPkg.Err)
+	assert.Equal(t, []string{"app.db.ulr", "app.levle", "app.replicas[1].prot"}, fileErr.Fields["keys"])
+	file := ResolvedPaths()["strict.json"]
+	unknown := fileErr.Fields["violations"].(map[string]string)
+	assert.Equal(t, file+`:3:5: unknown key, did you mean "level"?`, unknown["app.levle"])
+	assert.True(t, strings.HasSuffix(unknown["app.db.ulr"], `did you mean "url"?`))
+	assert.True(t, strings.HasSuffix(unknown["app.replicas[1].prot"], `did you mean "port"?`))
+
+	assert.Nil(t, Init(new(looseConf)), "Strict() overrides the global setting")
+}
+
+func Test_unknownEnvs(t *testing.T) {
+	os.Setenv("STRICT_LEVLE", "x")
+	os.Setenv("STRICT_DB_URL", "x")
+	defer os.Unsetenv("STRICT_LEVLE")
+	defer os.Unsetenv("STRICT_DB_URL")
+
+	unknown := unknownEnvs("strict", reflect.TypeOf(new(strictConf)))
+	assert.Equal(t, []unknownKey{{key: "STRICT_LEVLE", where: "env", suggestion: "STRICT_LEVEL"}}, unknown)
+	assert.Equal(t, 0, len(unknownEnvs("", reflect.TypeOf(new(strictConf)))))
+}
+
+func Test_suggest(t *testing.T) {
+	assert.Equal(t, 1, editDistance("levle", "level"))
+	assert.Equal(t, 3, editDistance("kitten", "sitting"))
+	assert.Equal(t, "level", suggest("levle", []string{"dir", "level"}))
+	assert.Equal(t, "", suggest("timeout", []string{"dir", "level"}))
+}
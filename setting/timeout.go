@@ -0,0 +1,108 @@
+package setting
+
+import (
+	"context"
+	"errors"
+	"qing/go-helper/error"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// 加载配置的超时
+//   每个源的加载时间受自己的超时限制: 实现了 TimeoutSource 时使用它的 Timeout, 否则使用默认的超时, 见 SetDefaultTimeout
+//   某个源超时后被跳过, 继续加载优先级更低或者更高的源, 相当于沿着优先级链回退: 该源提供的 key 使用其他源的值或者默认值
+//   被跳过的源见 Skipped 和 Explain
+// InitContext 的 ctx 是整个加载的限制, 它超时或者被取消时 InitContext 返回 error, v 保持不变
+type TimeoutSource interface {
+	Source
+	// 小于等于 0 时使用默认的超时
+	Timeout() time.Duration
+}
+
+var (
+	timeoutMu      sync.RWMutex
+	defaultTimeout = 30 * time.Second
+)
+
+// 默认 30s, 小于等于 0 表示不限制
+func SetDefaultTimeout(timeout time.Duration) {
+	timeoutMu.Lock()
+	defer timeoutMu.Unlock()
+	defaultTimeout = timeout
+}
+
+func currentDefaultTimeout() time.Duration {
+	timeoutMu.RLock()
+	defer timeoutMu.RUnlock()
+	return defaultTimeout
+}
+
+// src 的超时: TimeoutSource 的 Timeout, 否则为默认的超时; 小于等于 0 表示只受 ctx 的限制
+func sourceTimeout(src Source) time.Duration {
+	if ts, ok := src.(TimeoutSource); ok && ts.Timeout() > 0 {
+		return ts.Timeout()
+	}
+	return currentDefaultTimeout()
+}
+
+// 被跳过的源
+type SkippedSource struct {
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+}
+
+// v 最近一次 Init 时被跳过的源
+func Skipped(v interface{}) []SkippedSource {
+	if prov, ok := provenances.Load(v); ok {
+		return append([]SkippedSource(nil), prov.(*provenance).skipped...)
+	}
+	return nil
+}
+
+// 在 ctx 和 src 的超时内执行 src.Load, src 超时时返回的 skipped 为 true; ctx 结束时返回 ctx.Err()
+// Load 在 v 的一份拷贝上执行, 超时后它仍在后台执行, 但不会再访问 v, 它的结果会被丢弃
+func loadSource(ctx context.Context, src Source, v interface{}) (tree *SourceTree, skipped bool, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	srcCtx, cancel := ctx, context.CancelFunc(func() {})
+	if timeout := sourceTimeout(src); timeout > 0 {
+		srcCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	scratch := v
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		scratch = cloneValue(rv).Interface()
+	}
+	type result struct {
+		tree *SourceTree
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		tree, err := src.Load(srcCtx, scratch)
+		done <- result{tree, err}
+	}()
+
+	select {
+	case r := <-done:
+		if ctx.Err() != nil {
+			return nil, false, ctx.Err()
+		}
+		if r.err != nil && isTimeout(r.err) {
+			return nil, true, r.err
+		}
+		return r.tree, false, r.err
+	case <-srcCtx.Done():
+		if ctx.Err() != nil {
+			return nil, false, ctx.Err()
+		}
+		return nil, true, srcCtx.Err()
+	}
+}
+
+func isTimeout(err error) bool {
+	return errors.Is(errPkg.GetCause(err), context.DeadlineExceeded)
+}
@@ -0,0 +1,147 @@
+package setting
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"qing/go-helper/error"
+	"testing"
+	"time"
+)
+
+type fromSlow interface {
+	FromSlow() bool
+}
+
+// 测试用的源, 直到 release 关闭才返回
+type slowSource struct {
+	release chan struct{}
+	timeout time.Duration
+	// 默认为 slow
+	name string
+}
+
+func (s *slowSource) Name() string {
+	if s.name == "" {
+		return "slow"
+	}
+	return s.name
+}
+
+func (s *slowSource) Priority() int          { return PriorityEnv + 1 }
+func (s *slowSource) Timeout() time.Duration { return s.timeout }
+
+func (s *slowSource) Load(ctx context.Context, confObj interface{}) (*SourceTree, error) {
+	if _, ok := confObj.(fromSlow); !ok {
+		return nil, nil
+	}
+	<-s.release
+	return &SourceTree{Node: map[string]interface{}{"url": "slow"}}, nil
+}
+
+type slowConf struct {
+	URL string `json:"url"`
+}
+
+func (conf *slowConf) FromSlow() bool {
+	return true
+}
+
+func (conf *slowConf) FromOsEnvs() string {
+	return "SLOW"
+}
+
+func Test_InitContext(t *testing.T) {
+	src := &slowSource{release: make(chan struct{})}
+	defer close(src.release)
+	RegisterSource(src)
+	defer func() {
+		sourcesMu.Lock()
+		for i, s := range sources {
+			if s == Source(src) {
+				sources = append(sources[:i], sources[i+1:]...)
+				break
+			}
+		}
+		sourcesMu.Unlock()
+	}()
+	os.Setenv("SLOW_URL", "env")
+	defer os.Unsetenv("SLOW_URL")
+
+	// 超时的源被跳过, 使用优先级更低的环境变量
+	src.timeout = 10 * time.Millisecond
+	conf := new(slowConf)
+	assert.Nil(t, InitContext(context.Background(), conf))
+	defer Forget(conf)
+	assert.Equal(t, "env", conf.URL)
+	skipped := Skipped(conf)
+	if assert.Equal(t, 1, len(skipped)) {
+		assert.Equal(t, "slow", skipped[0].Source)
+		assert.Equal(t, context.DeadlineExceeded.Error(), skipped[0].Reason)
+	}
+	e, _ := Explain(conf)
+	assert.Equal(t, skipped, e.Skipped)
+	assert.Equal(t, OriginEnv, e.Entries[0].Source.Kind)
+	src.timeout = 0
+
+	// 默认的超时, 每个源单独计算
+	SetDefaultTimeout(10 * time.Millisecond)
+	conf = new(slowConf)
+	assert.Nil(t, Init(conf))
+	defer Forget(conf)
+	assert.Equal(t, "env", conf.URL)
+	assert.Equal(t, 1, len(Skipped(conf)))
+	SetDefaultTimeout(30 * time.Second)
+
+	// ctx 超时或者被取消时返回 error, v 不变
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	conf = &slowConf{URL: "preset"}
+	err := InitContext(ctx, conf)
+	if assert.NotNil(t, err) {
+		assert.ErrorIs(t, errPkg.GetCause(err), context.DeadlineExceeded)
+	}
+	assert.Equal(t, "preset", conf.URL)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err = InitContext(ctx, new(slowConf))
+	if assert.NotNil(t, err) {
+		assert.ErrorIs(t, errPkg.GetCause(err), context.Canceled)
+	}
+}
+
+// 慢的源不会占用后面的源的时间
+func Test_InitContext_perSource(t *testing.T) {
+	first := &slowSource{release: make(chan struct{})}
+	defer close(first.release)
+	second := &slowSource{release: make(chan struct{}), name: "slow2"}
+	close(second.release)
+	RegisterSource(first)
+	RegisterSource(second)
+	defer func() {
+		sourcesMu.Lock()
+		kept := sources[:0]
+		for _, s := range sources {
+			if s != Source(first) && s != Source(second) {
+				kept = append(kept, s)
+			}
+		}
+		sources = kept
+		sourcesMu.Unlock()
+	}()
+
+	// 默认的超时在第一个源上用完之后, 第二个源仍然有自己的超时
+	SetDefaultTimeout(20 * time.Millisecond)
+	defer SetDefaultTimeout(30 * time.Second)
+	conf := new(slowConf)
+	assert.Nil(t, Init(conf))
+	defer Forget(conf)
+	assert.Equal(t, "slow", conf.URL)
+	if skipped := Skipped(conf); assert.Equal(t, 1, len(skipped)) {
+		assert.Equal(t, "slow", skipped[0].Source)
+	}
+}
@@ -0,0 +1,197 @@
+package types
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"qing/go-helper/setting"
+	"testing"
+	"time"
+)
+
+type typesConf struct {
+	Timeout  Duration `json:"timeout" default:"3s"`
+	MaxBody  ByteSize `json:"maxBody" default:"1MB" validate:"min=1KB,max=1GB"`
+	Sampling Percent  `json:"sampling" default:"10%" validate:"max=100%"`
+	Allow    []CIDR   `json:"allow"`
+	Listen   HostPort `json:"listen" default:":8080"`
+	Upstream URL      `json:"upstream"`
+	Path     Regexp   `json:"path"`
+	Zone     Location `json:"zone"`
+	Mode     FileMode `json:"mode" default:"0644"`
+}
+
+func checkTypesConf(t *testing.T, v *typesConf, msg string) {
+	assert.Equal(t, 5*time.Second, v.Timeout.Std(), msg)
+	assert.Equal(t, 10*MegaByte, v.MaxBody, msg)
+	assert.Equal(t, Percent(25), v.Sampling, msg)
+	if assert.Len(t, v.Allow, 2, msg) {
+		assert.Equal(t, "10.0.0.0/8", v.Allow[0].String(), msg)
+		assert.Equal(t, "192.168.1.1/32", v.Allow[1].String(), msg)
+	}
+	assert.Equal(t, HostPort{Host: "127.0.0.1", Port: 9090}, v.Listen, msg)
+	assert.Equal(t, "http://backend:8000/api", v.Upstream.String(), msg)
+	assert.True(t, v.Path.Regexp != nil && v.Path.MatchString("/v1/users"), msg)
+	assert.Equal(t, "UTC", v.Zone.String(), msg)
+	assert.Equal(t, FileMode(0600), v.Mode, msg)
+}
+
+var typesContents = map[string]string{
+	".json": `{"app": {
+  "timeout": "5s", "maxBody": "10MB", "sampling": "25%", "allow": ["10.0.0.0/8", "192.168.1.1"],
+  "listen": "127.0.0.1:9090", "upstream": "http://backend:8000/api", "path": "^/v[0-9]+/",
+  "zone": "UTC", "mode": "0600"
+}}`,
+	".toml": `[app]
+timeout = "5s"
+maxBody = "10MB"
+sampling = 25
+allow = ["10.0.0.0/8", "192.168.1.1"]
+listen = "127.0.0.1:9090"
+upstream = "http://backend:8000/api"
+path = "^/v[0-9]+/"
+zone = "UTC"
+mode = 600
+`,
+	".ini": `[app]
+timeout = 5s
+maxBody = 10MB
+sampling = 25%
+allow[] = 10.0.0.0/8
+allow[] = 192.168.1.1
+listen = 127.0.0.1:9090
+upstream = http://backend:8000/api
+path = ^/v[0-9]+/
+zone = UTC
+mode = 0600
+`,
+	".properties": `app.timeout=5s
+app.maxBody=10MB
+app.sampling=25%
+app.allow=10.0.0.0/8, 192.168.1.1
+app.listen=127.0.0.1:9090
+app.upstream=http://backend:8000/api
+app.path=^/v[0-9]+/
+app.zone=UTC
+app.mode=rw-------
+`,
+	".env": `APP_TIMEOUT=5s
+APP_MAX_BODY=10MB
+APP_SAMPLING=25%
+APP_ALLOW=10.0.0.0/8,192.168.1.1
+APP_LISTEN=127.0.0.1:9090
+APP_UPSTREAM=http://backend:8000/api
+APP_PATH='^/v[0-9]+/'
+APP_ZONE=UTC
+APP_MODE=0600
+`,
+}
+
+func TestTypes_files(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-types")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for ext, content := range typesContents {
+		path := filepath.Join(dir, "conf"+ext)
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+		v, err := setting.Load[typesConf](setting.WithFile(path, "app"), setting.WithSources(setting.OriginFile),
+			setting.WithStrict(true))
+		if assert.Nil(t, err, ext) {
+			checkTypesConf(t, v, ext)
+		}
+	}
+}
+
+func TestTypes_envsAndArgs(t *testing.T) {
+	envs := map[string]string{
+		"TYPES_TIMEOUT":   "5s",
+		"TYPES_MAX_BODY":  "10MB",
+		"TYPES_ALLOW":     "10.0.0.0/8,192.168.1.1",
+		"TYPES_LISTEN":    "127.0.0.1:9090",
+		"TYPES_UPSTREAM":  "http://backend:8000/api",
+		"TYPES_PATH":      "^/v[0-9]+/",
+		"TYPES_ZONE":      "UTC",
+		"TYPES_MODE":      "0600",
+		"TYPES_SAMPLING":  "99%",
+		"TYPES_NOT_FIELD": "x",
+	}
+	for name, value := range envs {
+		os.Setenv(name, value)
+	}
+	defer func() {
+		for name := range envs {
+			os.Unsetenv(name)
+		}
+	}()
+	args := os.Args
+	os.Args = []string{args[0], "--types.sampling=25%"}
+	defer func() { os.Args = args }()
+
+	v, err := setting.Load[typesConf](setting.WithEnvPrefix("TYPES"), setting.WithArgsPrefix("types"),
+		setting.WithSources(setting.OriginEnv, setting.OriginFlag))
+	if assert.Nil(t, err) {
+		checkTypesConf(t, v, "envs")
+	}
+}
+
+func TestTypes_defaultsAndValidate(t *testing.T) {
+	v, err := setting.Load[typesConf](setting.WithSources())
+	if assert.Nil(t, err) {
+		assert.Equal(t, 3*time.Second, v.Timeout.Std())
+		assert.Equal(t, MegaByte, v.MaxBody)
+		assert.Equal(t, 0.1, v.Sampling.Fraction())
+		assert.Equal(t, HostPort{Port: 8080}, v.Listen)
+		assert.Equal(t, FileMode(0644), v.Mode)
+		assert.True(t, v.Zone.Get() != nil)
+	}
+
+	// 校验参数按类型自己的写法
+	assert.NotNil(t, setting.Validate(&typesConf{MaxBody: 512}))
+	assert.NotNil(t, setting.Validate(&typesConf{MaxBody: 2 * GigaByte}))
+	assert.NotNil(t, setting.Validate(&typesConf{MaxBody: KiloByte, Sampling: 120}))
+	assert.Nil(t, setting.Validate(&typesConf{MaxBody: KiloByte, Sampling: 100}))
+
+	// 加载失败时不修改原来的值
+	dir, err := ioutil.TempDir("", "setting-types")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.json")
+	for _, content := range []string{
+		`{"maxBody": "10XB"}`, `{"allow": ["10.0.0.0/33"]}`, `{"listen": "localhost"}`, `{"upstream": "/api"}`,
+		`{"path": "a(b"}`, `{"zone": "Mars/Olympus"}`, `{"mode": "0999"}`, `{"sampling": "abc"}`, `{"timeout": "3 days"}`,
+		`{"maxBody": "2GB"}`,
+	} {
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+		_, err := setting.Load[typesConf](setting.WithFile(path), setting.WithSources(setting.OriginFile))
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestTypes_schema(t *testing.T) {
+	schema := setting.GenerateSchema("types", new(typesConf))
+	props := schema.Properties
+	assert.Equal(t, "string", props["timeout"].Type)
+	assert.Equal(t, "3s", props["timeout"].Default)
+	assert.Equal(t, "string", props["maxBody"].Type)
+	assert.Equal(t, "1MB", props["maxBody"].Default)
+	assert.Nil(t, props["maxBody"].Minimum)
+	assert.Equal(t, "10%", props["sampling"].Default)
+	assert.Equal(t, "string", props["allow"].Items.Type)
+	assert.Equal(t, ":8080", props["listen"].Default)
+	assert.Equal(t, "uri", props["upstream"].Format)
+	assert.Equal(t, "regex", props["path"].Format)
+	assert.Equal(t, "0644", props["mode"].Default)
+
+	for content, n := range map[string]int{
+		`{"maxBody": "10MB", "sampling": 25, "allow": ["10.0.0.0/8"], "mode": "rw-r--r--"}`:    0,
+		`{"maxBody": "10 potatoes", "listen": "localhost", "upstream": "/api", "path": "a(b"}`: 4,
+		`{"timeout": "3 days", "sampling": "a%", "allow": ["host/8"], "mode": "0999"}`:         4,
+	} {
+		var node interface{}
+		assert.Nil(t, json.Unmarshal([]byte(content), &node))
+		assert.Len(t, schema.Check(node, true), n, content)
+	}
+}
@@ -0,0 +1,132 @@
+package types
+
+import (
+	"net"
+	"net/url"
+	"qing/go-helper/error"
+	"qing/go-helper/setting"
+	"strconv"
+	"strings"
+)
+
+// 网段, 比如 "10.0.0.0/8", "fd00::/8"; 单独的 IP 视为 /32 (IPv6 为 /128)
+type CIDR struct {
+	net.IPNet
+}
+
+func (c CIDR) String() string {
+	if c.IP == nil {
+		return ""
+	}
+	return c.IPNet.String()
+}
+
+func (c CIDR) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *CIDR) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return errPkg.Fail("parse cidr fail.", errPkg.Fields{"found": s})
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		c.IPNet = net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		return nil
+	}
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return errPkg.FailBy(err, "parse cidr fail.", errPkg.Fields{"found": s})
+	}
+	c.IPNet = *ipNet
+	return nil
+}
+
+func (c *CIDR) UnmarshalJSON(data []byte) error {
+	return unmarshalJSON(data, c.UnmarshalText)
+}
+
+func (CIDR) JSONSchema() *setting.JSONSchema {
+	return &setting.JSONSchema{Type: "string", Pattern: `^[0-9a-fA-F.:]+(/[0-9]{1,3})?$`}
+}
+
+// 监听或者连接的地址, 比如 "127.0.0.1:8080", "[::1]:80", ":8080" (Host 为空)
+type HostPort struct {
+	Host string
+	Port int
+}
+
+func (h HostPort) String() string {
+	if h.Host == "" && h.Port == 0 {
+		return ""
+	}
+	return net.JoinHostPort(h.Host, strconv.Itoa(h.Port))
+}
+
+func (h HostPort) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+func (h *HostPort) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return errPkg.FailBy(err, "parse host:port fail.", errPkg.Fields{"found": s})
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return errPkg.Fail("port must be in [0, 65535].", errPkg.Fields{"found": s})
+	}
+	h.Host, h.Port = host, n
+	return nil
+}
+
+func (h *HostPort) UnmarshalJSON(data []byte) error {
+	return unmarshalJSON(data, h.UnmarshalText)
+}
+
+func (HostPort) JSONSchema() *setting.JSONSchema {
+	return &setting.JSONSchema{Type: "string", Pattern: `^(\[[0-9a-fA-F:.]+\]|[^:\s]*):[0-9]{1,5}$`}
+}
+
+// 带 scheme 的绝对 URL, 比如 "https://example.com/api", "mysql://user@host:3306/db"
+type URL struct {
+	*url.URL
+}
+
+func (u URL) String() string {
+	if u.URL == nil {
+		return ""
+	}
+	return u.URL.String()
+}
+
+func (u URL) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+func (u *URL) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	parsed, err := url.Parse(s)
+	if err != nil {
+		return errPkg.FailBy(err, "parse url fail.", errPkg.Fields{"found": s})
+	}
+	if parsed.Scheme == "" {
+		return errPkg.Fail("url must be absolute.", errPkg.Fields{"found": s})
+	}
+	u.URL = parsed
+	return nil
+}
+
+func (u *URL) UnmarshalJSON(data []byte) error {
+	return unmarshalJSON(data, u.UnmarshalText)
+}
+
+func (URL) JSONSchema() *setting.JSONSchema {
+	return &setting.JSONSchema{Type: "string", Format: "uri"}
+}
@@ -0,0 +1,92 @@
+package types
+
+import (
+	"qing/go-helper/error"
+	"qing/go-helper/model"
+	"qing/go-helper/setting"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 正则表达式, 加载时编译, 写法同 regexp.Compile
+type Regexp struct {
+	*regexp.Regexp
+}
+
+func (r Regexp) String() string {
+	if r.Regexp == nil {
+		return ""
+	}
+	return r.Regexp.String()
+}
+
+func (r Regexp) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Regexp) UnmarshalText(text []byte) error {
+	re, err := regexp.Compile(string(text))
+	if err != nil {
+		return errPkg.FailBy(err, "compile regexp fail.", errPkg.Fields{"found": string(text)})
+	}
+	r.Regexp = re
+	return nil
+}
+
+func (r *Regexp) UnmarshalJSON(data []byte) error {
+	return unmarshalJSON(data, r.UnmarshalText)
+}
+
+func (Regexp) JSONSchema() *setting.JSONSchema {
+	return &setting.JSONSchema{Type: "string", Format: "regex"}
+}
+
+// 时区, 写法同 time.LoadLocation, 比如 "UTC", "Local", "Asia/Shanghai"; "CST" 即 model.CST
+type Location struct {
+	*time.Location
+}
+
+// 没有配置时为 model.CST
+func (l Location) Get() *time.Location {
+	if l.Location == nil {
+		return model.CST
+	}
+	return l.Location
+}
+
+func (l Location) String() string {
+	if l.Location == nil {
+		return ""
+	}
+	if l.Location == model.CST {
+		return "CST"
+	}
+	return l.Location.String()
+}
+
+func (l Location) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *Location) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if s == "CST" {
+		l.Location = model.CST
+		return nil
+	}
+	loc, err := time.LoadLocation(s)
+	if err != nil {
+		return errPkg.FailBy(err, "load time location fail.", errPkg.Fields{"found": s})
+	}
+	l.Location = loc
+	return nil
+}
+
+func (l *Location) UnmarshalJSON(data []byte) error {
+	return unmarshalJSON(data, l.UnmarshalText)
+}
+
+func (Location) JSONSchema() *setting.JSONSchema {
+	return &setting.JSONSchema{Type: "string"}
+}
@@ -0,0 +1,305 @@
+// 配置中常用的值类型, 在所有格式 (json, toml, ini, properties, .env) 和所有源 (环境变量, 命令行参数, default tag)
+// 中写法相同, 并且 setting.GenerateSchema 可以给出它们的 JSON Schema:
+//
+//   type ServerConf struct {
+//     Listen   types.HostPort `json:"listen" default:":8080"`
+//     Timeout  types.Duration `json:"timeout" default:"3s"`
+//     MaxBody  types.ByteSize `json:"maxBody" default:"10MB" validate:"max=1GB"`
+//     Sampling types.Percent  `json:"sampling" default:"10%"`
+//     Allow    []types.CIDR   `json:"allow"`
+//   }
+//
+// 数值类型 (Duration, ByteSize, Percent) 的 min, max 校验参数也按它自己的写法, 比如 min=1KB
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"qing/go-helper/error"
+	"qing/go-helper/setting"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 和 time.Duration 相同, 整数表示纳秒, 其他写法同 time.ParseDuration, 比如 "1h30m", "500ms"
+type Duration time.Duration
+
+const durationPattern = `^(-?[0-9]+|-?([0-9]*\.?[0-9]+(ns|us|µs|ms|s|m|h))+)$`
+
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		*d = Duration(n)
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return errPkg.FailBy(err, "parse duration fail.", errPkg.Fields{"found": s})
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	return unmarshalJSON(data, d.UnmarshalText)
+}
+
+func (Duration) JSONSchema() *setting.JSONSchema {
+	return &setting.JSONSchema{Type: "string", Pattern: durationPattern}
+}
+
+// 字节数, 单位 B, K(B), M(B), G(B), T(B), P(B) 都按 1024 进位, 不区分大小写, 也可以写作 KiB, MiB ...
+// 比如 "512", "10MB", "1.5g", "64 KiB"
+type ByteSize int64
+
+const (
+	Byte     ByteSize = 1
+	KiloByte          = Byte << 10
+	MegaByte          = KiloByte << 10
+	GigaByte          = MegaByte << 10
+	TeraByte          = GigaByte << 10
+	PetaByte          = TeraByte << 10
+)
+
+var byteUnits = []struct {
+	name string
+	size ByteSize
+}{
+	{"PB", PetaByte}, {"TB", TeraByte}, {"GB", GigaByte}, {"MB", MegaByte}, {"KB", KiloByte}, {"B", Byte},
+}
+
+const byteSizePattern = `^\s*[0-9]*\.?[0-9]+\s*([kKmMgGtTpP]([iI]?[bB])?|[bB])?\s*$`
+
+func (b ByteSize) Int64() int64 {
+	return int64(b)
+}
+
+// 用能整除的最大单位, 比如 "10MB", "1500B"
+func (b ByteSize) String() string {
+	if b == 0 {
+		return "0B"
+	}
+	for _, unit := range byteUnits {
+		if b%unit.size == 0 {
+			return fmt.Sprintf("%d%s", b/unit.size, unit.name)
+		}
+	}
+	return fmt.Sprintf("%dB", int64(b))
+}
+
+func (b ByteSize) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	number, unit := s, ""
+	if i >= 0 {
+		number, unit = s[:i], strings.ToUpper(strings.TrimSpace(s[i:]))
+	}
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return errPkg.FailBy(err, "parse byte size fail.", errPkg.Fields{"found": s})
+	}
+
+	size := ByteSize(0)
+	unit = strings.TrimSuffix(strings.Replace(unit, "IB", "B", 1), "B")
+	switch unit {
+	case "":
+		size = Byte
+	case "K":
+		size = KiloByte
+	case "M":
+		size = MegaByte
+	case "G":
+		size = GigaByte
+	case "T":
+		size = TeraByte
+	case "P":
+		size = PetaByte
+	default:
+		return errPkg.Fail("unknown byte size unit.", errPkg.Fields{"found": s})
+	}
+	f *= float64(size)
+	if f >= math.MaxInt64 {
+		return errPkg.Fail("byte size overflows int64.", errPkg.Fields{"found": s})
+	}
+	*b = ByteSize(f)
+	return nil
+}
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	return unmarshalJSON(data, b.UnmarshalText)
+}
+
+func (ByteSize) JSONSchema() *setting.JSONSchema {
+	return &setting.JSONSchema{Type: "string", Pattern: byteSizePattern}
+}
+
+// 百分比, 保存的是百分数: "20%" 和 20 都是 20, 用 Fraction 得到 0.2
+type Percent float64
+
+const percentPattern = `^\s*-?[0-9]*\.?[0-9]+\s*%?\s*$`
+
+func (p Percent) Fraction() float64 {
+	return float64(p) / 100
+}
+
+func (p Percent) String() string {
+	return strconv.FormatFloat(float64(p), 'f', -1, 64) + "%"
+}
+
+func (p Percent) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Percent) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	f, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "%")), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return errPkg.Fail("parse percent fail.", errPkg.Fields{"found": s})
+	}
+	*p = Percent(f)
+	return nil
+}
+
+func (p *Percent) UnmarshalJSON(data []byte) error {
+	return unmarshalJSON(data, p.UnmarshalText)
+}
+
+func (Percent) JSONSchema() *setting.JSONSchema {
+	return &setting.JSONSchema{Type: "string", Pattern: percentPattern}
+}
+
+// 文件权限, 八进制 "0644", "644", "0o644", 或者 ls -l 的写法 "rw-r--r--", "-rw-r--r--"
+// 在 json, toml 中写作数字时, 数字的每一位也按八进制, 即 644 和 "0644" 相同
+type FileMode os.FileMode
+
+const fileModePattern = `^(0[oO]?)?[0-7]{1,4}$|^[-dl]?([r-][w-][xsS-]){2}[r-][w-][xtT-]$`
+
+func (m FileMode) Perm() os.FileMode {
+	return os.FileMode(m).Perm()
+}
+
+func (m FileMode) String() string {
+	mode := os.FileMode(m)
+	n := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		n |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		n |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		n |= 01000
+	}
+	return fmt.Sprintf("%04o", n)
+}
+
+func (m FileMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *FileMode) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if len(s) == 9 || len(s) == 10 {
+		if mode, ok := parseSymbolicMode(s[len(s)-9:]); ok {
+			*m = FileMode(mode)
+			return nil
+		}
+	}
+	octal := strings.TrimPrefix(strings.TrimPrefix(s, "0o"), "0O")
+	n, err := strconv.ParseUint(octal, 8, 32)
+	if err != nil || n > 07777 {
+		return errPkg.Fail("parse file mode fail.", errPkg.Fields{"found": s})
+	}
+	*m = FileMode(fileModeBits(uint32(n)))
+	return nil
+}
+
+func (m *FileMode) UnmarshalJSON(data []byte) error {
+	return unmarshalJSON(data, m.UnmarshalText)
+}
+
+func (FileMode) JSONSchema() *setting.JSONSchema {
+	return &setting.JSONSchema{Type: "string", Pattern: fileModePattern}
+}
+
+// 八进制的 setuid, setgid, sticky 位转为 os.FileMode 中对应的位
+func fileModeBits(n uint32) os.FileMode {
+	mode := os.FileMode(n & 0777)
+	if n&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if n&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if n&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+func parseSymbolicMode(s string) (os.FileMode, bool) {
+	n := uint32(0)
+	for i, c := range s {
+		bit := uint32(1) << uint(8-i)
+		switch {
+		case c == '-':
+		case c == rune("rwxrwxrwx"[i]):
+			n |= bit
+		case i == 2 && (c == 's' || c == 'S'):
+			n |= 04000
+			if c == 's' {
+				n |= bit
+			}
+		case i == 5 && (c == 's' || c == 'S'):
+			n |= 02000
+			if c == 's' {
+				n |= bit
+			}
+		case i == 8 && (c == 't' || c == 'T'):
+			n |= 01000
+			if c == 't' {
+				n |= bit
+			}
+		default:
+			return 0, false
+		}
+	}
+	return fileModeBits(n), true
+}
+
+// json, toml 中的值: 字符串按 UnmarshalText 解析, 数字等其他值按它的字面量解析; null 不修改
+func unmarshalJSON(data []byte, unmarshalText func(text []byte) error) error {
+	s := strings.TrimSpace(string(data))
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		return unmarshalText([]byte(text))
+	}
+	return unmarshalText([]byte(s))
+}
@@ -0,0 +1,180 @@
+package types
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"qing/go-helper/model"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	var d Duration
+	for text, expected := range map[string]time.Duration{
+		"1h30m": 90 * time.Minute,
+		"500ms": 500 * time.Millisecond,
+		"1000":  1000,
+		" 3s ":  3 * time.Second,
+	} {
+		assert.Nil(t, d.UnmarshalText([]byte(text)), text)
+		assert.Equal(t, expected, d.Std(), text)
+	}
+	assert.NotNil(t, d.UnmarshalText([]byte("3 days")))
+
+	assert.Nil(t, json.Unmarshal([]byte(`"2s"`), &d))
+	assert.Equal(t, 2*time.Second, d.Std())
+	assert.Nil(t, json.Unmarshal([]byte(`5`), &d))
+	assert.Equal(t, Duration(5), d)
+	bs, _ := json.Marshal(Duration(time.Minute))
+	assert.Equal(t, `"1m0s"`, string(bs))
+}
+
+func TestByteSize(t *testing.T) {
+	var b ByteSize
+	for text, expected := range map[string]ByteSize{
+		"512":     512,
+		"512B":    512,
+		"10MB":    10 * MegaByte,
+		"10mb":    10 * MegaByte,
+		"1.5g":    GigaByte + GigaByte/2,
+		"64 KiB":  64 * KiloByte,
+		"2T":      2 * TeraByte,
+		"1PB":     PetaByte,
+		" 0.5K  ": 512,
+	} {
+		assert.Nil(t, b.UnmarshalText([]byte(text)), text)
+		assert.Equal(t, expected, b, text)
+	}
+	for _, text := range []string{"", "MB", "10XB", "-1KB", "1.2.3K", "99999999PB"} {
+		assert.NotNil(t, b.UnmarshalText([]byte(text)), text)
+	}
+
+	assert.Equal(t, "10MB", (10 * MegaByte).String())
+	assert.Equal(t, "1500B", ByteSize(1500).String())
+	assert.Equal(t, "1536KB", (MegaByte + MegaByte/2).String())
+	assert.Equal(t, "0B", ByteSize(0).String())
+
+	assert.Nil(t, json.Unmarshal([]byte(`1024`), &b))
+	assert.Equal(t, KiloByte, b)
+	bs, _ := json.Marshal(struct{ Size ByteSize }{2 * GigaByte})
+	assert.Equal(t, `{"Size":"2GB"}`, string(bs))
+}
+
+func TestPercent(t *testing.T) {
+	var p Percent
+	for text, expected := range map[string]Percent{"20%": 20, "20": 20, "12.5 %": 12.5, "-5%": -5} {
+		assert.Nil(t, p.UnmarshalText([]byte(text)), text)
+		assert.Equal(t, expected, p, text)
+	}
+	for _, text := range []string{"", "%", "abc%", "NaN"} {
+		assert.NotNil(t, p.UnmarshalText([]byte(text)), text)
+	}
+	assert.Equal(t, 0.125, Percent(12.5).Fraction())
+	assert.Equal(t, "12.5%", Percent(12.5).String())
+
+	assert.Nil(t, json.Unmarshal([]byte(`30`), &p))
+	assert.Equal(t, Percent(30), p)
+}
+
+func TestFileMode(t *testing.T) {
+	var m FileMode
+	for text, expected := range map[string]os.FileMode{
+		"0644":       0644,
+		"644":        0644,
+		"0o755":      0755,
+		"rw-r--r--":  0644,
+		"-rwxr-x---": 0750,
+		"drwxrwxrwt": 0777 | os.ModeSticky,
+		"4755":       0755 | os.ModeSetuid,
+	} {
+		assert.Nil(t, m.UnmarshalText([]byte(text)), text)
+		assert.Equal(t, expected, os.FileMode(m), text)
+	}
+	for _, text := range []string{"", "0888", "77777", "rw-r--r-x-", "rwxrwxrwz"} {
+		assert.NotNil(t, m.UnmarshalText([]byte(text)), text)
+	}
+	assert.Equal(t, "0640", FileMode(0640).String())
+	assert.Equal(t, "4755", FileMode(0755|os.ModeSetuid).String())
+	assert.Equal(t, os.FileMode(0600), FileMode(0600).Perm())
+
+	// json 中的数字也按八进制
+	assert.Nil(t, json.Unmarshal([]byte(`600`), &m))
+	assert.Equal(t, FileMode(0600), m)
+}
+
+func TestCIDR(t *testing.T) {
+	var c CIDR
+	assert.Nil(t, c.UnmarshalText([]byte("10.0.0.0/8")))
+	assert.True(t, c.Contains([]byte{10, 1, 2, 3}))
+	assert.Equal(t, "10.0.0.0/8", c.String())
+
+	assert.Nil(t, c.UnmarshalText([]byte("192.168.1.1")))
+	assert.Equal(t, "192.168.1.1/32", c.String())
+	assert.Nil(t, c.UnmarshalText([]byte("::1")))
+	assert.Equal(t, "::1/128", c.String())
+	assert.Nil(t, c.UnmarshalText([]byte("fd00::/8")))
+	assert.Equal(t, "fd00::/8", c.String())
+
+	for _, text := range []string{"", "10.0.0.0/33", "10.0.0", "host/8"} {
+		assert.NotNil(t, c.UnmarshalText([]byte(text)), text)
+	}
+	assert.Equal(t, "", CIDR{}.String())
+}
+
+func TestHostPort(t *testing.T) {
+	var h HostPort
+	for text, expected := range map[string]HostPort{
+		"127.0.0.1:8080": {Host: "127.0.0.1", Port: 8080},
+		"[::1]:80":       {Host: "::1", Port: 80},
+		":8080":          {Port: 8080},
+		"example.com:0":  {Host: "example.com"},
+	} {
+		assert.Nil(t, h.UnmarshalText([]byte(text)), text)
+		assert.Equal(t, expected, h, text)
+	}
+	for _, text := range []string{"", "localhost", "localhost:http", "localhost:65536", "::1:80"} {
+		assert.NotNil(t, h.UnmarshalText([]byte(text)), text)
+	}
+	assert.Equal(t, "[::1]:80", HostPort{Host: "::1", Port: 80}.String())
+	assert.Equal(t, ":8080", HostPort{Port: 8080}.String())
+}
+
+func TestURL(t *testing.T) {
+	var u URL
+	assert.Nil(t, u.UnmarshalText([]byte("mysql://user@host:3306/db?charset=utf8")))
+	assert.Equal(t, "mysql", u.Scheme)
+	assert.Equal(t, "host:3306", u.Host)
+	assert.Equal(t, "mysql://user@host:3306/db?charset=utf8", u.String())
+
+	assert.NotNil(t, u.UnmarshalText([]byte("/relative/path")))
+	assert.NotNil(t, u.UnmarshalText([]byte("http://[::1")))
+	assert.Equal(t, "", URL{}.String())
+}
+
+func TestRegexp(t *testing.T) {
+	var r Regexp
+	assert.Nil(t, json.Unmarshal([]byte(`"^/api/v[0-9]+/"`), &r))
+	assert.True(t, r.MatchString("/api/v2/users"))
+	bs, _ := json.Marshal(r)
+	assert.Equal(t, `"^/api/v[0-9]+/"`, string(bs))
+
+	assert.NotNil(t, r.UnmarshalText([]byte("a(b")))
+	assert.Equal(t, "", Regexp{}.String())
+}
+
+func TestLocation(t *testing.T) {
+	var l Location
+	assert.True(t, model.CST == l.Get())
+
+	assert.Nil(t, l.UnmarshalText([]byte("CST")))
+	assert.True(t, model.CST == l.Location)
+	assert.Equal(t, "CST", l.String())
+
+	assert.Nil(t, l.UnmarshalText([]byte("UTC")))
+	assert.Equal(t, time.UTC, l.Get())
+	assert.Nil(t, l.UnmarshalText([]byte("America/New_York")))
+	assert.Equal(t, "America/New_York", l.String())
+
+	assert.NotNil(t, l.UnmarshalText([]byte("Mars/Olympus")))
+}
@@ -0,0 +1,517 @@
+package setting
+
+import (
+	"encoding"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"qing/go-helper/error"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 通过 tag 声明校验规则, 多个规则以逗号分隔, 参数中的逗号写作 \,
+//
+//   type Conf struct {
+//     URL      string   `json:"url" validate:"required,url"`
+//     Port     int      `json:"port" validate:"min=1,max=65535"`
+//     Level    string   `json:"level" validate:"oneof=DEBUG INFO WARN ERROR"`
+//     Name     string   `json:"name" validate:"regex=^[a-z]{1\,8}$"`
+//     Addr     string   `json:"addr" validate:"hostport"`
+//     CertFile string   `json:"certFile" validate:"file-exists"`
+//     LogDir   string   `json:"logDir" validate:"dir-writable"`
+//     Hosts    []string `json:"hosts" validate:"min=1,max=3"`
+//     MinConns int      `json:"minConns"`
+//     MaxConns int      `json:"maxConns" validate:"gtefield=MinConns"`
+//     Mode     string   `json:"mode"`
+//     Peers    []string `json:"peers" validate:"required_if=Mode cluster"`
+//   }
+//
+// 规则:
+//   required                   非零值, 数组 / map 非空
+//   min=n, max=n, len=n        数值比较大小, string / 数组 / map 比较长度; time.Duration 的参数可以写作 5s
+//   oneof=a b c                值是其中之一
+//   regex=pattern              匹配正则
+//   url                        带 scheme 和 host 的 URL
+//   hostport                   host:port
+//   file-exists                文件存在 (不是目录)
+//   dir-writable               目录存在并且可写
+//   eqfield, nefield, gtfield, gtefield, ltfield, ltefield=Field
+//                              和同一个 struct 中的另一个字段比较, Field 可以是 key 或字段名
+//   required_with=Field        Field 非零值时必填
+//   required_without=Field     Field 为零值时必填
+//   required_if=Field value    Field 等于 value 时必填
+//
+// 除了 required 系列和 min / max / len, 其余规则在值为零值时不检查; 写错的规则名和不合法的 regex 不论值是什么都会报错
+// 嵌套的 struct, 以及 struct 的数组 / map 会被递归校验, 所有不通过的规则会一次性报告, key 形如 db.replicas[1].url
+const validateTag = "validate"
+
+// 按 validate tag 校验 v, 所有不通过的规则会一次性返回
+// errPkg.Err 的 Fields 中: "violations" 为字段路径 -> 原因 (map[string]string), "keys" 为排序后的字段路径
+// Init 会在所有源之后, CanChecked.Access() 之前调用它
+func Validate(v interface{}) error {
+	if err := checkRuleNames(reflect.TypeOf(v)); err != nil {
+		return err
+	}
+	violations := make(map[string][]string)
+	validateValue(reflect.ValueOf(v), "", violations)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	reasons := make(map[string]string, len(violations))
+	for key, list := range violations {
+		reasons[key] = strings.Join(list, "; ")
+	}
+	return violationsFail("validate confObj fail.", reasons)
+}
+
+func violationsFail(msg string, reasons map[string]string) error {
+	keys := make([]string, 0, len(reasons))
+	for key := range reasons {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return errPkg.Fail(msg, errPkg.Fields{"violations": reasons, "keys": keys})
+}
+
+var knownRules = map[string]bool{
+	"required": true, "required_with": true, "required_without": true, "required_if": true,
+	"min": true, "max": true, "len": true, "oneof": true, "regex": true, "url": true, "hostport": true,
+	"file-exists": true, "dir-writable": true,
+	"eqfield": true, "nefield": true, "gtfield": true, "gtefield": true, "ltfield": true, "ltefield": true,
+}
+
+// 每个类型的检查结果 (error 或 nil), 同一个类型只检查一次
+var ruleNamesChecked sync.Map
+
+// regex 规则的 pattern -> *regexp.Regexp, 在检查类型时编译, 校验时不再重复编译
+var regexCompiled sync.Map
+
+// 检查 t 中 (包括 nil 指针, 空数组中的 struct) 所有 validate tag 的规则名和 regex, key 路径同 DefaultValues
+func checkRuleNames(t reflect.Type) error {
+	if t == nil {
+		return nil
+	}
+	if checked, ok := ruleNamesChecked.Load(t); ok {
+		err, _ := checked.(error)
+		return err
+	}
+	reasons := make(map[string]string)
+	collectUnknownRules(t, "", reasons, make(map[reflect.Type]bool))
+	var err error
+	if len(reasons) > 0 {
+		err = violationsFail("validate tag has invalid rules.", reasons)
+	}
+	ruleNamesChecked.Store(t, err)
+	return err
+}
+
+func collectUnknownRules(t reflect.Type, path string, reasons map[string]string, visiting map[reflect.Type]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		collectUnknownRules(t.Elem(), path+"[]", reasons, visiting)
+		return
+	case reflect.Map:
+		collectUnknownRules(t.Elem(), joinPath(path, "*"), reasons, visiting)
+		return
+	case reflect.Struct:
+	default:
+		return
+	}
+	if visiting[t] {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for _, f := range structFields(t) {
+		key := joinPath(path, f.name)
+		unknown := make([]string, 0)
+		for _, r := range splitRules(f.field.Tag.Get(validateTag)) {
+			if !knownRules[r.name] {
+				unknown = append(unknown, fmt.Sprintf("unknown validate rule %q", r.name))
+			} else if r.name == "regex" {
+				if _, err := compileRegex(r.param); err != nil {
+					unknown = append(unknown, fmt.Sprintf("invalid regex %q: %s", r.param, err.Error()))
+				}
+			}
+		}
+		if len(unknown) > 0 {
+			reasons[key] = strings.Join(unknown, "; ")
+		}
+		collectUnknownRules(f.field.Type, key, reasons, visiting)
+	}
+}
+
+// interface 字段中的值在检查类型时看不到, 这时在校验时编译
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexCompiled.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexCompiled.Store(pattern, re)
+	return re, nil
+}
+
+func validateValue(rv reflect.Value, path string, violations map[string][]string) {
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !rv.IsNil() {
+			validateValue(rv.Elem(), path, violations)
+		}
+
+	case reflect.Struct:
+		for _, f := range structFields(rv.Type()) {
+			field, ok := existingField(rv, f.index)
+			if !ok {
+				continue
+			}
+			key := joinPath(path, f.name)
+			if tag := f.field.Tag.Get(validateTag); tag != "" {
+				for _, rule := range splitRules(tag) {
+					if reason := checkRule(rule, field, rv); reason != "" {
+						violations[key] = append(violations[key], reason)
+					}
+				}
+			}
+			validateValue(field, key, violations)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			validateValue(rv.Index(i), fmt.Sprintf("%s[%d]", path, i), violations)
+		}
+
+	case reflect.Map:
+		iter := rv.MapRange()
+		for iter.Next() {
+			validateValue(iter.Value(), joinPath(path, fmt.Sprint(iter.Key())), violations)
+		}
+	}
+}
+
+// 和 fieldByKey 不同, 不会为 nil 的嵌入指针分配内存
+func existingField(rv reflect.Value, index []int) (reflect.Value, bool) {
+	for _, i := range index {
+		if rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				return reflect.Value{}, false
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(i)
+	}
+	return rv, true
+}
+
+type rule struct {
+	name  string
+	param string
+}
+
+func splitRules(tag string) []rule {
+	rules := make([]rule, 0)
+	var bf strings.Builder
+	flush := func() {
+		text := strings.TrimSpace(bf.String())
+		bf.Reset()
+		if text == "" {
+			return
+		}
+		kv := strings.SplitN(text, "=", 2)
+		r := rule{name: strings.TrimSpace(kv[0])}
+		if len(kv) == 2 {
+			r.param = kv[1]
+		}
+		rules = append(rules, r)
+	}
+	for i := 0; i < len(tag); i++ {
+		switch {
+		case tag[i] == '\\' && i+1 < len(tag) && tag[i+1] == ',':
+			bf.WriteByte(',')
+			i++
+		case tag[i] == ',':
+			flush()
+		default:
+			bf.WriteByte(tag[i])
+		}
+	}
+	flush()
+	return rules
+}
+
+// 返回不通过的原因, 通过时返回 ""
+func checkRule(r rule, field reflect.Value, parent reflect.Value) string {
+	zero := isEmpty(field)
+
+	switch r.name {
+	case "required":
+		if zero {
+			return "required"
+		}
+		return ""
+	case "required_with", "required_without", "required_if":
+		return checkRequiredIf(r, zero, parent)
+	case "min", "max", "len":
+		return checkSize(r, field)
+	case "eqfield", "nefield", "gtfield", "gtefield", "ltfield", "ltefield":
+		return checkCrossField(r, field, parent)
+	}
+
+	if zero {
+		return ""
+	}
+	value := fmt.Sprint(indirect(field).Interface())
+
+	switch r.name {
+	case "oneof":
+		for _, option := range strings.Fields(r.param) {
+			if value == option {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of [%s]", r.param)
+
+	case "regex":
+		re, err := compileRegex(r.param)
+		if err != nil {
+			return fmt.Sprintf("invalid regex %q: %s", r.param, err.Error())
+		}
+		if !re.MatchString(value) {
+			return fmt.Sprintf("must match %s", r.param)
+		}
+
+	case "url":
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return "must be an url with scheme and host"
+		}
+
+	case "hostport":
+		_, port, err := net.SplitHostPort(value)
+		if err != nil {
+			return "must be host:port"
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+			return "port must be in [0, 65535]"
+		}
+
+	case "file-exists":
+		info, err := os.Stat(value)
+		if err != nil {
+			return fmt.Sprintf("file must exist: %s", err.Error())
+		}
+		if info.IsDir() {
+			return "must be a file, not a dir"
+		}
+
+	case "dir-writable":
+		info, err := os.Stat(value)
+		if err != nil {
+			return fmt.Sprintf("dir must exist: %s", err.Error())
+		}
+		if !info.IsDir() {
+			return "must be a dir"
+		}
+		f, err := ioutil.TempFile(value, ".setting-writable-")
+		if err != nil {
+			return fmt.Sprintf("dir must be writable: %s", err.Error())
+		}
+		f.Close()
+		os.Remove(f.Name())
+
+	default:
+		return fmt.Sprintf("unknown validate rule %q", r.name)
+	}
+	return ""
+}
+
+func checkRequiredIf(r rule, zero bool, parent reflect.Value) string {
+	params := strings.Fields(r.param)
+	if len(params) == 0 {
+		return fmt.Sprintf("%s needs a field name", r.name)
+	}
+	other, ok := siblingField(parent, params[0])
+	if !ok {
+		return fmt.Sprintf("%s: field %s not found", r.name, params[0])
+	}
+
+	var needed bool
+	switch r.name {
+	case "required_with":
+		needed = !isEmpty(other)
+	case "required_without":
+		needed = isEmpty(other)
+	case "required_if":
+		if len(params) < 2 {
+			return "required_if needs a field name and a value"
+		}
+		other = indirect(other)
+		needed = other.IsValid() && fmt.Sprint(other.Interface()) == strings.Join(params[1:], " ")
+	}
+	if needed && zero {
+		return fmt.Sprintf("%s %s", r.name, r.param)
+	}
+	return ""
+}
+
+func checkSize(r rule, field reflect.Value) string {
+	field = indirect(field)
+	if !field.IsValid() {
+		return ""
+	}
+	var actual, limit float64
+	var err error
+
+	switch field.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		actual = float64(field.Len())
+		limit, err = strconv.ParseFloat(r.param, 64)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		actual = float64(field.Int())
+		if field.Type() == durationType {
+			var d time.Duration
+			if d, err = toDuration(r.param); err == nil {
+				limit = float64(d)
+			}
+			break
+		}
+		limit, err = numberLimit(r.param, field.Type())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		actual = float64(field.Uint())
+		limit, err = numberLimit(r.param, field.Type())
+	case reflect.Float32, reflect.Float64:
+		actual = field.Float()
+		limit, err = numberLimit(r.param, field.Type())
+	default:
+		return fmt.Sprintf("%s is not supported by %s", r.name, field.Type().String())
+	}
+	if err != nil {
+		return fmt.Sprintf("invalid %s param %q", r.name, r.param)
+	}
+
+	switch {
+	case r.name == "min" && actual < limit:
+		return fmt.Sprintf("must be >= %s", r.param)
+	case r.name == "max" && actual > limit:
+		return fmt.Sprintf("must be <= %s", r.param)
+	case r.name == "len" && actual != limit:
+		return fmt.Sprintf("length must be %s", r.param)
+	}
+	return ""
+}
+
+// 实现了 encoding.TextUnmarshaler 的数值类型, 参数按它自己的写法解析, 比如 ByteSize 的 min=1KB
+func numberLimit(param string, t reflect.Type) (float64, error) {
+	if !reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return strconv.ParseFloat(param, 64)
+	}
+	rv := reflect.New(t)
+	if err := rv.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(param)); err != nil {
+		return 0, err
+	}
+	switch rv = rv.Elem(); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), nil
+	}
+	return rv.Float(), nil
+}
+
+func checkCrossField(r rule, field reflect.Value, parent reflect.Value) string {
+	other, ok := siblingField(parent, r.param)
+	if !ok {
+		return fmt.Sprintf("%s: field %s not found", r.name, r.param)
+	}
+	a, b := indirect(field), indirect(other)
+
+	if r.name == "eqfield" || r.name == "nefield" {
+		equal := a.IsValid() && b.IsValid() && reflect.DeepEqual(a.Interface(), b.Interface())
+		if r.name == "eqfield" && !equal {
+			return fmt.Sprintf("must equal %s", r.param)
+		}
+		if r.name == "nefield" && equal {
+			return fmt.Sprintf("must not equal %s", r.param)
+		}
+		return ""
+	}
+
+	x, ok1 := orderable(a)
+	y, ok2 := orderable(b)
+	if !ok1 || !ok2 {
+		return fmt.Sprintf("%s is not supported between %s and %s", r.name, field.Type(), other.Type())
+	}
+	switch {
+	case r.name == "gtfield" && !(x > y):
+		return fmt.Sprintf("must be > %s", r.param)
+	case r.name == "gtefield" && !(x >= y):
+		return fmt.Sprintf("must be >= %s", r.param)
+	case r.name == "ltfield" && !(x < y):
+		return fmt.Sprintf("must be < %s", r.param)
+	case r.name == "ltefield" && !(x <= y):
+		return fmt.Sprintf("must be <= %s", r.param)
+	}
+	return ""
+}
+
+func orderable(v reflect.Value) (float64, bool) {
+	if !v.IsValid() {
+		return 0, false
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			return float64(t.UnixNano()), true
+		}
+	}
+	return 0, false
+}
+
+// 按 key 或字段名查找同一个 struct 中的字段
+func siblingField(parent reflect.Value, name string) (reflect.Value, bool) {
+	for _, f := range structFields(parent.Type()) {
+		if f.name == name || f.field.Name == name {
+			return existingField(parent, f.index)
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return !v.IsValid() || v.IsZero()
+}
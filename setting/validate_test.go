@@ -0,0 +1,131 @@
+package setting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"qing/go-helper/error"
+	"testing"
+	"time"
+)
+
+type validateReplica struct {
+	URL string `json:"url" validate:"required,url"`
+}
+
+type validateDB struct {
+	Replicas []validateReplica `json:"replicas" validate:"min=1"`
+}
+
+type validateConf struct {
+	Port     int           `json:"port" validate:"min=1,max=65535"`
+	Level    string        `json:"level" validate:"oneof=DEBUG INFO WARN ERROR"`
+	Name     string        `json:"name" validate:"regex=^[a-z]{1\\,4}$"`
+	Addr     string        `json:"addr" validate:"hostport"`
+	CertFile string        `json:"certFile" validate:"file-exists"`
+	LogDir   string        `json:"logDir" validate:"dir-writable"`
+	Timeout  time.Duration `json:"timeout" validate:"max=10s"`
+	MinConns int           `json:"minConns"`
+	MaxConns int           `json:"maxConns" validate:"gtefield=minConns"`
+	Mode     string        `json:"mode"`
+	Peers    []string      `json:"peers" validate:"required_if=Mode cluster"`
+	DB       validateDB    `json:"db"`
+}
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file, _ := ioutil.TempFile(dir, "cert")
+	file.Close()
+
+	conf := &validateConf{
+		Port:     8080,
+		Level:    "INFO",
+		Name:     "abc",
+		Addr:     "localhost:80",
+		CertFile: file.Name(),
+		LogDir:   dir,
+		Timeout:  time.Second,
+		MinConns: 1,
+		MaxConns: 2,
+		DB:       validateDB{Replicas: []validateReplica{{URL: "mysql://a:3306"}}},
+	}
+	assert.Nil(t, Validate(conf))
+
+	conf = &validateConf{
+		Port:     70000,
+		Level:    "TRACE",
+		Name:     "abcdef",
+		Addr:     "localhost",
+		CertFile: dir,
+		LogDir:   file.Name(),
+		Timeout:  time.Minute,
+		MinConns: 3,
+		MaxConns: 2,
+		Mode:     "cluster",
+		DB:       validateDB{Replicas: []validateReplica{{URL: "mysql://a:3306"}, {}}},
+	}
+	err = Validate(conf)
+	known, ok := err.(*errPkg.Err)
+	if !ok {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{
+		"addr", "certFile", "db.replicas[1].url", "level", "logDir", "maxConns", "name", "peers", "port", "timeout",
+	}, known.Fields["keys"])
+	violations := known.Fields["violations"].(map[string]string)
+	assert.Equal(t, "required", violations["db.replicas[1].url"])
+	assert.Equal(t, "must be one of [DEBUG INFO WARN ERROR]", violations["level"])
+	assert.Equal(t, "must be >= minConns", violations["maxConns"])
+
+	assert.NotNil(t, Validate(&validateDB{}), "min=1 on empty slice")
+
+	// 字段名和 Fields 中的 key 相同时不会被覆盖
+	err = Validate(&struct {
+		Keys []string `json:"keys" validate:"required"`
+		Name string   `json:"name" validate:"required"`
+	}{})
+	if known, ok := err.(*errPkg.Err); assert.True(t, ok) {
+		assert.Equal(t, []string{"keys", "name"}, known.Fields["keys"])
+		assert.Equal(t, map[string]string{"keys": "required", "name": "required"}, known.Fields["violations"])
+	}
+}
+
+type unknownRuleConf struct {
+	Name     string `json:"name" validate:"requird"`
+	Replicas []struct {
+		URL string `json:"url" validate:"required,urll"`
+	} `json:"replicas"`
+}
+
+func TestValidate_unknownRule(t *testing.T) {
+	// 值为零值, 数组为空时也报错
+	err := Validate(new(unknownRuleConf))
+	if known, ok := err.(*errPkg.Err); assert.True(t, ok) {
+		assert.Equal(t, []string{"name", "replicas[].url"}, known.Fields["keys"])
+		assert.Equal(t, map[string]string{
+			"name":           `unknown validate rule "requird"`,
+			"replicas[].url": `unknown validate rule "urll"`,
+		}, known.Fields["violations"])
+	}
+	assert.NotNil(t, Validate(&unknownRuleConf{Name: "x"}))
+}
+
+type invalidRegexConf struct {
+	Code string `json:"code" validate:"regex=^[a-z+$"`
+	Name string `json:"name" validate:"regex=^[a-z]+$"`
+}
+
+// 不合法的 regex 和写错的规则名一样, 不论值是什么都会报错; 合法的 regex 只编译一次
+func TestValidate_invalidRegex(t *testing.T) {
+	err := Validate(new(invalidRegexConf))
+	if known, ok := err.(*errPkg.Err); assert.True(t, ok) {
+		assert.Equal(t, []string{"code"}, known.Fields["keys"])
+		assert.Contains(t, known.Fields["violations"].(map[string]string)["code"], `invalid regex "^[a-z+$"`)
+	}
+	_, compiled := regexCompiled.Load("^[a-z]+$")
+	assert.True(t, compiled)
+}
@@ -0,0 +1,505 @@
+package setting
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"qing/go-helper/error"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 配置热加载
+//
+//   var watcher *setting.Watcher
+//
+//   func init() {
+//     conf := new(Conf)
+//     conf.Level = INFO // 默认值
+//     var err error
+//     if watcher, err = setting.Watch(conf, nil); err != nil {
+//       panic(err.Error())
+//     }
+//     watcher.Subscribe(func(old, new *Conf) {
+//       SetLevel(new.Level)
+//     })
+//   }
+//
+//   func current() *Conf {
+//     return watcher.Get().(*Conf)
+//   }
+//
+// 每次重新加载都是在一个新的 confObj 上执行 Init (包括 CanChecked.Access), 成功后原子地替换, 所以 Watch 之后不要再直接
+// 读 v, 而是通过 Get() 拿到最新的值; 新的配置不合法时, 会通过 OnError 报告, 并继续使用上一次成功的配置
+type WatchOptions struct {
+	// 轮询间隔
+	//   不支持 inotify 的平台上, 用于轮询配置文件的变化, 默认 2s
+	//   大于 0 时, 还会按该间隔定期重新加载, 以拉取远程源 (如 Apollo) 的变化
+	Interval time.Duration
+
+	// 文件变化后等待一段时间再重新加载, 以合并编辑器保存文件时的多次写入, 默认 100ms
+	Debounce time.Duration
+
+	// 重新加载失败时的回调, 此时仍然使用上一次成功的配置
+	OnError func(err error)
+}
+
+type Watcher struct {
+	typ     reflect.Type
+	base    reflect.Value
+	opts    WatchOptions
+	plan    loadPlan
+	current atomic.Value
+
+	reloadMu    sync.Mutex
+	subMu       sync.RWMutex
+	subscribers []reflect.Value
+	notify      notifier
+
+	loop *watchLoop
+	// WatchAll 创建的 Watcher 没有自己的 loop, Reload 时重新加载所有注册的 confObj, 见 registry.go
+	reloadAll func() error
+}
+
+// 监听源的变化和定时重新加载
+type watchLoop struct {
+	opts    WatchOptions
+	reload  func()
+	changed chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// 轮询文件的间隔, 见 WatchOptions.Interval
+type pollIntervalKey struct{}
+
+// 内置的源监听时启动的 goroutine 加入 watchLoop 的 wg, close 时等待它们退出, 见 goWatch
+type watchGroupKey struct{}
+
+func newWatchLoop(opts WatchOptions, reload func()) *watchLoop {
+	l := &watchLoop{opts: opts, reload: reload, changed: make(chan struct{}, 1)}
+	ctx := context.WithValue(context.Background(), pollIntervalKey{}, opts.Interval)
+	l.ctx, l.cancel = context.WithCancel(context.WithValue(ctx, watchGroupKey{}, &l.wg))
+	return l
+}
+
+// 在 goroutine 中执行 fn, ctx 来自 watchLoop 时, watchLoop.close 会等待 fn 返回
+func goWatch(ctx context.Context, fn func()) {
+	wg, _ := ctx.Value(watchGroupKey{}).(*sync.WaitGroup)
+	if wg == nil {
+		go fn()
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		fn()
+	}()
+}
+
+func watchOptions(opts *WatchOptions) WatchOptions {
+	result := WatchOptions{}
+	if opts != nil {
+		result = *opts
+	}
+	if result.Debounce <= 0 {
+		result.Debounce = 100 * time.Millisecond
+	}
+	return result
+}
+
+// 对 v 执行 Init, 并开始监听配置的变化
+// v 在 Init 之前的值, 将作为之后每次重新加载的默认值
+func Watch(v interface{}, opts *WatchOptions) (*Watcher, error) {
+	return watch(v, planOf(v), opts)
+}
+
+func watch(v interface{}, plan loadPlan, opts *WatchOptions) (*Watcher, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil, errPkg.Fail("confObj must be a non-nil pointer.", errPkg.Fields{"type": fmt.Sprintf("%T", v)})
+	}
+
+	w := newWatcher(rv, watchOptions(opts))
+	w.plan = plan
+	if err := w.init(v); err != nil {
+		return nil, err
+	}
+	w.current.Store(v)
+
+	w.loop = newWatchLoop(w.opts, w.reload)
+	if err := w.loop.watchSources(v, plan); err != nil {
+		w.loop.close()
+		return nil, err
+	}
+	w.loop.run()
+	return w, nil
+}
+
+// base 是 v 在 Init 之前的值的拷贝
+func newWatcher(base reflect.Value, opts WatchOptions) *Watcher {
+	return &Watcher{typ: base.Type(), base: cloneValue(base), opts: opts}
+}
+
+// 监听 v 使用的源中实现了 WatchableSource 的源
+func (l *watchLoop) watchSources(v interface{}, plan loadPlan) error {
+	ctx := withLoadState(l.ctx, plan, nil)
+	for _, src := range currentSources() {
+		ws, ok := src.(WatchableSource)
+		if !ok || !plan.enabled(src) {
+			continue
+		}
+		if err := ws.Watch(ctx, v, l.notify); err != nil {
+			return errPkg.FailBy(err, "watch source fail.", errPkg.Fields{"source": src.Name()})
+		}
+	}
+	return nil
+}
+
+func (l *watchLoop) notify() {
+	notify(l.changed)
+}
+
+// 源变化时重新加载, Interval 大于 0 时还会定时重新加载
+func (l *watchLoop) run() {
+	l.wg.Add(1)
+	go l.debounce()
+
+	if l.opts.Interval > 0 {
+		l.wg.Add(1)
+		go func() {
+			defer l.wg.Done()
+			ticker := time.NewTicker(l.opts.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-l.ctx.Done():
+					return
+				case <-ticker.C:
+					l.reload()
+				}
+			}
+		}()
+	}
+}
+
+func (l *watchLoop) close() {
+	l.cancel()
+	l.wg.Wait()
+}
+
+// 最新的配置, 类型和传给 Watch 的 v 相同
+func (w *Watcher) Get() interface{} {
+	return w.current.Load()
+}
+
+// 订阅配置的变化, fn 的类型必须是 func(old, new T) 或者 func(old, new T, diff setting.Diff), T 是传给 Watch 的 v 的类型,
+// 比如 func(old, new *Conf); diff 是具体变化的 key, 见 Diff
+// 只有值真正发生变化时才会通知
+func (w *Watcher) Subscribe(fn interface{}) error {
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func || ft.NumIn() < 2 || ft.NumIn() > 3 || ft.NumOut() != 0 ||
+		ft.In(0) != w.typ || ft.In(1) != w.typ || (ft.NumIn() == 3 && ft.In(2) != diffType) {
+		return errPkg.Fail("subscriber must be func(old, new T) or func(old, new T, diff setting.Diff).", errPkg.Fields{
+			"T":    w.typ.String(),
+			"func": ft.String(),
+		})
+	}
+
+	w.subMu.Lock()
+	defer w.subMu.Unlock()
+	w.subscribers = append(w.subscribers, fv)
+	return nil
+}
+
+// 立即重新加载, 新配置不合法时返回 error 并继续使用上一次成功的配置
+// 订阅者在重新加载完成之后才被通知 (见 notifier), 订阅者中也可以调用 Reload
+func (w *Watcher) Reload() error {
+	if w.reloadAll != nil {
+		return w.reloadAll()
+	}
+
+	w.reloadMu.Lock()
+	fresh := cloneValue(w.base)
+	if err := w.init(fresh.Interface()); err != nil {
+		w.reloadMu.Unlock()
+		return errPkg.FailBy(err, "reload config fail, keep the last good one.",
+			errPkg.Fields{"type": w.typ.String()})
+	}
+	w.swap(fresh)
+	w.reloadMu.Unlock()
+	w.notify.drain()
+	return nil
+}
+
+// 同 Init, 但使用 Watch 时确定的加载方式
+func (w *Watcher) init(v interface{}) error {
+	prov, err := initWith(context.Background(), v, w.plan, newLoadSession())
+	if err != nil {
+		return err
+	}
+	recordProvenance(v, prov)
+	return nil
+}
+
+// 替换为新加载的配置, 对订阅者的通知放入 w.notify, 由调用者在释放 reloadMu 之后发出
+func (w *Watcher) swap(fresh reflect.Value) {
+	old := w.current.Load()
+	if reflect.DeepEqual(old, fresh.Interface()) {
+		Forget(fresh.Interface())
+		return
+	}
+	diff := changesOf(fresh.Interface(), snapshotLeaves(old))
+	w.current.Store(fresh.Interface())
+	Forget(old)
+
+	w.subMu.RLock()
+	subscribers := append([]reflect.Value(nil), w.subscribers...)
+	w.subMu.RUnlock()
+	args := []reflect.Value{reflect.ValueOf(old), fresh, reflect.ValueOf(diff)}
+	w.notify.push(func() {
+		for _, fn := range subscribers {
+			fn.Call(args[:fn.Type().NumIn()])
+		}
+	})
+}
+
+// 按重新加载的顺序通知订阅者, 通知时不持有 reloadMu, 所以订阅者中可以再次 Reload
+// 订阅者中 Reload 产生的通知排在队列的后面, 由正在通知的 goroutine 在当前的订阅者返回后发出
+type notifier struct {
+	mu       sync.Mutex
+	pending  []func()
+	draining bool
+}
+
+func (n *notifier) push(fn func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pending = append(n.pending, fn)
+}
+
+// 发出所有排队的通知; 已经有 goroutine 在通知时直接返回
+func (n *notifier) drain() {
+	n.mu.Lock()
+	if n.draining {
+		n.mu.Unlock()
+		return
+	}
+	n.draining = true
+	n.mu.Unlock()
+	defer func() {
+		// 订阅者 panic 时也要恢复, 以免之后的通知不再发出
+		if r := recover(); r != nil {
+			n.mu.Lock()
+			n.draining = false
+			n.mu.Unlock()
+			panic(r)
+		}
+	}()
+
+	for {
+		n.mu.Lock()
+		if len(n.pending) == 0 {
+			n.draining = false
+			n.mu.Unlock()
+			return
+		}
+		fn := n.pending[0]
+		n.pending = n.pending[1:]
+		n.mu.Unlock()
+		fn()
+	}
+}
+
+var diffType = reflect.TypeOf(Diff{})
+
+func (w *Watcher) reload() {
+	if err := w.Reload(); err != nil && w.opts.OnError != nil {
+		w.opts.OnError(err)
+	}
+}
+
+// 停止监听, WatchAll 创建的 Watcher 需要通过 WatchAll 返回的 io.Closer 停止
+func (w *Watcher) Close() error {
+	if w.loop != nil {
+		w.loop.close()
+	}
+	return nil
+}
+
+// 源发生变化时, 等待 Debounce 合并之后的变化, 再重新加载
+func (l *watchLoop) debounce() {
+	defer l.wg.Done()
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-l.changed:
+		}
+
+		timer := time.NewTimer(l.opts.Debounce)
+	wait:
+		for {
+			select {
+			case <-l.ctx.Done():
+				timer.Stop()
+				return
+			case <-l.changed:
+				timer.Reset(l.opts.Debounce)
+			case <-timer.C:
+				break wait
+			}
+		}
+		l.reload()
+	}
+}
+
+// 配置文件, 以及它 include 的文件和 profile 层的文件 (不存在的也监听, 以便发现新建的 conf.local.json)
+// Watch 之后新 include 的文件不会被监听
+func watchedFiles(path string) []string {
+	files := []string{path}
+	if _, loaded, err := loadFileTree(path); err == nil {
+		files = loaded
+	}
+	files = append(files, layerFiles(files[0])...)
+
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(files))
+	for _, file := range files {
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
+		if !seen[file] {
+			seen[file] = true
+			result = append(result, file)
+		}
+	}
+	return result
+}
+
+// 轮询文件的修改时间和大小
+func pollFiles(ctx context.Context, files []string, changed func()) {
+	interval, _ := ctx.Value(pollIntervalKey{}).(time.Duration)
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+
+	type fileStat struct {
+		modTime time.Time
+		size    int64
+	}
+	stat := func(file string) fileStat {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fileStat{size: -1}
+		}
+		return fileStat{info.ModTime(), info.Size()}
+	}
+	stats := make([]fileStat, len(files))
+	for i, file := range files {
+		stats[i] = stat(file)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for i, file := range files {
+				if s := stat(file); !s.modTime.Equal(stats[i].modTime) || s.size != stats[i].size {
+					stats[i] = s
+					changed()
+				}
+			}
+		}
+	}
+}
+
+func notify(changed chan<- struct{}) {
+	select {
+	case changed <- struct{}{}:
+	default:
+	}
+}
+
+// 深拷贝, 重新加载时不能修改 base 和当前配置共享的 map / slice
+func cloneValue(v reflect.Value) reflect.Value {
+	result := reflect.New(v.Type()).Elem()
+	copyValue(result, v)
+	return result
+}
+
+// 没有导出字段的结构体
+func opaqueStruct(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t.NumField() == 0 {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func copyValue(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return
+		}
+		if opaqueStruct(src.Type().Elem()) {
+			// 比如 *time.Location, *regexp.Regexp, 拷贝出来的值不一定可用 (time.Local 延迟初始化), 共享同一个
+			dst.Set(src)
+			return
+		}
+		dst.Set(reflect.New(src.Type().Elem()))
+		copyValue(dst.Elem(), src.Elem())
+	case reflect.Interface:
+		if src.IsNil() {
+			return
+		}
+		elem := reflect.New(src.Elem().Type()).Elem()
+		copyValue(elem, src.Elem())
+		dst.Set(elem)
+	case reflect.Struct:
+		dst.Set(src)
+		for i := 0; i < src.NumField(); i++ {
+			if dst.Field(i).CanSet() {
+				copyValue(dst.Field(i), src.Field(i))
+			}
+		}
+	case reflect.Slice:
+		if src.IsNil() {
+			return
+		}
+		dst.Set(reflect.MakeSlice(src.Type(), src.Len(), src.Len()))
+		for i := 0; i < src.Len(); i++ {
+			copyValue(dst.Index(i), src.Index(i))
+		}
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			copyValue(dst.Index(i), src.Index(i))
+		}
+	case reflect.Map:
+		if src.IsNil() {
+			return
+		}
+		dst.Set(reflect.MakeMapWithSize(src.Type(), src.Len()))
+		iter := src.MapRange()
+		for iter.Next() {
+			elem := reflect.New(src.Type().Elem()).Elem()
+			copyValue(elem, iter.Value())
+			dst.SetMapIndex(iter.Key(), elem)
+		}
+	default:
+		dst.Set(src)
+	}
+}
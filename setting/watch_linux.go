@@ -0,0 +1,84 @@
+package setting
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// 使用 inotify 监听配置文件所在的目录
+// 编辑器保存文件时, 经常是写一个临时文件再 rename 覆盖, 所以监听目录而不是文件本身
+func watchFiles(ctx context.Context, files []string, changed func()) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		goWatch(ctx, func() { pollFiles(ctx, files, changed) })
+		return nil
+	}
+
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_DELETE
+	watched := make(map[string]bool, len(files))
+	dirs := make(map[int32]string)
+	for _, file := range files {
+		watched[file] = true
+		dir := filepath.Dir(file)
+		if containsValue(dirs, dir) {
+			continue
+		}
+		wd, err := syscall.InotifyAddWatch(fd, dir, mask)
+		if err != nil {
+			// include 的目录可能不存在
+			continue
+		}
+		dirs[int32(wd)] = dir
+	}
+	if len(dirs) == 0 {
+		syscall.Close(fd)
+		goWatch(ctx, func() { pollFiles(ctx, files, changed) })
+		return nil
+	}
+
+	// 非阻塞的 fd 交给 runtime 的 poller, Close 可以打断阻塞中的 Read
+	f := os.NewFile(uintptr(fd), "inotify")
+	goWatch(ctx, func() {
+		<-ctx.Done()
+		f.Close()
+	})
+	goWatch(ctx, func() {
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+				offset += syscall.SizeofInotifyEvent + int(event.Len)
+				if watched[filepath.Join(dirs[event.Wd], trimNull(nameBytes))] {
+					changed()
+				}
+			}
+		}
+	})
+	return nil
+}
+
+func containsValue(dirs map[int32]string, dir string) bool {
+	for _, d := range dirs {
+		if d == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func trimNull(bs []byte) string {
+	for i, b := range bs {
+		if b == 0 {
+			return string(bs[:i])
+		}
+	}
+	return string(bs)
+}
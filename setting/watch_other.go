@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package setting
+
+import "context"
+
+// 没有 inotify 的平台, 轮询配置文件的修改时间和大小
+func watchFiles(ctx context.Context, files []string, changed func()) error {
+	goWatch(ctx, func() { pollFiles(ctx, files, changed) })
+	return nil
+}
@@ -0,0 +1,182 @@
+package setting
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type watchConf struct {
+	path  string
+	Level string `json:"level"`
+	Size  int    `json:"size"`
+}
+
+func (conf *watchConf) FromFile() (string, []string) {
+	return conf.path, []string{"log"}
+}
+
+func (conf *watchConf) Access() error {
+	if conf.Size < 0 {
+		return errors.New("size must not be negative")
+	}
+	return nil
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.json")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"log": {"level": "INFO"}}`)
+
+	errs := make(chan error, 10)
+	conf := &watchConf{path: path, Size: 1}
+	watcher, err := Watch(conf, &WatchOptions{
+		Interval: 20 * time.Millisecond,
+		Debounce: 10 * time.Millisecond,
+		OnError:  func(err error) { errs <- err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	assert.Equal(t, &watchConf{path: path, Level: "INFO", Size: 1}, watcher.Get())
+
+	assert.NotNil(t, watcher.Subscribe(func(old, new watchConf) {}), "wrong subscriber type")
+	assert.NotNil(t, watcher.Subscribe(func(old, new *watchConf, diff *Diff) {}), "wrong subscriber type")
+	changes := make(chan [2]*watchConf, 10)
+	assert.Nil(t, watcher.Subscribe(func(old, new *watchConf) {
+		changes <- [2]*watchConf{old, new}
+	}))
+	diffs := make(chan Diff, 10)
+	assert.Nil(t, watcher.Subscribe(func(old, new *watchConf, diff Diff) {
+		diffs <- diff
+	}))
+
+	// ok: 默认值来自 Watch 之前的 conf
+	write(`{"log": {"level": "DEBUG"}}`)
+	select {
+	case change := <-changes:
+		assert.Equal(t, "INFO", change[0].Level)
+		assert.Equal(t, &watchConf{path: path, Level: "DEBUG", Size: 1}, change[1])
+	case <-time.After(3 * time.Second):
+		t.Fatal("no change notified")
+	}
+	diff := <-diffs
+	if assert.Equal(t, 1, len(diff.Changed)) {
+		assert.Equal(t, "level", diff.Changed[0].Key)
+		assert.Equal(t, "INFO", diff.Changed[0].Old)
+		assert.Equal(t, "DEBUG", diff.Changed[0].New)
+		assert.Equal(t, OriginFile, diff.Changed[0].Source.Kind)
+	}
+
+	// Access() fail, keep the last good one
+	write(`{"log": {"level": "WARN", "size": -1}}`)
+	select {
+	case err := <-errs:
+		assert.NotNil(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no error reported")
+	}
+	assert.Equal(t, "DEBUG", watcher.Get().(*watchConf).Level)
+}
+
+// Close 返回时, 监听文件的 goroutine (inotify 或者轮询) 都已经退出
+// 订阅者中可以再次 Reload, 通知按重新加载的顺序发出
+func TestWatcher_reloadInSubscriber(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.json")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"log": {"level": "INFO"}}`)
+
+	watcher, err := Watch(&watchConf{path: path}, &WatchOptions{Debounce: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	levels := make([]string, 0)
+	var nestedErr error
+	assert.Nil(t, watcher.Subscribe(func(old, new *watchConf) {
+		levels = append(levels, new.Level)
+		if new.Level == "DEBUG" {
+			write(`{"log": {"level": "WARN"}}`)
+			nestedErr = watcher.Reload()
+		}
+	}))
+
+	write(`{"log": {"level": "DEBUG"}}`)
+	done := make(chan error, 1)
+	go func() {
+		done <- watcher.Reload()
+	}()
+	select {
+	case err = <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reload in a subscriber deadlocks")
+	}
+	assert.Nil(t, nestedErr)
+	assert.Equal(t, []string{"DEBUG", "WARN"}, levels)
+	assert.Equal(t, "WARN", watcher.Get().(*watchConf).Level)
+}
+
+// 只统计 watchLoop 自己的 goroutine, 不受其他测试留下的 goroutine 影响
+func watchGoroutines() int {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	count := 0
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(g, "setting.goWatch") || strings.Contains(g, "setting.(*watchLoop)") {
+			count++
+		}
+	}
+	return count
+}
+
+func Test_watchLoop_close(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setting-watch")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{}`), 0644))
+
+	for _, opts := range []WatchOptions{{}, {Interval: time.Millisecond}} {
+		before := watchGoroutines()
+		l := newWatchLoop(watchOptions(&opts), func() {})
+		assert.Nil(t, watchFiles(l.ctx, []string{path, filepath.Join(dir, "not-exist", "conf.json")}, l.notify))
+		l.run()
+		assert.True(t, watchGoroutines() > before)
+		l.close()
+		assert.Equal(t, before, watchGoroutines(), "%+v", opts)
+	}
+}